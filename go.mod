@@ -5,6 +5,7 @@ go 1.15
 require (
 	github.com/creachadair/jrpc2 v0.12.0
 	github.com/fsnotify/fsnotify v1.4.9 // indirect
+	github.com/go-logr/logr v0.4.0
 	github.com/golang/protobuf v1.4.2 // indirect
 	github.com/google/uuid v1.2.0
 	github.com/mitchellh/go-homedir v1.1.0
@@ -12,12 +13,14 @@ require (
 	github.com/spf13/pflag v1.0.5
 	github.com/spf13/viper v1.7.1
 	github.com/stretchr/testify v1.4.0
+	go.etcd.io/etcd/api/v3 v3.5.0-pre
 	go.etcd.io/etcd/client/v3 v3.0.0-20210127081512-a4fac14353e7
 	golang.org/x/net v0.0.0-20201202161906-c7110b5ffcbb // indirect
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
 	golang.org/x/sys v0.0.0-20210112080510-489259a85091 // indirect
 	golang.org/x/tools v0.0.0-20201224043029-2b0845dc783e // indirect
 	google.golang.org/genproto v0.0.0-20201210142538-e3217bee35cc // indirect
+	google.golang.org/grpc v1.29.1
 	k8s.io/klog v1.0.0
 )
 
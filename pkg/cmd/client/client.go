@@ -35,6 +35,11 @@ func lock(ctx context.Context, cli *jrpc2.Client, id string) (result interface{}
 	return
 }
 
+func steal(ctx context.Context, cli *jrpc2.Client, id string) (result interface{}, err error) {
+	err = cli.CallResult(ctx, "steal", []string{id}, &result)
+	return
+}
+
 func unlock(ctx context.Context, cli *jrpc2.Client, id string) (result interface{}, err error) {
 	err = cli.CallResult(ctx, "unlock", []string{id}, &result)
 	return
@@ -89,6 +94,16 @@ func main() {
 	} else {
 		log.Printf("lock result=%v", lock)
 	}
+	if lock, err := steal(ctx, cli, "test2"); err != nil {
+		log.Fatalln("steal:", err)
+	} else {
+		log.Printf("steal result=%v", lock)
+	}
+	if lock, err := unlock(ctx, cli, "test2"); err != nil {
+		log.Fatalf("unlock: %v", err)
+	} else {
+		log.Printf("unlock result=%v", lock)
+	}
 	if lock, err := unlock(ctx, cli, "test1"); err != nil {
 		log.Fatalf("unlock: %v", err)
 	} else {
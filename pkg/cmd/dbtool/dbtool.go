@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+
+	"github.com/ibm/ovsdb-etcd/pkg/ovsdb"
+)
+
+const ETCD_LOCALHOST = "localhost:2379"
+
+var (
+	etcdMembers = flag.String("etcd-members", ETCD_LOCALHOST, "ETCD service addresses, separated by ',' ")
+	timeout     = flag.Duration("timeout", time.Minute, "Maximum time of the command")
+
+	dbNamespaces = flag.String("database-namespaces", "", "Etcd namespaces of databases that are shared by several "+
+		"deployments, or of the databases of a deployment, <db-name>:<namespace> separated by ','")
+
+	etcdPrefix         = flag.String("etcd-prefix", "", "Prefix of the etcd keys, for sharing etcd by several deployments")
+	etcdCert           = flag.String("etcd-cert", "", "Client certificate file of etcd SSL connections")
+	etcdKey            = flag.String("etcd-key", "", "Private key file of the etcd client certificate")
+	etcdCACert         = flag.String("etcd-ca-cert", "", "CA certificate file that verifies the etcd members")
+	etcdUser           = flag.String("etcd-user", "", "User of etcd authentication")
+	etcdPassword       = flag.String("etcd-password-file", "", "File of the password of the etcd user")
+	etcdToken          = flag.String("etcd-token-file", "", "File of an etcd authentication token, instead of a user")
+	etcdDialTimeout    = flag.Duration("etcd-dial-timeout", ovsdb.ETCD_DIAL_TIMEOUT, "Timeout of connecting to etcd")
+	etcdRequestTimeout = flag.Duration("etcd-request-timeout", ovsdb.ETCD_REQUEST_TIMEOUT, "Timeout of etcd requests")
+	etcdKeepAlive      = flag.Duration("etcd-keepalive-time", 30*time.Second,
+		"Inactivity time of etcd connections after which they are pinged, 0 disables the pings")
+	etcdKeepAliveTimeout = flag.Duration("etcd-keepalive-timeout", 10*time.Second,
+		"Time to wait for the answer of an etcd connection ping before closing the connection")
+	etcdMaxSendSize = flag.Int("etcd-max-send-size", 0, "Maximum size of etcd requests, the client default if 0")
+	etcdMaxRecvSize = flag.Int("etcd-max-recv-size", 0, "Maximum size of etcd responses, the client default if 0")
+	etcdBackoffBase = flag.Duration("etcd-reconnect-base-delay", 0,
+		"Delay of reconnecting to etcd after the first failure, the gRPC default if 0")
+	etcdBackoffMax = flag.Duration("etcd-reconnect-max-delay", 0,
+		"Maximum delay of reconnecting to etcd, the gRPC default if 0")
+)
+
+const COMMANDS = `Commands:
+  import FILE...   import the databases of standalone or clustered OVSDB files into etcd
+  export DB FILE   export the database to a standalone OVSDB file, which is also a backup
+  restore FILE     restore a backup, if the stored schema has its version and cksum
+
+Flags:
+`
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] command [args]\n\n%s", os.Args[0], COMMANDS)
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	config, err := etcdConfig(strings.Split(*etcdMembers, ","))
+	if err != nil {
+		klog.Fatal(err)
+	}
+	dbServ, err := ovsdb.NewDBServer(config)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	namespaces, err := parseNamespaces(*dbNamespaces)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	if err := dbServ.SetDatabaseNamespaces(namespaces); err != nil {
+		klog.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	args := flag.Args()[1:]
+	switch flag.Arg(0) {
+	case "import":
+		if len(args) == 0 {
+			klog.Fatal("import requires OVSDB files")
+		}
+		for _, fileName := range args {
+			dbName, err := dbServ.ImportFile(ctx, fileName)
+			if err != nil {
+				klog.Fatal(err)
+			}
+			fmt.Printf("Imported %s from %s\n", dbName, fileName)
+		}
+	case "export":
+		if len(args) != 2 {
+			klog.Fatal("export requires a database and a file")
+		}
+		revision, err := dbServ.ExportFile(ctx, args[0], args[1])
+		if err != nil {
+			klog.Fatal(err)
+		}
+		fmt.Printf("Exported %s at etcd revision %d to %s\n", args[0], revision, args[1])
+	case "restore":
+		if len(args) != 1 {
+			klog.Fatal("restore requires a file")
+		}
+		dbName, revision, err := dbServ.RestoreFile(ctx, args[0])
+		if err != nil {
+			klog.Fatal(err)
+		}
+		fmt.Printf("Restored %s from %s, a backup of etcd revision %d\n", dbName, args[0], revision)
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+// etcdConfig returns the configuration of the etcd client by the flags
+func etcdConfig(endpoints []string) (ovsdb.EtcdConfig, error) {
+	config := ovsdb.EtcdConfig{Endpoints: endpoints, Prefix: *etcdPrefix, Username: *etcdUser,
+		DialTimeout: *etcdDialTimeout, RequestTimeout: *etcdRequestTimeout, KeepAliveTime: *etcdKeepAlive,
+		KeepAliveTimeout: *etcdKeepAliveTimeout, MaxSendMsgSize: *etcdMaxSendSize, MaxRecvMsgSize: *etcdMaxRecvSize,
+		ReconnectBaseDelay: *etcdBackoffBase, ReconnectMaxDelay: *etcdBackoffMax}
+	if len(*etcdCert) > 0 || len(*etcdCACert) > 0 {
+		tlsConfig, err := ovsdb.EtcdTLSConfig(*etcdCert, *etcdKey, *etcdCACert)
+		if err != nil {
+			return config, err
+		}
+		config.TLS = tlsConfig
+	}
+	if len(*etcdPassword) > 0 {
+		password, err := ioutil.ReadFile(*etcdPassword)
+		if err != nil {
+			return config, err
+		}
+		config.Password = strings.TrimSpace(string(password))
+	}
+	if len(*etcdToken) > 0 {
+		token, err := ioutil.ReadFile(*etcdToken)
+		if err != nil {
+			return config, err
+		}
+		config.Token = strings.TrimSpace(string(token))
+	}
+	return config, nil
+}
+
+// parseNamespaces parses the namespaces of the databases, <db-name>:<namespace> separated by ','
+func parseNamespaces(list string) (map[string]string, error) {
+	namespaces := map[string]string{}
+	if len(list) == 0 {
+		return namespaces, nil
+	}
+	for _, item := range strings.Split(list, ",") {
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("wrong database namespace %q", item)
+		}
+		namespaces[parts[0]] = parts[1]
+	}
+	return namespaces, nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+
+	"github.com/ibm/ovsdb-etcd/pkg/klogr"
+	"github.com/ibm/ovsdb-etcd/pkg/ovsdb"
+)
+
+// The klog verbosity of the "dbg" vlog level, the debug logs of the server are V(5)
+const VLOG_DEBUG_VERBOSITY = 5
+
+// registerAdminCommands registers the ovsdb-server and vlog commands of ovs-appctl
+func registerAdminCommands(ctx context.Context, ctl *unixctl, dbServ *ovsdb.DBServer, listeners []*remote) {
+	ctl.register("ovsdb-server/show", "", 0, 0, func(args []string) (string, error) {
+		status, err := dbServ.Status(ctx)
+		if err != nil {
+			return "", err
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "server id: %s\nready: %t\nsessions: %d\n", status.ServerID, status.Ready, status.Sessions)
+		if status.CorruptedValues > 0 {
+			fmt.Fprintf(&b, "corrupted values: %d, last key: %s\n", status.CorruptedValues, status.LastCorruptedKey)
+		}
+		for _, db := range status.Databases {
+			fmt.Fprintf(&b, "%s\n  leader: %t\n  monitors: %d\n", db.Name, db.Leader, db.Monitors)
+			if db.Cached {
+				fmt.Fprintf(&b, "  cached rows: %d\n  revision: %d\n", db.Rows, db.Revision)
+			} else {
+				b.WriteString("  not cached\n")
+			}
+		}
+		return b.String(), nil
+	})
+	ctl.register("ovsdb-server/list-dbs", "", 0, 0, func(args []string) (string, error) {
+		dbs, err := dbServ.ListDatabases(ctx)
+		if err != nil {
+			return "", err
+		}
+		return strings.Join(append(dbs, ""), "\n"), nil
+	})
+	ctl.register("ovsdb-server/list-remotes", "", 0, 0, func(args []string) (string, error) {
+		var b strings.Builder
+		for _, r := range listeners {
+			fmt.Fprintln(&b, r)
+		}
+		return b.String(), nil
+	})
+	ctl.register("ovsdb-server/dump-cache", "DB", 1, 1, func(args []string) (string, error) {
+		tables, err := dbServ.DumpCache(args[0])
+		if err != nil {
+			return "", err
+		}
+		var b strings.Builder
+		rows, bytes := 0, 0
+		for _, t := range tables {
+			fmt.Fprintf(&b, "%s: %d rows, %d bytes\n", t.Table, t.Rows, t.Bytes)
+			rows += t.Rows
+			bytes += t.Bytes
+		}
+		fmt.Fprintf(&b, "total: %d rows, %d bytes\n", rows, bytes)
+		return b.String(), nil
+	})
+	ctl.register("ovsdb-server/dump-monitors", "[DB]", 0, 1, func(args []string) (string, error) {
+		var b strings.Builder
+		for _, m := range dbServ.DumpMonitors() {
+			if len(args) > 0 && m.Database != args[0] {
+				continue
+			}
+			fmt.Fprintf(&b, "%s monitor %s of %s: version %d, revision %d, %d pending rows\n", m.Client, m.ID,
+				m.Database, m.Version, m.Revision, m.Pending)
+			tables := make([]string, 0, len(m.Tables))
+			for table := range m.Tables {
+				tables = append(tables, table)
+			}
+			sort.Strings(tables)
+			for _, table := range tables {
+				where := m.Tables[table]
+				if len(where) == 0 {
+					where = "all rows"
+				}
+				fmt.Fprintf(&b, "  %s: %s\n", table, where)
+			}
+		}
+		return b.String(), nil
+	})
+	ctl.register("ovsdb-server/dump-locks", "", 0, 0, func(args []string) (string, error) {
+		var b strings.Builder
+		for _, l := range dbServ.DumpLocks() {
+			if l.Owned {
+				fmt.Fprintf(&b, "%s: owned by %s for %v\n", l.ID, l.Client,
+					time.Since(l.OwnedSince).Round(time.Second))
+			} else {
+				fmt.Fprintf(&b, "%s: %s waits for %v\n", l.ID, l.Client, time.Since(l.WaitStart).Round(time.Second))
+			}
+		}
+		return b.String(), nil
+	})
+	ctl.register("ovsdb-server/compact", "[DB]", 0, 1, func(args []string) (string, error) {
+		// the etcd history is shared by all the databases, so it's compacted regardless of the database
+		revision, err := dbServ.Compact(ctx)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("compacted etcd revision %d\n", revision), nil
+	})
+
+	klogFlags := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(klogFlags)
+	ctl.register("vlog/set", "{LEVEL | MODULE:LEVEL | MODULE:DESTINATION:LEVEL | VERBOSITY}", 1, 1,
+		func(args []string) (string, error) {
+			// the levels apply to all the destinations, and to all the modules if the module is ANY or missing
+			parts := strings.Split(args[0], ":")
+			module := "ANY"
+			if len(parts) > 1 {
+				module = parts[0]
+			}
+			level := parts[len(parts)-1]
+			if module != "ANY" {
+				return "", setModuleVerbosity(module, level)
+			}
+			verbosity, err := vlogVerbosity(level)
+			if err != nil {
+				return "", err
+			}
+			if err := klogFlags.Set("v", strconv.Itoa(verbosity)); err != nil {
+				return "", err
+			}
+			return "", nil
+		})
+	ctl.register("vlog/list", "", 0, 0, func(args []string) (string, error) {
+		var b strings.Builder
+		fmt.Fprintf(&b, "klog verbosity: %s\n", klogFlags.Lookup("v").Value)
+		levels := klogr.Verbosity()
+		for _, module := range ovsdb.LOG_MODULES {
+			if level, ok := levels[module]; ok {
+				fmt.Fprintf(&b, "%s: %d\n", module, level)
+			} else {
+				fmt.Fprintf(&b, "%s: klog\n", module)
+			}
+		}
+		return b.String(), nil
+	})
+}
+
+// vlogVerbosity returns the klog verbosity of a vlog level or of a number
+func vlogVerbosity(level string) (int, error) {
+	switch level {
+	case "off", "emer", "err", "warn", "info":
+		return 0, nil
+	case "dbg":
+		return VLOG_DEBUG_VERBOSITY, nil
+	}
+	v, err := strconv.Atoi(level)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("unknown log level %s", level)
+	}
+	return v, nil
+}
+
+// setModuleVerbosity sets the verbosity of a log module, the "klog" level restores the klog verbosity of the module
+func setModuleVerbosity(module string, level string) error {
+	known := false
+	for _, m := range ovsdb.LOG_MODULES {
+		known = known || m == module
+	}
+	if !known {
+		return fmt.Errorf("unknown log module %s, the modules are %s", module, strings.Join(ovsdb.LOG_MODULES, ", "))
+	}
+	if level == "klog" {
+		klogr.SetVerbosity(module, -1)
+		return nil
+	}
+	verbosity, err := vlogVerbosity(level)
+	if err != nil {
+		return err
+	}
+	klogr.SetVerbosity(module, verbosity)
+	return nil
+}
+
+// parseLogVerbosity sets the verbosity of the log modules of a comma separated list of MODULE:LEVEL
+func parseLogVerbosity(spec string) error {
+	for _, s := range strings.Split(spec, ",") {
+		if len(s) == 0 {
+			continue
+		}
+		parts := strings.Split(s, ":")
+		if len(parts) != 2 {
+			return fmt.Errorf("wrong log verbosity %s, it should be MODULE:LEVEL", s)
+		}
+		if err := setModuleVerbosity(parts[0], parts[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
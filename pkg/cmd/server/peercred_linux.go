@@ -0,0 +1,34 @@
+// +build linux
+
+package main
+
+import (
+	"net"
+	"syscall"
+
+	"k8s.io/klog"
+
+	"github.com/ibm/ovsdb-etcd/pkg/ovsdb"
+)
+
+// peerCredentials returns the credentials of the peer process of a UNIX socket connection
+func peerCredentials(conn *net.UnixConn) *ovsdb.PeerCredentials {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		klog.Warningf("Peer credentials of %v: %v", conn.RemoteAddr(), err)
+		return nil
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err == nil {
+		err = credErr
+	}
+	if err != nil {
+		klog.Warningf("Peer credentials of %v: %v", conn.RemoteAddr(), err)
+		return nil
+	}
+	return &ovsdb.PeerCredentials{PID: int(cred.Pid), UID: int(cred.Uid), GID: int(cred.Gid)}
+}
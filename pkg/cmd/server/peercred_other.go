@@ -0,0 +1,14 @@
+// +build !linux
+
+package main
+
+import (
+	"net"
+
+	"github.com/ibm/ovsdb-etcd/pkg/ovsdb"
+)
+
+// peerCredentials returns nil, the credentials of UNIX socket peers are read only on Linux
+func peerCredentials(conn *net.UnixConn) *ovsdb.PeerCredentials {
+	return nil
+}
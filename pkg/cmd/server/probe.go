@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/channel"
+	"k8s.io/klog"
+)
+
+// activityChannel records the time of the last message that was received from the client, and counts the bytes that
+// are sent to the client
+type activityChannel struct {
+	channel.Channel
+	mu       sync.Mutex
+	lastRecv time.Time
+	// set before the channel is used
+	sent func(bytes int)
+}
+
+func newActivityChannel(ch channel.Channel) *activityChannel {
+	return &activityChannel{Channel: ch, lastRecv: time.Now()}
+}
+
+func (ch *activityChannel) Recv() ([]byte, error) {
+	data, err := ch.Channel.Recv()
+	ch.mu.Lock()
+	ch.lastRecv = time.Now()
+	ch.mu.Unlock()
+	return data, err
+}
+
+func (ch *activityChannel) Send(data []byte) error {
+	err := ch.Channel.Send(data)
+	if err == nil && ch.sent != nil {
+		ch.sent(len(data))
+	}
+	return err
+}
+
+func (ch *activityChannel) idle() time.Duration {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return time.Since(ch.lastRecv)
+}
+
+// inactivityProbe sends an "echo" request to the client when it doesn't send anything during the interval, like
+// ovsdb-server does, and closes the connection if the client doesn't reply during another interval. It returns when
+// done is closed.
+func inactivityProbe(srv *jrpc2.Server, ch *activityChannel, interval time.Duration, done <-chan struct{}) {
+	for {
+		if wait := interval - ch.idle(); wait > 0 {
+			select {
+			case <-time.After(wait):
+				continue
+			case <-done:
+				return
+			}
+		}
+		replied := make(chan error, 1)
+		go func() {
+			_, err := srv.Callback(context.Background(), "echo", []interface{}{})
+			replied <- err
+		}()
+		select {
+		case err := <-replied:
+			if err == jrpc2.ErrConnClosed {
+				return
+			}
+			if err != nil {
+				// an error response is a reply as well, the client is alive
+				klog.V(5).Infof("Inactivity probe returned %v", err)
+			}
+		case <-time.After(interval):
+			klog.Warningf("No reply to the inactivity probe for %v, closing the connection", interval)
+			srv.Stop()
+			return
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net"
+	"net/http"
+
+	"k8s.io/klog"
+
+	"github.com/ibm/ovsdb-etcd/pkg/ovsdb"
+)
+
+// serveHealth serves the probes of orchestrators: /healthz succeeds while the server runs, and /readyz succeeds only
+// when the server is ready to serve clients
+func serveHealth(lst net.Listener, dbServ *ovsdb.DBServer) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !dbServ.Ready() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+	if err := http.Serve(lst, mux); err != nil {
+		klog.V(5).Infof("Health listener returned %v", err)
+	}
+}
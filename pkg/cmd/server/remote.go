@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/creachadair/jrpc2/channel"
+	"k8s.io/klog"
+
+	"github.com/ibm/ovsdb-etcd/pkg/ovsdb"
+)
+
+// A remote is a passive connection method, in the syntax of ovsdb-server --remote:
+//   ptcp:<port>[:<ip>]  listens for TCP connections on the port, on all the addresses if ip is not specified
+//   pssl:<port>[:<ip>]  listens for SSL connections on the port
+//   punix:<file>        listens for connections on the UNIX domain socket file
+// The clients of read-only remotes may only read and monitor the databases.
+type remote struct {
+	network  string
+	address  string
+	ssl      bool
+	readOnly bool
+}
+
+func parseRemote(spec string) (*remote, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 || len(parts[1]) == 0 {
+		return nil, fmt.Errorf("wrong remote %s", spec)
+	}
+	switch parts[0] {
+	case "ptcp", "pssl":
+		portIP := strings.SplitN(parts[1], ":", 2)
+		if _, err := strconv.ParseUint(portIP[0], 10, 16); err != nil {
+			return nil, fmt.Errorf("wrong port of remote %s", spec)
+		}
+		ip := ""
+		if len(portIP) == 2 {
+			// IPv6 addresses are in brackets, e.g. ptcp:6641:[::1]
+			ip = strings.TrimSuffix(strings.TrimPrefix(portIP[1], "["), "]")
+		}
+		return &remote{network: "tcp", address: net.JoinHostPort(ip, portIP[0]), ssl: parts[0] == "pssl"}, nil
+	case "punix":
+		return &remote{network: "unix", address: parts[1]}, nil
+	}
+	return nil, fmt.Errorf("unknown remote type %s", parts[0])
+}
+
+// parseRemotes parses a comma separated list of remotes
+func parseRemotes(specs string) ([]*remote, error) {
+	remotes := []*remote{}
+	for _, spec := range strings.Split(specs, ",") {
+		if spec = strings.TrimSpace(spec); len(spec) == 0 {
+			continue
+		}
+		r, err := parseRemote(spec)
+		if err != nil {
+			return nil, err
+		}
+		remotes = append(remotes, r)
+	}
+	return remotes, nil
+}
+
+// listen listens on the remote, SSL remotes require the TLS configuration
+func (r *remote) listen(tlsConfig *tls.Config) (net.Listener, error) {
+	if r.ssl && tlsConfig == nil {
+		return nil, fmt.Errorf("SSL remote %s requires a private key and a certificate", r.address)
+	}
+	if r.network == "unix" {
+		if err := os.RemoveAll(r.address); err != nil {
+			return nil, err
+		}
+	}
+	lst, err := net.Listen(r.network, r.address)
+	if err != nil {
+		return nil, err
+	}
+	if r.ssl {
+		return tls.NewListener(lst, tlsConfig), nil
+	}
+	return lst, nil
+}
+
+// parseActiveRemote parses an active connection method, in the syntax of ovsdb-server --remote:
+//   tcp:<ip>:<port>  connects to the TCP port
+//   ssl:<ip>:<port>  connects to the SSL port
+//   unix:<file>      connects to the UNIX domain socket file
+func parseActiveRemote(spec string) (*remote, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 || len(parts[1]) == 0 {
+		return nil, fmt.Errorf("wrong remote %s", spec)
+	}
+	switch parts[0] {
+	case "tcp", "ssl":
+		i := strings.LastIndex(parts[1], ":")
+		if i < 0 {
+			return nil, fmt.Errorf("wrong remote %s", spec)
+		}
+		if _, err := strconv.ParseUint(parts[1][i+1:], 10, 16); err != nil {
+			return nil, fmt.Errorf("wrong port of remote %s", spec)
+		}
+		ip := strings.TrimSuffix(strings.TrimPrefix(parts[1][:i], "["), "]")
+		return &remote{network: "tcp", address: net.JoinHostPort(ip, parts[1][i+1:]), ssl: parts[0] == "ssl"}, nil
+	case "unix":
+		return &remote{network: "unix", address: parts[1]}, nil
+	}
+	return nil, fmt.Errorf("unknown remote type %s", parts[0])
+}
+
+// dial connects to an active remote, SSL remotes require the TLS configuration
+func (r *remote) dial(tlsConfig *tls.Config) (channel.Channel, error) {
+	if r.ssl && tlsConfig == nil {
+		return nil, fmt.Errorf("SSL remote %s requires a private key and a certificate", r.address)
+	}
+	conn, err := net.DialTimeout(r.network, r.address, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if r.ssl {
+		config := tlsConfig.Clone()
+		if len(config.ServerName) == 0 {
+			config.ServerName, _, _ = net.SplitHostPort(r.address)
+		}
+		conn = tls.Client(conn, config)
+	}
+	return channel.RawJSON(conn, conn), nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates in %s", caFile)
+	}
+	return pool, nil
+}
+
+// peerIdentity returns the identity of the verified client certificate, or nil if the client didn't send one
+func peerIdentity(state tls.ConnectionState) *ovsdb.ClientIdentity {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := state.VerifiedChains[0][0]
+	identity := &ovsdb.ClientIdentity{CommonName: cert.Subject.CommonName}
+	identity.AltNames = append(identity.AltNames, cert.DNSNames...)
+	identity.AltNames = append(identity.AltNames, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		identity.AltNames = append(identity.AltNames, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		identity.AltNames = append(identity.AltNames, uri.String())
+	}
+	klog.Infof("Client %s authenticated, alternative names %v", identity.CommonName, identity.AltNames)
+	return identity
+}
+
+// String returns the remote in ovsdb-server syntax
+func (r *remote) String() string {
+	spec := "punix:" + r.address
+	if r.network == "tcp" {
+		prefix := "ptcp:"
+		if r.ssl {
+			prefix = "pssl:"
+		}
+		spec = prefix + r.address
+		if host, port, err := net.SplitHostPort(r.address); err == nil {
+			spec = prefix + port
+			if len(host) > 0 {
+				spec += ":" + host
+			}
+		}
+	}
+	if r.readOnly {
+		spec += " (read-only)"
+	}
+	return spec
+}
@@ -2,14 +2,20 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/hex"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/creachadair/jrpc2"
 	"github.com/creachadair/jrpc2/channel"
@@ -18,7 +24,11 @@ import (
 	"github.com/creachadair/jrpc2/server"
 	"k8s.io/klog"
 
+	"github.com/ibm/ovsdb-etcd/pkg/json/OVN_Northbound"
+	"github.com/ibm/ovsdb-etcd/pkg/json/OVN_Southbound"
+	"github.com/ibm/ovsdb-etcd/pkg/json/_Server"
 	"github.com/ibm/ovsdb-etcd/pkg/ovsdb"
+	"github.com/ibm/ovsdb-etcd/pkg/trace"
 )
 
 const UNIX_SOCKET = "/tmp/ovsdb-etcd.sock"
@@ -27,39 +37,292 @@ const ETCD_LOCALHOST = "localhost:2379"
 var (
 	tcpAddress  = flag.String("tcp-address", "", "TCP service address")
 	unixAddress = flag.String("unix-address", "", "UNIX service address")
+	remotes     = flag.String("remotes", "",
+		"Listeners in ovsdb-server syntax, separated by ',': ptcp:<port>[:<ip>], pssl:<port>[:<ip>] or punix:<file>")
+	readOnlyRemotes = flag.String("read-only-remotes", "",
+		"Listeners in the syntax of -remotes, whose clients may only read and monitor the databases")
+	dbNamespaces = flag.String("database-namespaces", "", "Etcd namespaces of databases that are shared by several "+
+		"deployments, or of the databases of a deployment, <db-name>:<namespace> separated by ','")
+	aclFile = flag.String("acl-file", "", "JSON file of the rules that allow clients, by their certificate "+
+		"common name, source network or UNIX user id, to read, write or monitor databases, all is allowed by default")
+	privateKey  = flag.String("private-key", "", "Private key file of SSL remotes")
+	certificate = flag.String("certificate", "", "Certificate file of SSL remotes")
+	caCert      = flag.String("ca-cert", "", "CA certificate file that verifies the certificates of SSL clients")
+	requireCert = flag.Bool("require-client-cert", false, "Reject SSL clients without a certificate signed by -ca-cert")
 	etcdMembers = flag.String("etcd-members", ETCD_LOCALHOST, "ETCD service addresses, separated by ',' ")
 	maxTasks    = flag.Int("max", 1, "Maximum concurrent tasks")
+
+	etcdPrefix         = flag.String("etcd-prefix", "", "Prefix of the etcd keys, for sharing etcd by several deployments")
+	etcdCert           = flag.String("etcd-cert", "", "Client certificate file of etcd SSL connections")
+	etcdKey            = flag.String("etcd-key", "", "Private key file of the etcd client certificate")
+	etcdCACert         = flag.String("etcd-ca-cert", "", "CA certificate file that verifies the etcd members")
+	etcdUser           = flag.String("etcd-user", "", "User of etcd authentication")
+	etcdPassword       = flag.String("etcd-password-file", "", "File of the password of the etcd user")
+	etcdToken          = flag.String("etcd-token-file", "", "File of an etcd authentication token, instead of a user")
+	etcdDialTimeout    = flag.Duration("etcd-dial-timeout", ovsdb.ETCD_DIAL_TIMEOUT, "Timeout of connecting to etcd")
+	etcdRequestTimeout = flag.Duration("etcd-request-timeout", ovsdb.ETCD_REQUEST_TIMEOUT, "Timeout of etcd requests")
+	etcdKeepAlive      = flag.Duration("etcd-keepalive-time", 30*time.Second,
+		"Inactivity time of etcd connections after which they are pinged, 0 disables the pings")
+	etcdKeepAliveTimeout = flag.Duration("etcd-keepalive-timeout", 10*time.Second,
+		"Time to wait for the answer of an etcd connection ping before closing the connection")
+	etcdMaxSendSize = flag.Int("etcd-max-send-size", 0, "Maximum size of etcd requests, the client default if 0")
+	etcdMaxRecvSize = flag.Int("etcd-max-recv-size", 0, "Maximum size of etcd responses, the client default if 0")
+	etcdBackoffBase = flag.Duration("etcd-reconnect-base-delay", 0,
+		"Delay of reconnecting to etcd after the first failure, the gRPC default if 0")
+	etcdBackoffMax = flag.Duration("etcd-reconnect-max-delay", 0,
+		"Maximum delay of reconnecting to etcd, the gRPC default if 0")
+
+	monitorFlushInterval = flag.Duration("monitor-flush-interval", 20*time.Millisecond,
+		"Interval of merging monitor notifications, 0 sends every change immediately")
+	monitorHistorySize = flag.Int("monitor-history-size", 1000,
+		"Number of recent revisions kept in memory for monitor_cond_since requests, 0 disables the history")
+	selectLimit = flag.Int("select-limit", 0,
+		"Maximal number of rows of a select, larger selects fail with \"resources exhausted\", 0 disables the limit")
+	serializableReads = flag.Bool("serializable-reads", false,
+		"Selects that are read from etcd are served by the local etcd member, which may return an older revision")
+	compressValuesAbove = flag.Int("compress-values-above", 0,
+		"Column values longer than the number of bytes are stored compressed by gzip, 0 disables the compression")
+	valueChecksums = flag.Bool("value-checksums", false,
+		"Store the column values with checksums, which are verified when the values are read")
+	valueKeyFile = flag.String("value-key-file", "",
+		"File of the hex encoded AES key by which the column values are encrypted, disabled by default")
+	cachedSelects = flag.Bool("cached-selects", true,
+		"Read the rows of selects from the database caches that are updated by etcd watches, rather than from etcd")
+	maxQueuedNotifications = flag.Int("max-queued-notifications", 1000,
+		"Maximum number of notifications queued for a client, 0 for unlimited")
+	maxQueuedBytes = flag.Int("max-queued-bytes", 64*1024*1024,
+		"Maximum size of the notifications queued for a client, 0 for unlimited")
+	slowClientPolicy = flag.String("slow-client-policy", ovsdb.SLOW_CLIENT_RESYNC,
+		"Handling of clients that exceed the notification limits: block, resync or disconnect")
+	serverName      = flag.String("server-name", "", "Name that identifies the server in etcd, the host name by default")
+	shutdownTimeout = flag.Duration("shutdown-timeout", 10*time.Second,
+		"Maximum time to complete the in-flight requests and notifications when the server shuts down")
+	leaderOnly    = flag.Bool("leader-only", false, "Refuse write transactions of databases that the server doesn't lead")
+	probeInterval = flag.Duration("inactivity-probe", 0,
+		"Interval of client inactivity after which an echo request is sent, 0 disables the probes")
+	relayRemote = flag.String("relay-remote", "",
+		"Upstream server of a relay, in ovsdb-server syntax: tcp:<ip>:<port>, ssl:<ip>:<port> or unix:<file>")
+	syncFrom = flag.String("sync-from", "",
+		"Upstream ovsdb-server whose databases are mirrored into etcd, in the syntax of -relay-remote")
+	migrateSchemas = flag.Bool("migrate-schemas", false,
+		"Replace the schemas that are stored in etcd when their version or cksum differs from the local schemas")
+	bootstrap = flag.Bool("bootstrap", false, "Serve the bundled OVN_Northbound and OVN_Southbound schemas, "+
+		"and create their NB_Global and SB_Global rows on the first start")
+	compactionInterval = flag.Duration("etcd-compaction-interval", 0,
+		"Interval of compacting the etcd history and defragmenting the etcd members, 0 disables the maintenance")
+	historyRetention = flag.Duration("etcd-history-retention", time.Hour,
+		"Period of etcd history that is kept by the compactions, for monitor_cond_since requests")
+	defragQuietRevisions = flag.Int64("etcd-defrag-quiet-revisions", 100, "Maximum number of etcd revisions "+
+		"between the compactions, for defragmenting the etcd members after a compaction, negative disables it")
+	ephemeralTables = flag.String("ephemeral-tables", "",
+		"Tables of rows that are deleted when their clients are lost, <db-name>:<table> separated by ','")
+	etcdHealthInterval = flag.Duration("etcd-health-interval", 5*time.Second,
+		"Interval of checking the health of the etcd endpoints, the unhealthy ones are not used, 0 disables the checks")
+	breakerFailures = flag.Int("etcd-breaker-failures", 5,
+		"Consecutive etcd failures after which transactions fail immediately until etcd is available, 0 disables it")
+	breakerProbeInterval = flag.Duration("etcd-breaker-probe-interval", time.Second,
+		"Interval of probing etcd while transactions fail immediately")
+	etcdWaitTimeout = flag.Duration("etcd-wait-timeout", time.Minute,
+		"Maximum time to wait for etcd when the server starts")
+	healthAddress = flag.String("health-address", "",
+		"HTTP address of the /healthz and /readyz probes, <ip>:<port>, disabled by default")
+	metricsAddress = flag.String("metrics-address", "",
+		"HTTP address of the /metrics of Prometheus, <ip>:<port>, disabled by default")
+	slowTransactionDuration = flag.Duration("slow-transaction-duration", 0,
+		"Transactions that last longer are logged with a summary, 0 disables it")
+	largeTransactionOperations = flag.Int("large-transaction-operations", 0,
+		"Transactions with more operations are logged with a summary, 0 disables it")
+	largeTransactionBytes = flag.Int("large-transaction-bytes", 0,
+		"Transactions whose operations are larger are logged with a summary, 0 disables it")
+	traceFile = flag.String("trace-file", "",
+		"File of the traces of the requests, a JSON array of spans per line, disabled by default")
+	traceSampleRatio = flag.Float64("trace-sample-ratio", 1, "Fraction of the requests that are traced")
+	traceMinDuration = flag.Duration("trace-min-duration", 0,
+		"Minimal duration of the traced requests that are written to the trace file")
+	unixctlPath  = flag.String("unixctl", "", "Control socket of ovs-appctl commands, disabled by default")
+	logVerbosity = flag.String("log-verbosity", "",
+		"Verbosity of log modules, a comma separated list of MODULE:LEVEL, e.g. transact:6,rpc:6")
 )
 
 func main() {
 
 	flag.Parse()
-	if len(*tcpAddress) == 0 && len(*unixAddress) == 0 {
-		klog.Fatal("You must provide a network-address (TCP and/or UNIX) to listen on")
+	if err := parseLogVerbosity(*logVerbosity); err != nil {
+		klog.Fatal(err)
+	}
+	listeners, err := parseRemotes(*remotes)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	readOnlyListeners, err := parseRemotes(*readOnlyRemotes)
+	if err != nil {
+		klog.Fatal(err)
+	}
+	for _, r := range readOnlyListeners {
+		r.readOnly = true
+	}
+	listeners = append(listeners, readOnlyListeners...)
+	if len(*tcpAddress) > 0 {
+		listeners = append(listeners, &remote{network: jrpc2.Network(*tcpAddress), address: *tcpAddress})
+	}
+	if runtime.GOOS == "linux" && len(*unixAddress) > 0 {
+		listeners = append(listeners, &remote{network: jrpc2.Network(*unixAddress), address: *unixAddress})
+	}
+	if len(listeners) == 0 {
+		klog.Fatal("You must provide a network-address (TCP and/or UNIX) or remotes to listen on")
+	}
+	var tlsConfig *tls.Config
+	if len(*privateKey) > 0 || len(*certificate) > 0 {
+		cert, err := tls.LoadX509KeyPair(*certificate, *privateKey)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		if len(*caCert) > 0 {
+			tlsConfig.ClientCAs, err = loadCertPool(*caCert)
+			if err != nil {
+				klog.Fatal(err)
+			}
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+		if *requireCert {
+			if tlsConfig.ClientCAs == nil {
+				klog.Fatal("-require-client-cert requires -ca-cert")
+			}
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
 	}
 
 	if len(*etcdMembers) == 0 {
 		klog.Fatal("Wrong ETCD members list", etcdMembers)
 	}
 	etcdServers := strings.Split(*etcdMembers, ",")
-	dbServ, err := ovsdb.NewDBServer(etcdServers)
+	config, err := etcdConfig(etcdServers)
 	if err != nil {
 		klog.Fatal(err)
 	}
-
-	// For development only
-	err = dbServ.AddSchema("_Server", "./json/_server.ovsschema")
+	dbServ, err := ovsdb.NewDBServer(config)
 	if err != nil {
 		klog.Fatal(err)
 	}
-	err = dbServ.AddSchema("OVN_Northbound", "./json/ovn-nb.ovsschema")
+	if len(*serverName) == 0 {
+		if *serverName, err = os.Hostname(); err != nil {
+			klog.Fatal(err)
+		}
+	}
+	if len(*healthAddress) > 0 {
+		lst, err := net.Listen("tcp", *healthAddress)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		defer lst.Close()
+		go serveHealth(lst, dbServ)
+	}
+	if len(*metricsAddress) > 0 {
+		lst, err := net.Listen("tcp", *metricsAddress)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		defer lst.Close()
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", dbServ.Metrics().Handler())
+		go http.Serve(lst, mux)
+	}
+	namespaces, err := parseNamespaces(*dbNamespaces)
 	if err != nil {
 		klog.Fatal(err)
 	}
-	err = dbServ.LoadServerData()
-	if err != nil {
+	if err := dbServ.SetDatabaseNamespaces(namespaces); err != nil {
 		klog.Fatal(err)
 	}
+	// relays don't use etcd
+	relay := len(*relayRemote) > 0
+	if !relay {
+		waitCtx, waitCancel := context.WithTimeout(context.Background(), *etcdWaitTimeout)
+		err := dbServ.WaitForEtcd(waitCtx)
+		waitCancel()
+		if err != nil {
+			klog.Fatal(err)
+		}
+		if err := dbServ.LoadServerID(*serverName); err != nil {
+			klog.Fatal(err)
+		}
+	}
+	if len(*aclFile) > 0 {
+		rules, err := ovsdb.LoadACL(*aclFile)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		if err := dbServ.SetACL(rules); err != nil {
+			klog.Fatal(err)
+		}
+	}
+	dbServ.SetMonitorFlushInterval(*monitorFlushInterval)
+	dbServ.SetMonitorHistorySize(*monitorHistorySize)
+	dbServ.SetCachedSelects(*cachedSelects)
+	dbServ.SetSelectLimit(*selectLimit)
+	dbServ.SetSerializableReads(*serializableReads)
+	dbServ.SetValueCompression(*compressValuesAbove)
+	dbServ.SetValueChecksums(*valueChecksums)
+	if *valueKeyFile != "" {
+		data, err := ioutil.ReadFile(*valueKeyFile)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil {
+			klog.Fatal("Wrong value encryption key: ", err)
+		}
+		if err := dbServ.SetValueEncryption(key); err != nil {
+			klog.Fatal("Wrong value encryption key: ", err)
+		}
+	}
+	switch *slowClientPolicy {
+	case ovsdb.SLOW_CLIENT_BLOCK, ovsdb.SLOW_CLIENT_RESYNC, ovsdb.SLOW_CLIENT_DISCONNECT:
+	default:
+		klog.Fatal("Wrong slow client policy ", *slowClientPolicy)
+	}
+	dbServ.SetNotificationLimits(ovsdb.NotificationLimits{MaxNotifications: *maxQueuedNotifications,
+		MaxBytes: *maxQueuedBytes, Policy: *slowClientPolicy})
+	dbServ.SetSlowTransactionLimits(ovsdb.SlowTransactionLimits{Duration: *slowTransactionDuration,
+		Operations: *largeTransactionOperations, Bytes: *largeTransactionBytes})
+
+	if *bootstrap {
+		for dbName, schema := range map[string]string{"_Server": _Server.Schema,
+			"OVN_Northbound": OVN_Northbound.Schema, "OVN_Southbound": OVN_Southbound.Schema} {
+			if err := dbServ.AddSchemaData(dbName, []byte(schema)); err != nil {
+				klog.Fatal(err)
+			}
+		}
+	} else {
+		// For development only
+		err = dbServ.AddSchema("_Server", "./json/_server.ovsschema")
+		if err != nil {
+			klog.Fatal(err)
+		}
+		err = dbServ.AddSchema("OVN_Northbound", "./json/ovn-nb.ovsschema")
+		if err != nil {
+			klog.Fatal(err)
+		}
+	}
+	var schemasRevision int64
+	if !relay {
+		// the schemas that are stored in etcd replace the local ones, so all the servers use the same schemas, the
+		// versions of the local schemas are verified against them
+		if schemasRevision, err = dbServ.StoreSchemas(*migrateSchemas); err != nil {
+			klog.Fatal(err)
+		}
+		if *bootstrap {
+			if err := dbServ.StoreServerRows(); err != nil {
+				klog.Fatal(err)
+			}
+			if err := dbServ.Bootstrap(); err != nil {
+				klog.Fatal(err)
+			}
+		} else if err := dbServ.LoadServerData(); err != nil {
+			klog.Fatal(err)
+		}
+	}
 
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
@@ -73,13 +336,108 @@ func main() {
 		signal.Stop(exitCh)
 		cancel()
 	}()
+	serverMetrics := metrics.New()
+	var upstreamTLSConfig *tls.Config
+	if tlsConfig != nil {
+		// the server authenticates to upstream servers with its certificate, and verifies them with the CA certificate
+		upstreamTLSConfig = &tls.Config{Certificates: tlsConfig.Certificates, RootCAs: tlsConfig.ClientCAs}
+	}
+	if relay {
+		if len(*syncFrom) > 0 {
+			klog.Fatal("A relay can't sync from an upstream server")
+		}
+		upstream, err := parseActiveRemote(*relayRemote)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		dbServ.StartRelay(ctx, func() (channel.Channel, error) {
+			return upstream.dial(upstreamTLSConfig)
+		})
+	} else {
+		go dbServ.WatchDatabases(ctx)
+		go dbServ.WatchSchemas(ctx, schemasRevision)
+		if err := dbServ.StartElections(ctx); err != nil {
+			klog.Fatal(err)
+		}
+		dbServ.SetLeaderOnly(*leaderOnly)
+		dbServ.SetCircuitBreaker(*breakerFailures, *breakerProbeInterval)
+		if *etcdHealthInterval > 0 {
+			dbServ.StartHealthCheck(ctx, *etcdHealthInterval, serverMetrics)
+		}
+		tables, err := parseEphemeralTables(*ephemeralTables)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		dbServ.SetEphemeralTables(tables)
+		if *compactionInterval > 0 {
+			err := dbServ.StartMaintenance(ctx, ovsdb.MaintenanceConfig{Interval: *compactionInterval,
+				Retention: *historyRetention, QuietRevisions: *defragQuietRevisions})
+			if err != nil {
+				klog.Fatal(err)
+			}
+		}
+		if len(*syncFrom) > 0 {
+			upstream, err := parseActiveRemote(*syncFrom)
+			if err != nil {
+				klog.Fatal(err)
+			}
+			dbServ.StartSync(ctx, func() (channel.Channel, error) {
+				return upstream.dial(upstreamTLSConfig)
+			})
+		}
+	}
 
 
+	if len(*unixctlPath) > 0 {
+		ctl := newUnixctl()
+		ctl.register("ovsdb-server/add-db", "DB", 1, 1, func(args []string) (string, error) {
+			schema, err := ioutil.ReadFile(args[0])
+			if err != nil {
+				return "", err
+			}
+			_, err = dbServ.AddDatabase(ctx, schema)
+			return "", err
+		})
+		ctl.register("ovsdb-server/remove-db", "DB", 1, 1, func(args []string) (string, error) {
+			return "", dbServ.RemoveDatabase(ctx, args[0])
+		})
+		ctl.register("etcd/show-endpoints", "", 0, 0, func(args []string) (string, error) {
+			var b strings.Builder
+			for _, h := range dbServ.EndpointsHealth() {
+				state := "healthy"
+				if !h.Healthy {
+					state = "unhealthy: " + h.Err
+				}
+				fmt.Fprintf(&b, "%s: %s, latency %v, %d errors\n", h.Endpoint, state, h.Latency, h.Errors)
+			}
+			return b.String(), nil
+		})
+		registerAdminCommands(ctx, ctl, dbServ, listeners)
+		os.Remove(*unixctlPath)
+		lst, err := net.Listen("unix", *unixctlPath)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		defer lst.Close()
+		go ctl.serve(lst)
+	}
+
 	servOptions := &jrpc2.ServerOptions{
-		Concurrency: *maxTasks,
-		Metrics:     metrics.New(),
-		AllowPush:   true,
-		AllowV1:     true,
+		Concurrency:  *maxTasks,
+		Metrics:      serverMetrics,
+		AllowPush:    true,
+		AllowV1:      true,
+		CheckRequest: dbServ.CheckRequest,
+	}
+	if len(*traceFile) > 0 {
+		f, err := os.OpenFile(*traceFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			klog.Fatal(err)
+		}
+		defer f.Close()
+		tracer := &rpcTracer{tracer: trace.NewTracer(*traceSampleRatio, *traceMinDuration, trace.WriterExporter(f))}
+		servOptions.DecodeContext = tracer.decodeContext
+		servOptions.RPCLog = tracer
 	}
 	ovsdbServ := ovsdb.NewService(dbServ)
 	mux := handler.ServiceMap{
@@ -90,39 +448,102 @@ func main() {
 	// a WaitGroup for the goroutines to tell us they've stopped
 	wg := sync.WaitGroup{}
 
-	if len(*tcpAddress) > 0 {
-		lst, err := net.Listen(jrpc2.Network(*tcpAddress), *tcpAddress)
+	lsts := []net.Listener{}
+	for _, r := range listeners {
+		lst, err := r.listen(tlsConfig)
 		if err != nil {
 			klog.Fatalln("Listen:", err)
 		}
-		klog.Infof("Listening at %v...", lst.Addr())
-		//servOptions.Logger = log.New(os.Stderr, "[TCP.Server] ", log.LstdFlags|log.Lshortfile)
-
-		go serverLoop(ctx, lst, srvFunc, servOptions, &wg)
-	}
-	if runtime.GOOS == "linux" && len(*unixAddress) > 0 {
-		if err := os.RemoveAll(*unixAddress); err != nil {
-			klog.Fatal(err)
+		if r.readOnly {
+			klog.Infof("Listening at %v, read-only...", lst.Addr())
+		} else {
+			klog.Infof("Listening at %v...", lst.Addr())
 		}
-		lst, err := net.Listen(jrpc2.Network(*unixAddress), *unixAddress)
-		if err != nil {
-			klog.Fatalln("Listen:", err)
-		}
-		klog.Infof("Listening at %v...", lst.Addr())
-		//servOptions.Logger = log.New(os.Stderr, "[UNIX.Server] ", log.LstdFlags|log.Lshortfile)
-		go serverLoop(ctx, lst, srvFunc, servOptions, &wg)
+		lsts = append(lsts, lst)
+		go serverLoop(ctx, lst, r.readOnly, srvFunc, servOptions, dbServ, &wg)
 	}
+	// the schemas are loaded and the listeners accept clients
+	dbServ.SetReady(true)
 
 	select {
 	case s := <-exitCh:
 		klog.Infof("Received signal %s. Shutting down", s)
+		// stop accepting new connections, and let the current ones complete their work
+		for _, lst := range lsts {
+			lst.Close()
+		}
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		dbServ.Shutdown(shutdownCtx)
+		shutdownCancel()
 		cancel()
 	case <-ctx.Done():
 	}
 
 }
 
-func serverLoop(ctx context.Context, lst net.Listener, newService func() server.Service, serverOpts *jrpc2.ServerOptions, wg *sync.WaitGroup)  error {
+// etcdConfig returns the configuration of the etcd client by the flags
+func etcdConfig(endpoints []string) (ovsdb.EtcdConfig, error) {
+	config := ovsdb.EtcdConfig{Endpoints: endpoints, Prefix: *etcdPrefix, Username: *etcdUser,
+		DialTimeout: *etcdDialTimeout, RequestTimeout: *etcdRequestTimeout, KeepAliveTime: *etcdKeepAlive,
+		KeepAliveTimeout: *etcdKeepAliveTimeout, MaxSendMsgSize: *etcdMaxSendSize, MaxRecvMsgSize: *etcdMaxRecvSize,
+		ReconnectBaseDelay: *etcdBackoffBase, ReconnectMaxDelay: *etcdBackoffMax}
+	if len(*etcdCert) > 0 || len(*etcdCACert) > 0 {
+		tlsConfig, err := ovsdb.EtcdTLSConfig(*etcdCert, *etcdKey, *etcdCACert)
+		if err != nil {
+			return config, err
+		}
+		config.TLS = tlsConfig
+	}
+	if len(*etcdPassword) > 0 {
+		password, err := ioutil.ReadFile(*etcdPassword)
+		if err != nil {
+			return config, err
+		}
+		config.Password = strings.TrimSpace(string(password))
+	}
+	if len(*etcdToken) > 0 {
+		token, err := ioutil.ReadFile(*etcdToken)
+		if err != nil {
+			return config, err
+		}
+		config.Token = strings.TrimSpace(string(token))
+	}
+	return config, nil
+}
+
+// parseEphemeralTables parses a list of <db-name>:<table> separated by ','
+func parseEphemeralTables(list string) (map[string][]string, error) {
+	tables := map[string][]string{}
+	if len(list) == 0 {
+		return tables, nil
+	}
+	for _, item := range strings.Split(list, ",") {
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("wrong ephemeral table %q", item)
+		}
+		tables[parts[0]] = append(tables[parts[0]], parts[1])
+	}
+	return tables, nil
+}
+
+// parseNamespaces parses the namespaces of the databases, <db-name>:<namespace> separated by ','
+func parseNamespaces(list string) (map[string]string, error) {
+	namespaces := map[string]string{}
+	if len(list) == 0 {
+		return namespaces, nil
+	}
+	for _, item := range strings.Split(list, ",") {
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("wrong database namespace %q", item)
+		}
+		namespaces[parts[0]] = parts[1]
+	}
+	return namespaces, nil
+}
+
+func serverLoop(ctx context.Context, lst net.Listener, readOnly bool, newService func() server.Service, serverOpts *jrpc2.ServerOptions, dbServ *ovsdb.DBServer, wg *sync.WaitGroup)  error {
 	for {
 		conn, err := lst.Accept()
 		if err != nil {
@@ -134,21 +555,48 @@ func serverLoop(ctx context.Context, lst net.Listener, newService func() server.
 			wg.Wait()
 			return err
 		}
-		ch := channel.RawJSON(conn, conn)
+		ch := newActivityChannel(channel.RawJSON(conn, conn))
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
+			clientConn := ovsdb.ClientConnection{ReadOnly: readOnly}
+			if addr := conn.RemoteAddr(); addr != nil {
+				clientConn.Address = addr.String()
+			}
+			if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+				clientConn.IP = addr.IP
+			}
+			if unixConn, ok := conn.(*net.UnixConn); ok {
+				clientConn.Peer = peerCredentials(unixConn)
+			}
+			if tlsConn, ok := conn.(*tls.Conn); ok {
+				tlsConn.SetDeadline(time.Now().Add(10 * time.Second))
+				if err := tlsConn.Handshake(); err != nil {
+					klog.Warningf("SSL handshake with %v failed: %v", conn.RemoteAddr(), err)
+					conn.Close()
+					return
+				}
+				tlsConn.SetDeadline(time.Time{})
+				clientConn.Identity = peerIdentity(tlsConn.ConnectionState())
+			}
 			svc := newService()
 			assigner, err := svc.Assigner()
 			if err != nil {
 				klog.Errorf("Service initialization failed: %v", err)
 				return
 			}
-			srv := jrpc2.NewServer(assigner, serverOpts).Start(ch)
+			ch.sent = dbServ.SentBytesCounter(&clientConn)
+			srv := jrpc2.NewServer(assigner, serverOpts)
+			dbServ.AddClient(srv, ch, clientConn)
 			// create and init OVSD service
 			// Bind the methods of the math type to an assigner.
 
+			done := make(chan struct{})
+			if *probeInterval > 0 {
+				go inactivityProbe(srv, ch, *probeInterval, done)
+			}
 			stat := srv.WaitStatus()
+			close(done)
 			svc.Finish(stat)
 			if stat.Err != nil {
 				klog.Infof("Server exit: %v", stat.Err)
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/creachadair/jrpc2"
+
+	"github.com/ibm/ovsdb-etcd/pkg/trace"
+)
+
+// rpcTracer starts the root span of a trace for every JSON-RPC request, and ends it when the response is sent
+type rpcTracer struct {
+	tracer *trace.Tracer
+}
+
+// decodeContext is the DecodeContext of the server options, it starts the span of the request
+func (t *rpcTracer) decodeContext(ctx context.Context, method string, params json.RawMessage) (context.Context,
+	json.RawMessage, error) {
+	ctx, span := t.tracer.Start(ctx, "rpc "+method)
+	span.SetAttribute("params.bytes", len(params))
+	return ctx, params, nil
+}
+
+func (t *rpcTracer) LogRequest(ctx context.Context, req *jrpc2.Request) {
+	trace.FromContext(ctx).SetAttribute("id", req.ID())
+}
+
+func (t *rpcTracer) LogResponse(ctx context.Context, rsp *jrpc2.Response) {
+	var err error
+	if rsp.Error() != nil {
+		err = rsp.Error()
+	}
+	trace.FromContext(ctx).End(err)
+}
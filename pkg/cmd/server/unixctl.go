@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/klog"
+)
+
+type unixctlCommand struct {
+	usage   string
+	minArgs int
+	maxArgs int
+	handler func(args []string) (string, error)
+}
+
+// unixctl is a control socket that is compatible with ovs-appctl, its requests are JSON-RPC 1.0 requests whose method
+// is the command name and whose params are the command arguments, and the results and the errors are strings.
+type unixctl struct {
+	mu       sync.RWMutex
+	commands map[string]*unixctlCommand
+}
+
+type unixctlRequest struct {
+	Method string          `json:"method"`
+	Params []string        `json:"params"`
+	Id     json.RawMessage `json:"id"`
+}
+
+type unixctlReply struct {
+	Result *string         `json:"result"`
+	Error  *string         `json:"error"`
+	Id     json.RawMessage `json:"id"`
+}
+
+func newUnixctl() *unixctl {
+	u := &unixctl{commands: map[string]*unixctlCommand{}}
+	u.register("list-commands", "", 0, 0, func(args []string) (string, error) {
+		u.mu.RLock()
+		defer u.mu.RUnlock()
+		names := make([]string, 0, len(u.commands))
+		for name := range u.commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var b strings.Builder
+		b.WriteString("The available commands are:\n")
+		for _, name := range names {
+			fmt.Fprintf(&b, "  %-23s %s\n", name, u.commands[name].usage)
+		}
+		return b.String(), nil
+	})
+	return u
+}
+
+// register adds a command with the given usage and number of arguments
+func (u *unixctl) register(name, usage string, minArgs, maxArgs int, handler func(args []string) (string, error)) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.commands[name] = &unixctlCommand{usage: usage, minArgs: minArgs, maxArgs: maxArgs, handler: handler}
+}
+
+// serve handles the connections of the listener until it's closed
+func (u *unixctl) serve(lst net.Listener) {
+	for {
+		conn, err := lst.Accept()
+		if err != nil {
+			return
+		}
+		go u.handle(conn)
+	}
+}
+
+func (u *unixctl) handle(conn net.Conn) {
+	defer conn.Close()
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+	for {
+		var req unixctlRequest
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		result, err := u.run(req.Method, req.Params)
+		reply := unixctlReply{Id: req.Id}
+		if err != nil {
+			msg := err.Error() + "\n"
+			reply.Error = &msg
+		} else {
+			reply.Result = &result
+		}
+		if err := enc.Encode(&reply); err != nil {
+			klog.Warningf("Unixctl reply: %v", err)
+			return
+		}
+	}
+}
+
+func (u *unixctl) run(name string, args []string) (string, error) {
+	u.mu.RLock()
+	cmd, ok := u.commands[name]
+	u.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("%q is not a valid command (use \"list-commands\" to see a list of valid commands)", name)
+	}
+	if len(args) < cmd.minArgs {
+		return "", fmt.Errorf("%q command requires at least %d arguments", name, cmd.minArgs)
+	}
+	if len(args) > cmd.maxArgs {
+		return "", fmt.Errorf("%q command takes at most %d arguments", name, cmd.maxArgs)
+	}
+	return cmd.handler(args)
+}
@@ -13,6 +13,9 @@ import (
 	"k8s.io/klog"
 )
 
+// the file of the schema constant, in the directory of the generated code
+const SCHEMA_FILE = "schema.go"
+
 var (
 	SchemaFile     string
 	PkgName        string
@@ -89,6 +92,22 @@ func Run() {
 	}
 	writer.Flush()
 	klog.Infof("The new code is stored in %s", dir+"/"+OutputFile)
+	if err := writeSchema(dir+"/"+SCHEMA_FILE, data); err != nil {
+		klog.Errorf("writeSchema returned %v", err)
+		return
+	}
+	klog.Infof("The schema is stored in %s", dir+"/"+SCHEMA_FILE)
+}
+
+// writeSchema writes the schema as a Go constant, so programs can use the schema without its file
+func writeSchema(fileName string, data []byte) error {
+	schema := strings.TrimSpace(string(data))
+	if strings.Contains(schema, "`") {
+		return fmt.Errorf("the schema contains a back quote")
+	}
+	code := fmt.Sprintf("package %s\n\n// Schema is the <database-schema> of the generated types\nconst Schema = `%s`\n",
+		PkgName, schema)
+	return ioutil.WriteFile(fileName, []byte(code), 0644)
 }
 
 func printStruct(w io.Writer, tableName string, columns []string) error {
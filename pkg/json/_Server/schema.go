@@ -0,0 +1,4 @@
+package _Server
+
+// Schema is the <database-schema> of the generated types
+const Schema = `{"name":"_Server","version":"1.1.0","cksum":"3236486585 698","tables":{"Database":{"columns":{"name":{"type":"string"},"model":{"type":{"key":{"type":"string","enum":["set",["standalone","clustered"]]}}},"connected":{"type":"boolean"},"leader":{"type":"boolean"},"schema":{"type":{"key":{"type":"string"},"min":0,"max":1}},"cid":{"type":{"key":{"type":"uuid"},"min":0,"max":1}},"sid":{"type":{"key":{"type":"uuid"},"min":0,"max":1}},"index":{"type":{"key":{"type":"integer"},"min":0,"max":1}}},"isRoot":true}}}`
@@ -17,9 +17,17 @@ func (u NamedUuid) MarshalJSON() ([]byte, error) {
 func (m Map) MarshalJSON() ([]byte, error) {
 	var buf bytes.Buffer
 	buf.WriteString(`["map",[`)
-	if m != nil {
+	if len(m) > 0 {
 		for k, v := range m {
-			buf.WriteString(fmt.Sprintf(`["%s","%s"],`, k, v))
+			jk, err := json.Marshal(k)
+			if err != nil {
+				return nil, err
+			}
+			jv, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			buf.WriteString(fmt.Sprintf(`[%s,%s],`, jk, jv))
 		}
 		buf.Truncate(buf.Len() - 1)
 	}
@@ -30,9 +38,12 @@ func (m Map) MarshalJSON() ([]byte, error) {
 func (s Set) MarshalJSON() ([]byte, error) {
 	var buf bytes.Buffer
 	buf.WriteString(`["set",[`)
-	if s != nil {
+	if len(s) > 0 {
 		for _, v := range s {
-			x, _ := json.Marshal(v)
+			x, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
 			buf.WriteString(fmt.Sprintf(`%s,`, x))
 		}
 		buf.Truncate(buf.Len() - 1)
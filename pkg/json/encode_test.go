@@ -108,3 +108,21 @@ func TestLength(t *testing.T) {
 		fmt.Sprintf("expected: %s\n", expected)+
 		fmt.Sprintf("actual  : %s\n", actual))
 }
+
+func TestEmptyMap(t *testing.T) {
+	b, err := json.Marshal(Map{})
+	assert.Nil(t, err)
+	assert.Equal(t, `["map",[]]`, string(b))
+}
+
+func TestEmptySet(t *testing.T) {
+	b, err := json.Marshal(Set{})
+	assert.Nil(t, err)
+	assert.Equal(t, `["set",[]]`, string(b))
+}
+
+func TestMapEscaping(t *testing.T) {
+	b, err := json.Marshal(Map{"match": `ip4.src=="10.0.0.1"`})
+	assert.Nil(t, err)
+	assert.Equal(t, `["map",[["match","ip4.src==\"10.0.0.1\""]]]`, string(b))
+}
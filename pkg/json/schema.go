@@ -0,0 +1,154 @@
+package json
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	TypeInteger = "integer"
+	TypeReal    = "real"
+	TypeBoolean = "boolean"
+	TypeString  = "string"
+	TypeUUID    = "uuid"
+
+	Unlimited = -1
+)
+
+// <database-schema>, as defined by RFC 7047, section 3.2
+type DatabaseSchema struct {
+	Name    string                  `json:"name"`
+	Version string                  `json:"version"`
+	Cksum   string                  `json:"cksum,omitempty"`
+	Tables  map[string]*TableSchema `json:"tables"`
+}
+
+// <table-schema>
+type TableSchema struct {
+	Columns map[string]*ColumnSchema `json:"columns"`
+	MaxRows int                      `json:"maxRows,omitempty"`
+	IsRoot  bool                     `json:"isRoot,omitempty"`
+	Indexes [][]string               `json:"indexes,omitempty"`
+}
+
+// <column-schema>
+type ColumnSchema struct {
+	Type      ColumnType `json:"type"`
+	Ephemeral bool       `json:"ephemeral,omitempty"`
+	Mutable   *bool      `json:"mutable,omitempty"`
+}
+
+// <type>, either an <atomic-type> or a JSON object that describes the type of a database column
+type ColumnType struct {
+	Key   *BaseType
+	Value *BaseType
+	Min   int
+	// Unlimited if "max" is "unlimited"
+	Max int
+}
+
+// <base-type>, either an <atomic-type> or a JSON object with optional constraints
+type BaseType struct {
+	Type       string
+	Enum       interface{}
+	MinInteger *int64
+	MaxInteger *int64
+	MinReal    *float64
+	MaxReal    *float64
+	MinLength  *int
+	MaxLength  *int
+	RefTable   string
+	RefType    string
+}
+
+func NewDatabaseSchema(data []byte) (*DatabaseSchema, error) {
+	schema := DatabaseSchema{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+// LookupColumn returns the schema of the column, or nil if the table or the column don't exist
+func (s *DatabaseSchema) LookupColumn(table, column string) *ColumnSchema {
+	ts, ok := s.Tables[table]
+	if !ok {
+		return nil
+	}
+	return ts.Columns[column]
+}
+
+// IsMap returns true if the column values are <pair>s
+func (ct *ColumnType) IsMap() bool {
+	return ct.Value != nil
+}
+
+// IsSet returns true if the column values can contain other than exactly one atom
+func (ct *ColumnType) IsSet() bool {
+	return !ct.IsMap() && (ct.Min != 1 || ct.Max != 1)
+}
+
+func (ct *ColumnType) UnmarshalJSON(data []byte) error {
+	var atomic string
+	if err := json.Unmarshal(data, &atomic); err == nil {
+		*ct = ColumnType{Key: &BaseType{Type: atomic}, Min: 1, Max: 1}
+		return nil
+	}
+	var obj struct {
+		Key   *BaseType   `json:"key"`
+		Value *BaseType   `json:"value"`
+		Min   *int        `json:"min"`
+		Max   interface{} `json:"max"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	if obj.Key == nil {
+		return fmt.Errorf("column type %s doesn't have a key", string(data))
+	}
+	*ct = ColumnType{Key: obj.Key, Value: obj.Value, Min: 1, Max: 1}
+	if obj.Min != nil {
+		ct.Min = *obj.Min
+	}
+	switch max := obj.Max.(type) {
+	case nil:
+	case float64:
+		ct.Max = int(max)
+	case string:
+		if max != "unlimited" {
+			return fmt.Errorf("wrong max value %q", max)
+		}
+		ct.Max = Unlimited
+	default:
+		return fmt.Errorf("wrong max value %v", max)
+	}
+	return nil
+}
+
+func (bt *BaseType) UnmarshalJSON(data []byte) error {
+	var atomic string
+	if err := json.Unmarshal(data, &atomic); err == nil {
+		*bt = BaseType{Type: atomic}
+		return nil
+	}
+	var obj struct {
+		Type       string      `json:"type"`
+		Enum       interface{} `json:"enum"`
+		MinInteger *int64      `json:"minInteger"`
+		MaxInteger *int64      `json:"maxInteger"`
+		MinReal    *float64    `json:"minReal"`
+		MaxReal    *float64    `json:"maxReal"`
+		MinLength  *int        `json:"minLength"`
+		MaxLength  *int        `json:"maxLength"`
+		RefTable   string      `json:"refTable"`
+		RefType    string      `json:"refType"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	*bt = BaseType(obj)
+	if bt.RefTable != "" && bt.RefType == "" {
+		bt.RefType = "strong"
+	}
+	return nil
+}
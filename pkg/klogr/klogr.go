@@ -0,0 +1,127 @@
+// Package klogr is a logr.Logger that writes by klog, it's the default logger of the server, so embedders that don't
+// set their own logger get the usual klog output.
+package klogr
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"k8s.io/klog"
+)
+
+var (
+	verbosityMu sync.RWMutex
+	// the verbosity of the modules that don't use the klog verbosity, by module name
+	verbosity = map[string]int{}
+)
+
+// SetVerbosity sets the verbosity of a module, which is the first name of its loggers, e.g. "transact" for the loggers
+// that are named "transact" and "transact/select". A negative level restores the klog verbosity of the module.
+func SetVerbosity(module string, level int) {
+	verbosityMu.Lock()
+	defer verbosityMu.Unlock()
+	if level < 0 {
+		delete(verbosity, module)
+	} else {
+		verbosity[module] = level
+	}
+}
+
+// Verbosity returns the verbosity of the modules that don't use the klog verbosity, by module name
+func Verbosity() map[string]int {
+	verbosityMu.RLock()
+	defer verbosityMu.RUnlock()
+	levels := make(map[string]int, len(verbosity))
+	for module, level := range verbosity {
+		levels[module] = level
+	}
+	return levels
+}
+
+type logger struct {
+	name   string
+	level  int
+	values []interface{}
+}
+
+// New returns a logger whose messages are written by klog, the V levels are klog verbosity levels
+func New() logr.Logger {
+	return logger{}
+}
+
+func (l logger) Enabled() bool {
+	module := l.name
+	if i := strings.Index(module, "/"); i >= 0 {
+		module = module[:i]
+	}
+	verbosityMu.RLock()
+	level, ok := verbosity[module]
+	verbosityMu.RUnlock()
+	if ok {
+		return l.level <= level
+	}
+	return bool(klog.V(klog.Level(l.level)))
+}
+
+func (l logger) Info(msg string, keysAndValues ...interface{}) {
+	if l.Enabled() {
+		klog.InfoDepth(1, l.format(msg, keysAndValues))
+	}
+}
+
+func (l logger) Error(err error, msg string, keysAndValues ...interface{}) {
+	kvs := make([]interface{}, 0, len(keysAndValues)+2)
+	kvs = append(append(kvs, keysAndValues...), "error", err)
+	klog.ErrorDepth(1, l.format(msg, kvs))
+}
+
+func (l logger) V(level int) logr.Logger {
+	l.level += level
+	return l
+}
+
+func (l logger) WithValues(keysAndValues ...interface{}) logr.Logger {
+	values := make([]interface{}, 0, len(l.values)+len(keysAndValues))
+	l.values = append(append(values, l.values...), keysAndValues...)
+	return l
+}
+
+func (l logger) WithName(name string) logr.Logger {
+	if len(l.name) > 0 {
+		name = l.name + "/" + name
+	}
+	l.name = name
+	return l
+}
+
+// format returns the message followed by the key-value pairs of the logger and of the message, e.g.
+// cache: Revision was compacted db="OVN_Northbound" revision=12
+func (l logger) format(msg string, keysAndValues []interface{}) string {
+	var b strings.Builder
+	if len(l.name) > 0 {
+		b.WriteString(l.name + ": ")
+	}
+	b.WriteString(msg)
+	writeValues(&b, l.values)
+	writeValues(&b, keysAndValues)
+	return b.String()
+}
+
+func writeValues(b *strings.Builder, keysAndValues []interface{}) {
+	for i := 0; i < len(keysAndValues); i += 2 {
+		if i+1 == len(keysAndValues) {
+			fmt.Fprintf(b, " %v=(MISSING)", keysAndValues[i])
+			break
+		}
+		switch v := keysAndValues[i+1].(type) {
+		case string:
+			fmt.Fprintf(b, " %v=%q", keysAndValues[i], v)
+		case error:
+			fmt.Fprintf(b, " %v=%q", keysAndValues[i], v.Error())
+		default:
+			fmt.Fprintf(b, " %v=%+v", keysAndValues[i], v)
+		}
+	}
+}
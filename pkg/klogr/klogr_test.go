@@ -0,0 +1,33 @@
+package klogr
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormat(t *testing.T) {
+	l := New().WithName("cache").WithValues("db", "OVN_Northbound").(logger)
+	assert.Equal(t, `cache: Revision was compacted db="OVN_Northbound" revision=12`,
+		l.format("Revision was compacted", []interface{}{"revision", 12}))
+	l = l.WithName("monitor").V(5).(logger)
+	assert.Equal(t, 5, l.level)
+	assert.Equal(t, `cache/monitor: Watch failed db="OVN_Northbound" error="leader changed" table=(MISSING)`,
+		l.format("Watch failed", []interface{}{"error", fmt.Errorf("leader changed"), "table"}))
+}
+
+func TestVerbosity(t *testing.T) {
+	l := New().WithName("transact")
+	assert.False(t, l.V(6).Enabled())
+	SetVerbosity("transact", 6)
+	assert.Equal(t, map[string]int{"transact": 6}, Verbosity())
+	assert.True(t, l.V(6).Enabled())
+	assert.True(t, l.WithName("select").V(6).Enabled())
+	assert.False(t, l.V(7).Enabled())
+	assert.False(t, New().WithName("monitor").V(6).Enabled())
+	assert.False(t, New().WithName("transactions").V(6).Enabled())
+	SetVerbosity("transact", -1)
+	assert.Equal(t, map[string]int{}, Verbosity())
+	assert.False(t, l.V(6).Enabled())
+}
@@ -0,0 +1,156 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+
+	"github.com/creachadair/jrpc2"
+)
+
+// The operation classes of the access control lists
+const (
+	// selects
+	ACL_READ = "read"
+	// the other operations of transactions, and ephemeral rows
+	ACL_WRITE = "write"
+	// monitors of any version
+	ACL_MONITOR = "monitor"
+)
+
+// ACLRule allows the clients that match all its criteria to run the operation classes on the databases. A rule
+// without criteria matches all the clients.
+type ACLRule struct {
+	// the common name of the client certificate, "*" matches any authenticated client
+	CommonName string `json:"cn,omitempty"`
+	// the source network of TCP and SSL clients, e.g. 10.0.0.0/8
+	CIDR string `json:"cidr,omitempty"`
+	// the user id of the peer process of UNIX socket clients
+	UID *int `json:"uid,omitempty"`
+	// the allowed databases, "*" allows all the databases
+	Databases []string `json:"databases"`
+	// the allowed operation classes, ACL_READ, ACL_WRITE or ACL_MONITOR
+	Operations []string `json:"operations"`
+
+	network *net.IPNet
+}
+
+// LoadACL reads the access control list from a JSON file, an array of ACLRule objects
+func LoadACL(fileName string) ([]ACLRule, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var rules []ACLRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("ACL file %s: %v", fileName, err)
+	}
+	return rules, nil
+}
+
+// SetACL enforces the access control list on the transactions and monitors of the clients. A client may run an
+// operation class on a database if any rule that matches the client allows it. nil disables the access control.
+func (con *DBServer) SetACL(rules []ACLRule) error {
+	for i := range rules {
+		r := &rules[i]
+		if len(r.CIDR) > 0 {
+			_, network, err := net.ParseCIDR(r.CIDR)
+			if err != nil {
+				return fmt.Errorf("ACL rule %d: %v", i, err)
+			}
+			r.network = network
+		}
+		for _, op := range r.Operations {
+			if op != ACL_READ && op != ACL_WRITE && op != ACL_MONITOR {
+				return fmt.Errorf("ACL rule %d: unknown operation class %s", i, op)
+			}
+		}
+	}
+	con.acl = rules
+	return nil
+}
+
+// matches returns true if the client connection matches all the criteria of the rule
+func (r *ACLRule) matches(conn *ClientConnection) bool {
+	if len(r.CommonName) > 0 {
+		if conn.Identity == nil || (r.CommonName != "*" && r.CommonName != conn.Identity.CommonName) {
+			return false
+		}
+	}
+	if r.network != nil && (conn.IP == nil || !r.network.Contains(conn.IP)) {
+		return false
+	}
+	if r.UID != nil && (conn.Peer == nil || *r.UID != conn.Peer.UID) {
+		return false
+	}
+	return true
+}
+
+// allows returns true if the rule allows the operation class on the database
+func (r *ACLRule) allows(dbName, op string) bool {
+	dbAllowed := false
+	for _, db := range r.Databases {
+		if db == "*" || db == dbName {
+			dbAllowed = true
+			break
+		}
+	}
+	if !dbAllowed {
+		return false
+	}
+	for _, o := range r.Operations {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize returns an error if the access control list doesn't allow the client of the request to run the
+// operation class on the database. The clients whose connections were not registered by AddClient are not allowed
+// anything, since their identities are unknown.
+func (con *DBServer) authorize(ctx context.Context, dbName, op string) error {
+	if con.acl == nil {
+		return nil
+	}
+	con.mu.Lock()
+	cs := con.session(jrpc2.ServerFromContext(ctx))
+	conn, registered := cs.conn, cs.registered
+	con.mu.Unlock()
+	if !registered {
+		return fmt.Errorf("%s of %s is not allowed, the connection is unknown", op, dbName)
+	}
+	for i := range con.acl {
+		if con.acl[i].matches(&conn) && con.acl[i].allows(dbName, op) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s of %s is not allowed", op, dbName)
+}
+
+// authorizeTransaction authorizes the operations of a transaction, the selects are ACL_READ and the other operations
+// are ACL_WRITE
+func (con *DBServer) authorizeTransaction(ctx context.Context, dbName string, operations []interface{}) error {
+	if con.acl == nil {
+		return nil
+	}
+	classes := map[string]bool{}
+	for _, op := range operations {
+		if valuesMap, ok := op.(map[string]interface{}); ok && valuesMap["op"] == "select" {
+			classes[ACL_READ] = true
+		} else {
+			classes[ACL_WRITE] = true
+		}
+	}
+	for _, class := range []string{ACL_READ, ACL_WRITE} {
+		if !classes[class] {
+			continue
+		}
+		if err := con.authorize(ctx, dbName, class); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package ovsdb
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestACLRules(t *testing.T) {
+	con := &DBServer{}
+	uid := 0
+	err := con.SetACL([]ACLRule{
+		{CommonName: "northd", Databases: []string{"*"}, Operations: []string{ACL_READ, ACL_WRITE, ACL_MONITOR}},
+		{CIDR: "10.0.0.0/8", Databases: []string{"OVN_Southbound"}, Operations: []string{ACL_READ, ACL_MONITOR}},
+		{UID: &uid, Databases: []string{"OVN_Northbound"}, Operations: []string{ACL_WRITE}},
+	})
+	assert.Nil(t, err)
+	allowed := func(conn ClientConnection, dbName, op string) bool {
+		for i := range con.acl {
+			if con.acl[i].matches(&conn) && con.acl[i].allows(dbName, op) {
+				return true
+			}
+		}
+		return false
+	}
+	northd := ClientConnection{Identity: &ClientIdentity{CommonName: "northd"}, IP: net.ParseIP("192.168.1.1")}
+	assert.True(t, allowed(northd, "OVN_Northbound", ACL_WRITE))
+	assert.True(t, allowed(northd, "OVN_Southbound", ACL_MONITOR))
+
+	dashboard := ClientConnection{IP: net.ParseIP("10.1.2.3")}
+	assert.True(t, allowed(dashboard, "OVN_Southbound", ACL_MONITOR))
+	assert.False(t, allowed(dashboard, "OVN_Southbound", ACL_WRITE))
+	assert.False(t, allowed(dashboard, "OVN_Northbound", ACL_READ))
+
+	root := ClientConnection{Peer: &PeerCredentials{UID: 0}}
+	assert.True(t, allowed(root, "OVN_Northbound", ACL_WRITE))
+	assert.False(t, allowed(ClientConnection{}, "OVN_Northbound", ACL_WRITE))
+
+	assert.NotNil(t, con.SetACL([]ACLRule{{CIDR: "10.0.0.0", Databases: []string{"*"}}}))
+	assert.NotNil(t, con.SetACL([]ACLRule{{Databases: []string{"*"}, Operations: []string{"delete"}}}))
+}
@@ -0,0 +1,220 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// DatabaseStatus is the state of a database in the server, as it's shown by the admin commands
+type DatabaseStatus struct {
+	Name   string
+	Leader bool
+	// set if the database is cached, then its rows and revision are known
+	Cached   bool
+	Rows     int
+	Revision int64
+	// the active monitors of the clients of the server
+	Monitors int
+}
+
+// ServerStatus is the state of the server, as it's shown by the admin commands
+type ServerStatus struct {
+	ServerID  string
+	Ready     bool
+	Sessions  int
+	Databases []DatabaseStatus
+	// the stored values whose checksums didn't match, and the key of the last one
+	CorruptedValues  int64
+	LastCorruptedKey string
+}
+
+// Status returns the state of the server and of its databases
+func (con *DBServer) Status(ctx context.Context) (*ServerStatus, error) {
+	dbs, err := con.ListDatabases(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status := &ServerStatus{ServerID: con.uuid, Ready: con.Ready()}
+	monitors := map[string]int{}
+	con.mu.Lock()
+	status.Sessions = len(con.sessions)
+	status.CorruptedValues, status.LastCorruptedKey = con.corruptedValues, con.lastCorruptedKey
+	for _, cs := range con.sessions {
+		for _, m := range cs.monitors {
+			monitors[m.dbName]++
+		}
+	}
+	con.mu.Unlock()
+	for _, dbName := range dbs {
+		db := DatabaseStatus{Name: dbName, Leader: con.IsLeader(dbName), Monitors: monitors[dbName]}
+		con.cachesMu.Lock()
+		c, ok := con.caches[dbName]
+		con.cachesMu.Unlock()
+		if ok {
+			db.Cached = true
+			db.Rows = c.size()
+			c.mu.Lock()
+			db.Revision = c.revision
+			c.mu.Unlock()
+		}
+		status.Databases = append(status.Databases, db)
+	}
+	sort.Slice(status.Databases, func(i, j int) bool { return status.Databases[i].Name < status.Databases[j].Name })
+	return status, nil
+}
+
+// Compact compacts the etcd history up to the current revision, and defragments the etcd members. The history is
+// shared by all the databases, so clients that reconnect later than the monitor history of the caches get the whole
+// contents of their monitors. It returns the compacted revision.
+func (con *DBServer) Compact(ctx context.Context) (int64, error) {
+	if con.relay != nil {
+		return 0, fmt.Errorf("the databases are relayed from an upstream server")
+	}
+	var revision int64
+	err := con.retry(ctx, "get", func(ctx context.Context) error {
+		resp, err := con.cli.Get(ctx, MAINTENANCE_PREFIX, clientv3.WithPrefix(), clientv3.WithCountOnly())
+		if err == nil {
+			revision = resp.Header.Revision
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := con.cli.Compact(ctx, revision, clientv3.WithCompactPhysical()); err != nil {
+		return 0, fmt.Errorf("compaction of revision %d: %v", revision, err)
+	}
+	con.log.WithName("maintenance").Info("Compacted etcd", "revision", revision)
+	con.defragment(ctx)
+	return revision, nil
+}
+
+// TableDump describes the cached rows of a table
+type TableDump struct {
+	Table string
+	Rows  int
+	// an estimate of the memory of the rows, by the size of their JSON encoding
+	Bytes int
+}
+
+// DumpCache describes the cached tables of the database, it returns an error if the database is not cached
+func (con *DBServer) DumpCache(dbName string) ([]TableDump, error) {
+	con.cachesMu.Lock()
+	c, ok := con.caches[dbName]
+	con.cachesMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("database %s is not cached", dbName)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tables := make([]TableDump, 0, len(c.rows))
+	for table, tableRows := range c.rows {
+		dump := TableDump{Table: table, Rows: len(tableRows)}
+		for rowUuid, r := range tableRows {
+			data, _ := json.Marshal(r)
+			dump.Bytes += len(rowUuid) + len(data)
+		}
+		tables = append(tables, dump)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Table < tables[j].Table })
+	return tables, nil
+}
+
+// MonitorDump describes an active monitor of a client
+type MonitorDump struct {
+	Client   string
+	ID       string
+	Database string
+	// 1 for "monitor", 2 for "monitor_cond" and 3 for "monitor_cond_since"
+	Version int
+	// the revision of the last update that was sent to the client
+	Revision int64
+	// the rows of the changes that weren't sent yet
+	Pending int
+	// the conditions of the tables, by table name, the tables without conditions are empty
+	Tables map[string]string
+}
+
+// DumpMonitors describes the active monitors of the clients, sorted by client and monitor id
+func (con *DBServer) DumpMonitors() []MonitorDump {
+	con.mu.Lock()
+	var monitors []*monitor
+	clients := map[*monitor]string{}
+	for _, cs := range con.sessions {
+		for _, m := range cs.monitors {
+			monitors = append(monitors, m)
+			clients[m] = cs.conn.name()
+		}
+	}
+	con.mu.Unlock()
+	dumps := make([]MonitorDump, 0, len(monitors))
+	for _, m := range monitors {
+		dump := MonitorDump{Client: clients[m], ID: m.key, Database: m.dbName, Version: int(m.version),
+			Tables: map[string]string{}}
+		m.mu.Lock()
+		dump.Revision = m.revision
+		for _, tableChanges := range m.pending {
+			dump.Pending += len(tableChanges)
+		}
+		for table := range m.tables {
+			conds := make([]string, 0, len(m.where[table]))
+			for _, cond := range m.where[table] {
+				conds = append(conds, cond.String())
+			}
+			dump.Tables[table] = strings.Join(conds, ",")
+		}
+		m.mu.Unlock()
+		dumps = append(dumps, dump)
+	}
+	sort.Slice(dumps, func(i, j int) bool {
+		if dumps[i].Client != dumps[j].Client {
+			return dumps[i].Client < dumps[j].Client
+		}
+		return dumps[i].ID < dumps[j].ID
+	})
+	return dumps
+}
+
+// LockDump describes an OVSDB lock that a client owns or waits for
+type LockDump struct {
+	Client string
+	ID     string
+	Owned  bool
+	// the time that the client started to wait for the lock, or that it acquired the lock if it owns it
+	WaitStart  time.Time
+	OwnedSince time.Time
+}
+
+// DumpLocks describes the OVSDB locks of the clients, sorted by lock id and client
+func (con *DBServer) DumpLocks() []LockDump {
+	con.mu.Lock()
+	sessions := map[*lockSession]string{}
+	for _, cs := range con.sessions {
+		if cs.locks != nil {
+			sessions[cs.locks] = cs.conn.name()
+		}
+	}
+	con.mu.Unlock()
+	var dumps []LockDump
+	for ls, client := range sessions {
+		ls.mu.Lock()
+		for id, lock := range ls.locks {
+			dumps = append(dumps, LockDump{Client: client, ID: id, Owned: lock.owned, WaitStart: lock.waitStart,
+				OwnedSince: lock.ownedSince})
+		}
+		ls.mu.Unlock()
+	}
+	sort.Slice(dumps, func(i, j int) bool {
+		if dumps[i].ID != dumps[j].ID {
+			return dumps[i].ID < dumps[j].ID
+		}
+		return dumps[i].Client < dumps[j].Client
+	})
+	return dumps
+}
@@ -0,0 +1,97 @@
+package ovsdb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// breaker is the circuit breaker of the etcd requests. It opens after consecutive failed requests, then the requests
+// fail immediately, without waiting for their timeouts, until a background probe of etcd succeeds.
+type breaker struct {
+	mu sync.Mutex
+	// the number of consecutive failures that open the breaker, 0 if the breaker is disabled
+	threshold     int
+	probeInterval time.Duration
+	failures      int
+	open          bool
+	// the error of the last failed request
+	lastErr error
+}
+
+// errBreakerOpen is returned for the etcd requests while the breaker is open
+type errBreakerOpen struct {
+	err error
+}
+
+func (e errBreakerOpen) Error() string {
+	return fmt.Sprintf("etcd is unavailable: %v", e.err)
+}
+
+// SetCircuitBreaker opens the circuit breaker of the etcd requests after threshold consecutive requests fail, and
+// probes etcd every probeInterval until it's available. 0 disables the breaker.
+func (con *DBServer) SetCircuitBreaker(threshold int, probeInterval time.Duration) {
+	con.breaker.mu.Lock()
+	con.breaker.threshold = threshold
+	con.breaker.probeInterval = probeInterval
+	con.breaker.mu.Unlock()
+}
+
+// breakerError returns the error of the requests if the breaker is open, nil otherwise
+func (con *DBServer) breakerError() error {
+	con.breaker.mu.Lock()
+	defer con.breaker.mu.Unlock()
+	if con.breaker.open {
+		return errBreakerOpen{err: con.breaker.lastErr}
+	}
+	return nil
+}
+
+// breakerResult counts the failures of the etcd requests, the errors that are not related to the availability of
+// etcd are ignored. The timeouts are counted only if the context of the caller, ctx, is not done, otherwise the request
+// was cut short by the caller rather than by a slow etcd. It opens the breaker after threshold consecutive failures.
+func (con *DBServer) breakerResult(ctx context.Context, err error) {
+	b := &con.breaker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.failures = 0
+		return
+	}
+	if b.threshold == 0 || b.open || !(retryable(err) || (err == context.DeadlineExceeded && ctx.Err() == nil)) {
+		return
+	}
+	b.failures++
+	b.lastErr = err
+	if b.failures < b.threshold {
+		return
+	}
+	b.open = true
+	con.log.WithName("etcd").Error(err, "Circuit breaker opened", "failures", b.failures)
+	go con.probe(b.probeInterval)
+}
+
+// probe reads etcd every interval until it succeeds, then it closes the breaker
+func (con *DBServer) probe(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), con.requestTimeout)
+		_, err := con.cli.Get(ctx, SCHEMAS_PREFIX, clientv3.WithPrefix(), clientv3.WithCountOnly())
+		cancel()
+		con.breaker.mu.Lock()
+		if err == nil {
+			con.breaker.open = false
+			con.breaker.failures = 0
+			con.breaker.mu.Unlock()
+			con.log.WithName("etcd").Info("Circuit breaker closed")
+			return
+		}
+		con.breaker.lastErr = err
+		con.breaker.mu.Unlock()
+		con.log.WithName("etcd").V(5).Info("Circuit breaker probe failed", "error", err)
+	}
+}
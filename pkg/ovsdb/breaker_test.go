@@ -0,0 +1,36 @@
+package ovsdb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+
+	"github.com/ibm/ovsdb-etcd/pkg/klogr"
+)
+
+func TestBreaker(t *testing.T) {
+	con := &DBServer{log: klogr.New()}
+	con.SetCircuitBreaker(2, time.Hour)
+	ctx := context.Background()
+	con.breakerResult(ctx, rpctypes.ErrNoLeader)
+	con.breakerResult(ctx, nil)
+	con.breakerResult(ctx, rpctypes.ErrNoLeader)
+	con.breakerResult(ctx, fmt.Errorf("unknown table"))
+	assert.Nil(t, con.breakerError())
+
+	// the timeouts of callers whose contexts expired are not failures of etcd
+	expired, cancel := context.WithTimeout(ctx, 0)
+	defer cancel()
+	con.breakerResult(expired, context.DeadlineExceeded)
+	assert.Nil(t, con.breakerError())
+
+	con.breakerResult(ctx, context.DeadlineExceeded)
+	err := con.breakerError()
+	assert.NotNil(t, err)
+	assert.True(t, etcdError(err))
+	assert.Contains(t, err.Error(), "deadline exceeded")
+}
@@ -0,0 +1,601 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	ovsjson "github.com/ibm/ovsdb-etcd/pkg/json"
+)
+
+// row columns in the OVSDB wire format. The rows are not structs that are generated from the schemas, like the types in
+// pkg/json/<database>, since the schemas are loaded at runtime, from the schema files or from etcd, and they can be
+// converted while the server runs.
+type row map[string]interface{}
+
+// tables rows: table name -> row uuid -> row
+type tablesRows map[string]map[string]row
+
+// a change of a single row during one etcd revision, old is nil for inserted rows and new is nil for deleted rows
+type rowChange struct {
+	old row
+	new row
+}
+
+// tables changes: table name -> row uuid -> change
+type tablesChanges map[string]map[string]*rowChange
+
+// the changes of a single etcd revision
+type revisionChanges struct {
+	revision int64
+	changes  tablesChanges
+}
+
+// A dbCache is a copy of the rows of a database, which is kept up to date by a single etcd watch. The cache is shared
+// by all the monitors of the database, and it notifies them about the changes of every etcd revision.
+type dbCache struct {
+	dbName   string
+	dbSchema *ovsjson.DatabaseSchema
+	// the prefix of the data keys of the database
+	prefix string
+	// mu protects the fields below
+	mu sync.Mutex
+	// the rows are never modified, every change replaces the row by a new one, so they can be shared with the monitors
+	rows tablesRows
+	// the etcd revision of the rows
+	revision int64
+	// the last revision in which a column of a row was modified: table name -> row uuid -> revision, the rows of
+	// relayed databases have no versions
+	versions map[string]map[string]int64
+	monitors map[*monitor]bool
+	// the changes of the recent revisions, oldest first, the history contains all the changes after historyStart
+	history      []revisionChanges
+	historyStart int64
+	// the maximal number of revisions in the history, 0 if the history is not kept
+	historySize int
+	// if it's not nil, it's called for every changed row, and it can set the columns that are not stored in etcd
+	override func(table, rowUuid string, r row)
+	// the encoding of the stored values of the database
+	encoding *valueEncoding
+	// if it's not nil, it's called for the stored values whose checksums don't match, with their keys
+	corrupted func(key string)
+	// stops the etcd watch of the cache
+	cancel context.CancelFunc
+	log    logr.Logger
+}
+
+func newDBCache(log logr.Logger, dbName string, dbSchema *ovsjson.DatabaseSchema) *dbCache {
+	c := &dbCache{dbName: dbName, dbSchema: dbSchema, prefix: dataPrefix(dbName), rows: tablesRows{},
+		versions: map[string]map[string]int64{}, encoding: &valueEncoding{}, monitors: map[*monitor]bool{},
+		log: log.WithName("cache").WithValues("db", dbName)}
+	for table := range dbSchema.Tables {
+		c.rows[table] = map[string]row{}
+		c.versions[table] = map[string]int64{}
+	}
+	return c
+}
+
+// getCache returns the cache of the database. The cache is loaded when it's used for the first time, and then it's
+// updated by the database watch, until the watch fails.
+func (con *DBServer) getCache(ctx context.Context, dbName string) (*dbCache, error) {
+	if con.relay != nil {
+		return con.relay.cache(dbName)
+	}
+	for {
+		if c, ok := con.loadedCache(dbName); ok {
+			return c, nil
+		}
+		_, dbSchema, ok := con.lookupSchema(dbName)
+		if !ok {
+			return nil, fmt.Errorf("unknown database")
+		}
+		// the rows are read without holding cachesMu, so the caches of the other databases are not blocked by the
+		// load, the cache is installed only if no other cache was installed meanwhile, and the schema wasn't changed
+		c, err := con.loadCache(ctx, dbName, dbSchema, 0)
+		if err != nil {
+			return nil, err
+		}
+		con.cachesMu.Lock()
+		_, current, _ := con.lookupSchema(dbName)
+		if _, ok := con.caches[dbName]; !ok && current == dbSchema {
+			con.installCache(c)
+			con.cachesMu.Unlock()
+			return c, nil
+		}
+		con.cachesMu.Unlock()
+	}
+}
+
+// loadedCache returns the cache of the database if it's loaded
+func (con *DBServer) loadedCache(dbName string) (*dbCache, bool) {
+	con.cachesMu.Lock()
+	defer con.cachesMu.Unlock()
+	c, ok := con.caches[dbName]
+	return c, ok
+}
+
+// installCache sets the loaded cache as the cache of its database, and starts its watch. It's called with cachesMu
+// locked.
+func (con *DBServer) installCache(c *dbCache) {
+	dbName, dbSchema := c.dbName, c.dbSchema
+	if dbName == "_Server" {
+		// the _Server rows are shared by all the servers, the columns that describe the server are set locally
+		c.override = con.overrideServerRow
+		for table, tableRows := range c.rows {
+			for rowUuid, r := range tableRows {
+				c.override(table, rowUuid, r)
+			}
+		}
+	}
+	c.historySize = con.monitorHistorySize
+	c.historyStart = c.revision
+	con.caches[dbName] = c
+	watchCtx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	go func() {
+		prefix := c.prefix
+		for {
+			// the watch starts right after the revision of the loaded rows, so no change is lost or applied twice
+			err := c.watch(con.cli.Watch(watchCtx, prefix, clientv3.WithPrefix(), clientv3.WithRev(c.revision+1)))
+			if watchCtx.Err() != nil {
+				// the cache was dropped
+				return
+			}
+			if err != rpctypes.ErrCompacted {
+				c.log.Error(err, "Watch failed")
+				break
+			}
+			// the changes after the cache revision were compacted, so the cache is loaded again and the monitors
+			// get the differences, as if they were the changes of a single revision
+			c.log.Info("Revision was compacted, reloading the database", "revision", c.revision+1)
+			con.metrics.watchRestarts.Inc(dbName)
+			fresh, err := con.loadCache(watchCtx, dbName, dbSchema, 0)
+			if err != nil {
+				c.log.Error(err, "Reload failed")
+				break
+			}
+			if c.override != nil {
+				for table, tableRows := range fresh.rows {
+					for rowUuid, r := range tableRows {
+						c.override(table, rowUuid, r)
+					}
+				}
+			}
+			c.reload(fresh)
+		}
+		// the cache is not up to date anymore, the next monitor of the database will load a new one
+		con.cachesMu.Lock()
+		if con.caches[dbName] == c {
+			delete(con.caches, dbName)
+		}
+		con.cachesMu.Unlock()
+		con.disconnectMonitors(c)
+	}()
+}
+
+// dropCache drops the cache of the database, after the database schema was changed. The monitors of the database are
+// canceled: the clients that are aware of database changes get "monitor_canceled" notifications, and the connections
+// of the other clients are closed.
+func (con *DBServer) dropCache(dbName string) {
+	con.cachesMu.Lock()
+	c, ok := con.caches[dbName]
+	delete(con.caches, dbName)
+	con.cachesMu.Unlock()
+	if !ok {
+		return
+	}
+	c.cancel()
+	c.mu.Lock()
+	monitors := c.monitors
+	c.monitors = map[*monitor]bool{}
+	c.mu.Unlock()
+	for m := range monitors {
+		con.mu.Lock()
+		cs, ok := con.sessions[m.srv]
+		aware := ok && cs.dbChangeAware
+		con.mu.Unlock()
+		con.removeMonitor(m)
+		if aware {
+			m.notifier.enqueue(m, "monitor_canceled", []interface{}{m.id}, 0, true)
+		} else {
+			m.srv.Stop()
+		}
+	}
+}
+
+// disconnectMonitors removes the monitors of a cache that is not up to date anymore, and closes their connections
+func (con *DBServer) disconnectMonitors(c *dbCache) {
+	c.mu.Lock()
+	monitors := c.monitors
+	c.monitors = map[*monitor]bool{}
+	c.mu.Unlock()
+	for m := range monitors {
+		con.removeMonitor(m)
+		// the client can't know which changes it missed, closing the connection forces it to reconnect and to
+		// monitor the database again
+		m.srv.Stop()
+	}
+}
+
+// loadCache loads the rows of the database at the given etcd revision, or at the current revision if it's 0. The rows
+// are read page by page, all the pages at the revision of the first one, so together they are a consistent snapshot of
+// the revision, and the whole etcd response of the database isn't held in memory together with the loaded rows.
+func (con *DBServer) loadCache(ctx context.Context, dbName string, dbSchema *ovsjson.DatabaseSchema,
+	revision int64) (*dbCache, error) {
+	c := newDBCache(con.log, dbName, dbSchema)
+	c.prefix = con.dbPrefix(dbName)
+	c.encoding = &con.encoding
+	c.corrupted = func(key string) { con.valueCorrupted(dbName, key) }
+	prefix := c.prefix
+	end := clientv3.GetPrefixRangeEnd(prefix)
+	for key := prefix; ; {
+		opts := []clientv3.OpOption{clientv3.WithRange(end), clientv3.WithLimit(SELECT_PAGE_SIZE)}
+		if revision > 0 {
+			opts = append(opts, clientv3.WithRev(revision))
+		}
+		var resp *clientv3.GetResponse
+		err := con.retry(ctx, "get", func(ctx context.Context) error {
+			var err error
+			resp, err = con.cli.Get(ctx, key, opts...)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if revision == 0 {
+			revision = resp.Header.Revision
+		}
+		for _, kv := range resp.Kvs {
+			c.applyKv(prefix, kv, false, tablesChanges{})
+		}
+		if !resp.More || len(resp.Kvs) == 0 {
+			break
+		}
+		key = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+	}
+	c.revision = revision
+	return c, nil
+}
+
+// watch applies the watch events to the cache until the watch fails, it returns rpctypes.ErrCompacted if the next
+// revision of the cache was compacted
+func (c *dbCache) watch(wch clientv3.WatchChan) error {
+	prefix := c.prefix
+	for wresp := range wch {
+		if err := wresp.Err(); err != nil {
+			return err
+		}
+		// all the events of an etcd revision are the result of a single transaction, and they are sent together
+		events := wresp.Events
+		for len(events) > 0 {
+			revision := events[0].Kv.ModRevision
+			n := 1
+			for n < len(events) && events[n].Kv.ModRevision == revision {
+				n++
+			}
+			c.apply(prefix, revision, events[:n])
+			events = events[n:]
+		}
+	}
+	return fmt.Errorf("watch channel closed")
+}
+
+// apply applies the events of a single etcd revision to the cache rows, and notifies the monitors about the changes
+func (c *dbCache) apply(prefix string, revision int64, events []*clientv3.Event) {
+	changes := tablesChanges{}
+	c.mu.Lock()
+	if revision <= c.revision {
+		// the revision is already part of the loaded rows, applying it again would notify the monitors twice
+		c.mu.Unlock()
+		c.log.V(5).Info("Skipping an old revision", "revision", revision, "cacheRevision", c.revision)
+		return
+	}
+	for _, ev := range events {
+		c.applyKv(prefix, ev.Kv, ev.Type == mvccpb.DELETE, changes)
+	}
+	monitors := c.record(revision, changes)
+	c.mu.Unlock()
+	for _, m := range monitors {
+		m.update(revision, changes)
+	}
+}
+
+// record sets the revision of the cache, adds its changes to the history, and returns the monitors that should be
+// notified about them. It's called with c.mu locked.
+func (c *dbCache) record(revision int64, changes tablesChanges) []*monitor {
+	c.revision = revision
+	if c.historySize > 0 {
+		c.history = append(c.history, revisionChanges{revision: revision, changes: changes})
+		if len(c.history) > c.historySize {
+			c.historyStart = c.history[0].revision
+			c.history = c.history[1:]
+		}
+	} else {
+		// without history, only the clients that are up to date can resume their monitors
+		c.historyStart = revision
+	}
+	monitors := make([]*monitor, 0, len(c.monitors))
+	for m := range c.monitors {
+		monitors = append(monitors, m)
+	}
+	return monitors
+}
+
+// reload replaces the rows of the cache by the rows of a newer snapshot, and notifies the monitors about the
+// differences
+func (c *dbCache) reload(fresh *dbCache) {
+	changes := tablesChanges{}
+	c.mu.Lock()
+	for table, tableRows := range c.rows {
+		freshRows := fresh.rows[table]
+		tableChanges := map[string]*rowChange{}
+		for rowUuid, r := range tableRows {
+			if fr, ok := freshRows[rowUuid]; !ok || !reflect.DeepEqual(r, fr) {
+				tableChanges[rowUuid] = &rowChange{old: r, new: freshRows[rowUuid]}
+			}
+		}
+		for rowUuid, fr := range freshRows {
+			if _, ok := tableRows[rowUuid]; !ok {
+				tableChanges[rowUuid] = &rowChange{new: fr}
+			}
+		}
+		changes[table] = tableChanges
+	}
+	c.rows = fresh.rows
+	c.versions = fresh.versions
+	c.revision = fresh.revision
+	// the changes between the revisions are unknown, so the history starts again
+	c.history = nil
+	c.historyStart = c.revision
+	revision := c.revision
+	monitors := make([]*monitor, 0, len(c.monitors))
+	for m := range c.monitors {
+		monitors = append(monitors, m)
+	}
+	c.mu.Unlock()
+	for _, m := range monitors {
+		m.update(revision, changes)
+	}
+}
+
+// snapshot returns the current rows of the tables, and registers the monitor, so it will be notified about all the
+// changes after the returned revision
+func (c *dbCache) snapshot(m *monitor, tables []string) (tablesRows, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rows := tablesRows{}
+	for _, table := range tables {
+		tableRows := make(map[string]row, len(c.rows[table]))
+		for rowUuid, r := range c.rows[table] {
+			tableRows[rowUuid] = r
+		}
+		rows[table] = tableRows
+	}
+	if m != nil {
+		c.monitors[m] = true
+	}
+	return rows, c.revision
+}
+
+// setColumn sets a column of a row that is not stored in etcd, and notifies the monitors about the change
+func (c *dbCache) setColumn(table, rowUuid, column string, value interface{}) {
+	c.mu.Lock()
+	old, ok := c.rows[table][rowUuid]
+	if !ok || reflect.DeepEqual(old[column], value) {
+		c.mu.Unlock()
+		return
+	}
+	r := old.copy()
+	r[column] = value
+	c.rows[table][rowUuid] = r
+	changes := tablesChanges{table: {rowUuid: &rowChange{old: old, new: r}}}
+	monitors := make([]*monitor, 0, len(c.monitors))
+	for m := range c.monitors {
+		monitors = append(monitors, m)
+	}
+	c.mu.Unlock()
+	for _, m := range monitors {
+		m.updateLocal(changes)
+	}
+}
+
+// changesSince returns the changes of the tables after lastRevision from the history, merged per row, and registers
+// the monitor like snapshot. It returns false, and doesn't register the monitor, if the history doesn't contain all
+// the changes after lastRevision.
+func (c *dbCache) changesSince(m *monitor, tables []string, lastRevision int64) (tablesChanges, int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if lastRevision < c.historyStart || lastRevision > c.revision {
+		return nil, 0, false
+	}
+	changes := tablesChanges{}
+	for _, table := range tables {
+		changes[table] = map[string]*rowChange{}
+	}
+	for _, rc := range c.history {
+		if rc.revision <= lastRevision {
+			continue
+		}
+		for table, tableChanges := range rc.changes {
+			merged, ok := changes[table]
+			if !ok {
+				continue
+			}
+			for rowUuid, change := range tableChanges {
+				if prev, ok := merged[rowUuid]; ok {
+					prev.new = change.new
+				} else {
+					merged[rowUuid] = &rowChange{old: change.old, new: change.new}
+				}
+			}
+		}
+	}
+	c.monitors[m] = true
+	return changes, c.revision, true
+}
+
+func (c *dbCache) removeMonitor(m *monitor) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.monitors, m)
+}
+
+// selectRows returns the rows of the table like DBServer.Select, sorted by uuid, and the revision of the rows. The
+// rows are returned if the cache is at the given revision, or at any revision if it's 0, otherwise it returns false.
+func (c *dbCache) selectRows(table string, columnsMap map[string]bool, withVersion bool,
+	revision int64) ([]map[string]interface{}, int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if revision > 0 && revision != c.revision {
+		return nil, 0, false
+	}
+	uuids := make([]string, 0, len(c.rows[table]))
+	for rowUuid := range c.rows[table] {
+		uuids = append(uuids, rowUuid)
+	}
+	sort.Strings(uuids)
+	rows := make([]map[string]interface{}, 0, len(uuids))
+	for _, rowUuid := range uuids {
+		result := map[string]interface{}{COL_UUID: ovsjson.Uuid(rowUuid)}
+		for colName, value := range c.rows[table][rowUuid] {
+			if columnsMap == nil || columnsMap[colName] {
+				result[colName] = value
+			}
+		}
+		if version, ok := c.versions[table][rowUuid]; ok && withVersion {
+			result[COL_VERSION] = revisionToUuid(version)
+		}
+		rows = append(rows, result)
+	}
+	return rows, c.revision, true
+}
+
+// size returns the number of rows in the cache
+func (c *dbCache) size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	size := 0
+	for _, tableRows := range c.rows {
+		size += len(tableRows)
+	}
+	return size
+}
+
+// applyKv applies a stored (or deleted) key of the database to the cache rows, and records the change of the row in
+// changes. The changed row is replaced by a new one.
+func (c *dbCache) applyKv(prefix string, kv *mvccpb.KeyValue, deleted bool, changes tablesChanges) {
+	table, rowUuid, column, ok := parseDataKey(prefix, string(kv.Key))
+	if !ok {
+		return
+	}
+	tableRows, ok := c.rows[table]
+	if !ok {
+		c.log.V(5).Info("Unknown table", "key", string(kv.Key))
+		return
+	}
+	tableChanges, ok := changes[table]
+	if !ok {
+		tableChanges = map[string]*rowChange{}
+		changes[table] = tableChanges
+	}
+	change, ok := tableChanges[rowUuid]
+	if !ok {
+		change = &rowChange{old: tableRows[rowUuid], new: tableRows[rowUuid]}
+		tableChanges[rowUuid] = change
+	}
+	current := tableRows[rowUuid].copy()
+	if current == nil {
+		current = row{}
+	}
+	tableSchema := c.dbSchema.Tables[table]
+	switch {
+	case deleted && column == "":
+		current = row{}
+	case deleted:
+		delete(current, column)
+	case column == "":
+		// the whole row is stored as a single JSON object
+		var r row
+		if err := json.Unmarshal(kv.Value, &r); err != nil {
+			c.log.Error(err, "Wrong row value", "table", table, "uuid", rowUuid)
+			return
+		}
+		current = row{}
+		for colName, value := range r {
+			if _, ok := tableSchema.Columns[colName]; ok {
+				current[colName] = value
+			}
+		}
+	default:
+		colSchema, ok := tableSchema.Columns[column]
+		if !ok {
+			c.log.V(5).Info("Unknown column", "table", table, "uuid", rowUuid, "column", column)
+			return
+		}
+		text, err := c.encoding.load(string(kv.Key), string(kv.Value))
+		if err == errChecksum && c.corrupted != nil {
+			c.corrupted(string(kv.Key))
+		}
+		var value interface{}
+		if err == nil {
+			value, err = decodeValue(text, &colSchema.Type)
+		}
+		if err != nil {
+			c.log.Error(err, "Wrong column value", "table", table, "uuid", rowUuid, "column", column)
+			return
+		}
+		current[column] = value
+	}
+	if len(current) == 0 {
+		delete(tableRows, rowUuid)
+		delete(c.versions[table], rowUuid)
+		change.new = nil
+	} else {
+		if c.override != nil {
+			c.override(table, rowUuid, current)
+		}
+		tableRows[rowUuid] = current
+		c.versions[table][rowUuid] = kv.ModRevision
+		change.new = current
+	}
+}
+
+func (r row) copy() row {
+	if r == nil {
+		return nil
+	}
+	c := make(row, len(r))
+	for k, v := range r {
+		c[k] = v
+	}
+	return c
+}
+
+func dataPrefix(dbName string) string {
+	return "ovsdb/" + dbName + "/"
+}
+
+// parseDataKey splits a data key of the form <prefix><table>/<uuid>/<column>. Rows that are stored as a single JSON
+// value, e.g. the _Server database rows, have keys without the column part.
+func parseDataKey(prefix, key string) (table, rowUuid, column string, ok bool) {
+	if !strings.HasPrefix(key, prefix) {
+		return "", "", "", false
+	}
+	keys := strings.Split(strings.TrimPrefix(key, prefix), "/")
+	switch len(keys) {
+	case 2:
+		return keys[0], keys[1], "", true
+	case 3:
+		return keys[0], keys[1], keys[2], true
+	}
+	return "", "", "", false
+}
@@ -0,0 +1,63 @@
+package ovsdb
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+
+	ovsjson "github.com/ibm/ovsdb-etcd/pkg/json"
+	"github.com/ibm/ovsdb-etcd/pkg/klogr"
+)
+
+func TestCacheSelectRows(t *testing.T) {
+	var tableSchema ovsjson.TableSchema
+	assert.Nil(t, json.Unmarshal([]byte(portBindingSchema), &tableSchema))
+	c := newDBCache(klogr.New(), "OVN_Southbound",
+		&ovsjson.DatabaseSchema{Tables: map[string]*ovsjson.TableSchema{"Port_Binding": &tableSchema}})
+	apply := func(key, value string, revision int64, deleted bool) {
+		kv := &mvccpb.KeyValue{Key: []byte(c.prefix + "Port_Binding/" + key), Value: []byte(value),
+			ModRevision: revision}
+		c.applyKv(c.prefix, kv, deleted, tablesChanges{})
+		c.revision = revision
+	}
+	apply("u2/logical_port", `"lsp2"`, 4, false)
+	apply("u1/logical_port", `"lsp1"`, 5, false)
+	apply("u1/tunnel_key", `3`, 6, false)
+
+	rows, revision, ok := c.selectRows("Port_Binding", map[string]bool{"logical_port": true}, true, 0)
+	assert.True(t, ok)
+	assert.Equal(t, int64(6), revision)
+	assert.Equal(t, []map[string]interface{}{
+		{COL_UUID: ovsjson.Uuid("u1"), "logical_port": "lsp1", COL_VERSION: revisionToUuid(6)},
+		{COL_UUID: ovsjson.Uuid("u2"), "logical_port": "lsp2", COL_VERSION: revisionToUuid(4)},
+	}, rows)
+	_, _, ok = c.selectRows("Port_Binding", nil, true, 5)
+	assert.False(t, ok)
+
+	apply("u2/logical_port", "", 7, true)
+	rows, revision, ok = c.selectRows("Port_Binding", nil, false, 7)
+	assert.True(t, ok)
+	assert.Equal(t, int64(7), revision)
+	assert.Equal(t, []map[string]interface{}{
+		{COL_UUID: ovsjson.Uuid("u1"), "logical_port": "lsp1", "tunnel_key": int64(3)},
+	}, rows)
+	assert.Equal(t, map[string]int64{"u1": 6}, c.versions["Port_Binding"])
+}
+
+func TestCacheChangesSinceWithoutHistory(t *testing.T) {
+	c := newDBCache(klogr.New(), "OVN_Southbound", &ovsjson.DatabaseSchema{})
+	c.revision, c.historyStart = 4, 4
+	c.record(5, tablesChanges{})
+	c.record(6, tablesChanges{})
+
+	_, _, ok := c.changesSince(&monitor{}, nil, 4)
+	assert.False(t, ok)
+	_, _, ok = c.changesSince(&monitor{}, nil, 5)
+	assert.False(t, ok)
+	changes, revision, ok := c.changesSince(&monitor{}, nil, 6)
+	assert.True(t, ok)
+	assert.Equal(t, int64(6), revision)
+	assert.Empty(t, changes)
+}
@@ -0,0 +1,317 @@
+package ovsdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	ovsjson "github.com/ibm/ovsdb-etcd/pkg/json"
+)
+
+const (
+	FUNC_EQ       = "=="
+	FUNC_NE       = "!="
+	FUNC_LT       = "<"
+	FUNC_LE       = "<="
+	FUNC_GT       = ">"
+	FUNC_GE       = ">="
+	FUNC_INCLUDES = "includes"
+	FUNC_EXCLUDES = "excludes"
+)
+
+// <condition>, as defined by RFC 7047 section 5.1, or a boolean value, which is allowed in the "where" clauses of
+// monitor_cond requests.
+type condition struct {
+	column   string
+	function string
+	colType  *ovsjson.ColumnType
+	// the condition value, normalized by normalizeValue
+	value interface{}
+	// the condition is a constant true or false
+	isConst    bool
+	constValue bool
+}
+
+// newCondition parses a condition of a where clause of the table
+func newCondition(cond interface{}, table string, tableSchema *ovsjson.TableSchema) (*condition, error) {
+	if b, ok := cond.(bool); ok {
+		return &condition{isConst: true, constValue: b}, nil
+	}
+	elements, ok := cond.([]interface{})
+	if !ok || len(elements) != 3 {
+		return nil, fmt.Errorf("wrong condition %v", cond)
+	}
+	column, ok := elements[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("wrong condition column %v", elements[0])
+	}
+	function, ok := elements[1].(string)
+	if !ok {
+		return nil, fmt.Errorf("wrong condition function %v", elements[1])
+	}
+	c := &condition{column: column, function: function}
+	switch column {
+	case COL_UUID, COL_VERSION:
+		c.colType = &ovsjson.ColumnType{Key: &ovsjson.BaseType{Type: ovsjson.TypeUUID}, Min: 1, Max: 1}
+	default:
+		colSchema, ok := tableSchema.Columns[column]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %s in table %s", column, table)
+		}
+		c.colType = &colSchema.Type
+	}
+	switch function {
+	case FUNC_EQ, FUNC_NE, FUNC_INCLUDES, FUNC_EXCLUDES:
+	case FUNC_LT, FUNC_LE, FUNC_GT, FUNC_GE:
+		if c.colType.IsMap() || c.colType.Max != 1 ||
+			(c.colType.Key.Type != ovsjson.TypeInteger && c.colType.Key.Type != ovsjson.TypeReal) {
+			return nil, fmt.Errorf("function %s is not applicable to column %s", function, column)
+		}
+	default:
+		return nil, fmt.Errorf("unknown function %s", function)
+	}
+	value, err := normalizeValue(elements[2])
+	if err != nil {
+		return nil, err
+	}
+	c.value = value
+	return c, nil
+}
+
+// String returns the condition in the <condition> syntax, the constant conditions are true or false
+func (c *condition) String() string {
+	if c.isConst {
+		return fmt.Sprint(c.constValue)
+	}
+	value, err := json.Marshal(c.value)
+	if err != nil {
+		value = []byte(fmt.Sprint(c.value))
+	}
+	return fmt.Sprintf("[%q,%q,%s]", c.column, c.function, value)
+}
+
+// match evaluates the condition on the row, the row uuid is passed separately, since the rows don't contain it
+func (c *condition) match(rowUuid string, r row) (bool, error) {
+	if c.isConst {
+		return c.constValue, nil
+	}
+	var actual interface{}
+	var err error
+	switch c.column {
+	case COL_UUID:
+		actual, err = normalizeValue(ovsjson.Uuid(rowUuid))
+	default:
+		value, ok := r[c.column]
+		if !ok {
+			value = defaultValue(c.colType)
+		}
+		actual, err = normalizeValue(value)
+	}
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case c.colType.IsMap():
+		return c.matchMap(mapPairs(actual), mapPairs(c.value))
+	case c.colType.IsSet():
+		return c.matchSet(setElements(actual), setElements(c.value))
+	}
+	return c.matchAtom(atomValue(actual), atomValue(c.value))
+}
+
+func (c *condition) matchAtom(actual, expected interface{}) (bool, error) {
+	switch c.function {
+	case FUNC_EQ, FUNC_INCLUDES:
+		return reflect.DeepEqual(actual, expected), nil
+	case FUNC_NE, FUNC_EXCLUDES:
+		return !reflect.DeepEqual(actual, expected), nil
+	}
+	a, ok1 := actual.(float64)
+	e, ok2 := expected.(float64)
+	if !ok1 || !ok2 {
+		return false, fmt.Errorf("function %s requires numbers, got %v and %v", c.function, actual, expected)
+	}
+	switch c.function {
+	case FUNC_LT:
+		return a < e, nil
+	case FUNC_LE:
+		return a <= e, nil
+	case FUNC_GT:
+		return a > e, nil
+	case FUNC_GE:
+		return a >= e, nil
+	}
+	return false, fmt.Errorf("unknown function %s", c.function)
+}
+
+func (c *condition) matchSet(actual, expected []interface{}) (bool, error) {
+	switch c.function {
+	case FUNC_EQ:
+		return len(actual) == len(expected) && includesAll(actual, expected), nil
+	case FUNC_NE:
+		return len(actual) != len(expected) || !includesAll(actual, expected), nil
+	case FUNC_INCLUDES:
+		return includesAll(actual, expected), nil
+	case FUNC_EXCLUDES:
+		for _, e := range expected {
+			if contains(actual, e) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+	if len(actual) != 1 || len(expected) != 1 {
+		return false, nil
+	}
+	return c.matchAtom(actual[0], expected[0])
+}
+
+func (c *condition) matchMap(actual, expected map[string]interface{}) (bool, error) {
+	includes := func() bool {
+		for k, v := range expected {
+			if av, ok := actual[k]; !ok || !reflect.DeepEqual(av, v) {
+				return false
+			}
+		}
+		return true
+	}
+	switch c.function {
+	case FUNC_EQ:
+		return len(actual) == len(expected) && includes(), nil
+	case FUNC_NE:
+		return len(actual) != len(expected) || !includes(), nil
+	case FUNC_INCLUDES:
+		return includes(), nil
+	case FUNC_EXCLUDES:
+		for k, v := range expected {
+			if av, ok := actual[k]; ok && reflect.DeepEqual(av, v) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+	return false, fmt.Errorf("function %s is not applicable to maps", c.function)
+}
+
+// matchAny returns true if one of the conditions is true, or if there are no conditions at all
+func matchAny(conditions []*condition, rowUuid string, r row) (bool, error) {
+	if len(conditions) == 0 {
+		return true, nil
+	}
+	for _, c := range conditions {
+		ok, err := c.match(rowUuid, r)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// normalizeValue converts a value in the OVSDB wire format to its generic JSON representation, so values decoded from
+// etcd and values received from clients can be compared.
+func normalizeValue(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func atomValue(value interface{}) interface{} {
+	if arr, ok := value.([]interface{}); ok && len(arr) == 2 && (arr[0] == "uuid" || arr[0] == "named-uuid") {
+		return arr[1]
+	}
+	return value
+}
+
+func setElements(value interface{}) []interface{} {
+	elements := wireSetElements(value)
+	atoms := make([]interface{}, 0, len(elements))
+	for _, e := range elements {
+		atoms = append(atoms, atomValue(e))
+	}
+	return atoms
+}
+
+// wireSetElements returns the elements of a normalized <set> value, without converting them to atoms
+func wireSetElements(value interface{}) []interface{} {
+	if arr, ok := value.([]interface{}); ok && len(arr) == 2 && arr[0] == "set" {
+		elements, _ := arr[1].([]interface{})
+		return elements
+	}
+	return []interface{}{value}
+}
+
+// wireMapPairs returns the pairs of a normalized <map> value, keyed by the JSON representation of the keys
+func wireMapPairs(value interface{}) map[string][]interface{} {
+	pairs := map[string][]interface{}{}
+	arr, ok := value.([]interface{})
+	if !ok || len(arr) != 2 || arr[0] != "map" {
+		return pairs
+	}
+	elements, _ := arr[1].([]interface{})
+	for _, e := range elements {
+		pair, ok := e.([]interface{})
+		if !ok || len(pair) != 2 {
+			continue
+		}
+		key, _ := json.Marshal(pair[0])
+		pairs[string(key)] = pair
+	}
+	return pairs
+}
+
+// mapPairs returns the values of a normalized <map> value, keyed by the JSON representation of the keys
+func mapPairs(value interface{}) map[string]interface{} {
+	pairs := map[string]interface{}{}
+	for key, pair := range wireMapPairs(value) {
+		pairs[key] = atomValue(pair[1])
+	}
+	return pairs
+}
+
+func includesAll(set []interface{}, elements []interface{}) bool {
+	for _, e := range elements {
+		if !contains(set, e) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(set []interface{}, element interface{}) bool {
+	for _, s := range set {
+		if reflect.DeepEqual(s, element) {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultValue returns the value of a column that is not stored, as defined by RFC 7047 section 5.1
+func defaultValue(colType *ovsjson.ColumnType) interface{} {
+	if colType.IsMap() {
+		return ovsjson.Map{}
+	}
+	if colType.IsSet() {
+		return ovsjson.Set{}
+	}
+	switch colType.Key.Type {
+	case ovsjson.TypeInteger:
+		return int64(0)
+	case ovsjson.TypeReal:
+		return float64(0)
+	case ovsjson.TypeBoolean:
+		return false
+	case ovsjson.TypeUUID:
+		return ovsjson.Uuid(ovsjson.ZERO_UUID)
+	}
+	return ""
+}
@@ -0,0 +1,90 @@
+package ovsdb
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	ovsjson "github.com/ibm/ovsdb-etcd/pkg/json"
+)
+
+var portBindingSchema = `{
+	"columns": {
+		"logical_port": {"type": "string"},
+		"chassis": {"type": {"key": {"type": "uuid", "refTable": "Chassis", "refType": "weak"}, "min": 0, "max": 1}},
+		"tunnel_key": {"type": "integer"},
+		"mac": {"type": {"key": "string", "min": 0, "max": "unlimited"}},
+		"options": {"type": {"key": "string", "value": "string", "min": 0, "max": "unlimited"}}
+	}
+}`
+
+func testCondition(t *testing.T, cond string, r row, expected bool) {
+	var tableSchema ovsjson.TableSchema
+	assert.Nil(t, json.Unmarshal([]byte(portBindingSchema), &tableSchema))
+	var c interface{}
+	assert.Nil(t, json.Unmarshal([]byte(cond), &c))
+	condition, err := newCondition(c, "Port_Binding", &tableSchema)
+	assert.Nil(t, err, cond)
+	ok, err := condition.match("413afe3e-79ff-4583-88a6-f02b70b8e927", r)
+	assert.Nil(t, err, cond)
+	assert.Equal(t, expected, ok, cond)
+}
+
+func TestConditionAtoms(t *testing.T) {
+	r := row{"logical_port": "lsp1", "tunnel_key": int64(3)}
+	testCondition(t, `["logical_port", "==", "lsp1"]`, r, true)
+	testCondition(t, `["logical_port", "!=", "lsp1"]`, r, false)
+	testCondition(t, `["tunnel_key", "<", 4]`, r, true)
+	testCondition(t, `["tunnel_key", ">=", 4]`, r, false)
+	testCondition(t, `["_uuid", "==", ["uuid", "413afe3e-79ff-4583-88a6-f02b70b8e927"]]`, r, true)
+	testCondition(t, `true`, r, true)
+	testCondition(t, `false`, r, false)
+}
+
+func TestConditionSets(t *testing.T) {
+	chassis := ovsjson.Uuid("25f2e69e-4bac-4529-9082-9f94da060cf1")
+	r := row{"chassis": chassis, "mac": ovsjson.Set{"0a:58:0a:f4:00:03 10.244.0.3", "router"}}
+	testCondition(t, `["chassis", "==", ["uuid", "25f2e69e-4bac-4529-9082-9f94da060cf1"]]`, r, true)
+	testCondition(t, `["chassis", "==", ["set", []]]`, row{}, true)
+	testCondition(t, `["chassis", "==", ["set", []]]`, r, false)
+	testCondition(t, `["mac", "includes", "router"]`, r, true)
+	testCondition(t, `["mac", "excludes", "router"]`, r, false)
+	testCondition(t, `["mac", "==", ["set", ["router"]]]`, r, false)
+}
+
+func TestConditionMaps(t *testing.T) {
+	r := row{"options": ovsjson.Map{"requested-chassis": "node1", "peer": "lrp1"}}
+	testCondition(t, `["options", "includes", ["map", [["requested-chassis", "node1"]]]]`, r, true)
+	testCondition(t, `["options", "includes", ["map", [["requested-chassis", "node2"]]]]`, r, false)
+	testCondition(t, `["options", "excludes", ["map", [["requested-chassis", "node2"]]]]`, r, true)
+	testCondition(t, `["options", "==", ["map", []]]`, row{}, true)
+}
+
+func TestConditionErrors(t *testing.T) {
+	var tableSchema ovsjson.TableSchema
+	assert.Nil(t, json.Unmarshal([]byte(portBindingSchema), &tableSchema))
+	for _, cond := range []string{`["name", "==", "lsp1"]`, `["logical_port", "<", "lsp1"]`,
+		`["logical_port", "like", "lsp1"]`, `["logical_port", "=="]`, `"logical_port"`} {
+		var c interface{}
+		assert.Nil(t, json.Unmarshal([]byte(cond), &c))
+		_, err := newCondition(c, "Port_Binding", &tableSchema)
+		assert.NotNil(t, err, cond)
+	}
+}
+
+func TestConditionString(t *testing.T) {
+	var tableSchema ovsjson.TableSchema
+	assert.Nil(t, json.Unmarshal([]byte(portBindingSchema), &tableSchema))
+	for cond, expected := range map[string]string{
+		`["logical_port", "==", "lsp1"]`: `["logical_port","==","lsp1"]`,
+		`["tunnel_key", "<", 4]`:         `["tunnel_key","<",4]`,
+		`true`:                           `true`,
+	} {
+		var c interface{}
+		assert.Nil(t, json.Unmarshal([]byte(cond), &c))
+		condition, err := newCondition(c, "Port_Binding", &tableSchema)
+		assert.Nil(t, err, cond)
+		assert.Equal(t, expected, condition.String(), cond)
+	}
+}
@@ -0,0 +1,334 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/google/uuid"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	ovsdbjson "github.com/ibm/ovsdb-etcd/pkg/json"
+	"github.com/ibm/ovsdb-etcd/pkg/json/_Server"
+)
+
+const SCHEMAS_PREFIX = "schemas/"
+
+// WatchDatabases closes the connections of the clients that are not aware of database changes, when a database is
+// added, removed or its schema is converted, like ovsdb-server does. The aware clients learn about the changes from
+// their monitors of the _Server Database table. It returns when the context is canceled or the watch fails.
+func (con *DBServer) WatchDatabases(ctx context.Context) {
+	prefix := con.serverRowKey("")
+	for wresp := range con.cli.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithPrevKV()) {
+		if err := wresp.Err(); err != nil {
+			con.log.WithName("databases").Error(err, "Databases watch failed")
+			return
+		}
+		for _, ev := range wresp.Events {
+			if databaseChanged(ev) {
+				con.disconnectChangeUnaware()
+				break
+			}
+		}
+	}
+}
+
+// databaseChanged returns true if the event of a _Server Database row adds, removes or converts a database
+func databaseChanged(ev *clientv3.Event) bool {
+	if ev.Type == mvccpb.DELETE || ev.PrevKv == nil {
+		return true
+	}
+	var prev, db _Server.Database
+	if json.Unmarshal(ev.PrevKv.Value, &prev) != nil || json.Unmarshal(ev.Kv.Value, &db) != nil {
+		return true
+	}
+	return prev.Schema != db.Schema
+}
+
+func (con *DBServer) disconnectChangeUnaware() {
+	con.mu.Lock()
+	unaware := []*jrpc2.Server{}
+	for srv, cs := range con.sessions {
+		if !cs.dbChangeAware {
+			unaware = append(unaware, srv)
+		}
+	}
+	con.mu.Unlock()
+	con.log.WithName("databases").Info("Databases changed, closing the connections that are not aware of database changes",
+		"connections", len(unaware))
+	for _, srv := range unaware {
+		srv.Stop()
+	}
+}
+
+// StoreSchemas stores the schemas that were added by AddSchema in etcd, under [<namespace>/]SCHEMAS_PREFIX<db-name>,
+// unless the
+// databases already have stored schemas, and then loads all the stored schemas, so all the servers use the same
+// schemas. A stored schema whose version or cksum differs from the added schema is an error, unless migrate is true,
+// and then the added schema replaces it. It returns the etcd revision of the loaded schemas, WatchSchemas follows
+// their changes after it.
+func (con *DBServer) StoreSchemas(migrate bool) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), con.requestTimeout)
+	defer cancel()
+	con.schemasMu.RLock()
+	schemas := make(map[string]string, len(con.schemas))
+	dbSchemas := make(map[string]*ovsdbjson.DatabaseSchema, len(con.dbSchemas))
+	for dbName, schema := range con.schemas {
+		schemas[dbName] = schema
+		dbSchemas[dbName] = con.dbSchemas[dbName]
+	}
+	con.schemasMu.RUnlock()
+	for dbName, schema := range schemas {
+		key := con.schemaKey(dbName)
+		resp, err := con.cli.Txn(ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+			Then(clientv3.OpPut(key, schema)).
+			Else(clientv3.OpGet(key)).
+			Commit()
+		if err != nil {
+			return 0, err
+		}
+		if resp.Succeeded {
+			continue
+		}
+		kvs := resp.Responses[0].GetResponseRange().Kvs
+		if len(kvs) == 0 {
+			continue
+		}
+		stored, err := ovsdbjson.NewDatabaseSchema(kvs[0].Value)
+		if err != nil {
+			return 0, fmt.Errorf("stored schema of %s: %v", dbName, err)
+		}
+		local := dbSchemas[dbName]
+		if stored.Version == local.Version && stored.Cksum == local.Cksum {
+			continue
+		}
+		if !migrate {
+			return 0, fmt.Errorf("schema of %s, version %s cksum %q, doesn't match the stored schema, version %s "+
+				"cksum %q", dbName, local.Version, local.Cksum, stored.Version, stored.Cksum)
+		}
+		con.log.WithName("databases").Info("Migrating the stored schema", "db", dbName, "from", stored.Version,
+			"to", local.Version)
+		if _, err := con.cli.Put(ctx, key, schema); err != nil {
+			return 0, err
+		}
+	}
+	// the schemas of all the namespaces are read by a single revision
+	namespaces := con.usedNamespaces()
+	ops := make([]clientv3.Op, 0, len(namespaces))
+	for _, ns := range namespaces {
+		ops = append(ops, clientv3.OpGet(ns+SCHEMAS_PREFIX, clientv3.WithPrefix()))
+	}
+	resp, err := con.cli.Txn(ctx).Then(ops...).Commit()
+	if err != nil {
+		return 0, err
+	}
+	for i, ns := range namespaces {
+		for _, kv := range resp.Responses[i].GetResponseRange().Kvs {
+			dbName := strings.TrimPrefix(string(kv.Key), ns+SCHEMAS_PREFIX)
+			if con.namespace(dbName) != ns {
+				// a database of another deployment
+				continue
+			}
+			if _, err := con.loadSchema(dbName, kv.Value); err != nil {
+				return 0, fmt.Errorf("stored schema of %s: %v", dbName, err)
+			}
+		}
+	}
+	return resp.Header.Revision, nil
+}
+
+// WatchSchemas applies the changes of the stored schemas after the revision, e.g. of a database that was converted by
+// another server. The caches of the changed databases are dropped, so their monitors are canceled and the clients
+// monitor them again with the new schemas, and the clients that are not aware of database changes are disconnected.
+// The servers that change the stored schemas update the _Server Database rows of the databases too. It returns when
+// the context is canceled or the watch fails.
+func (con *DBServer) WatchSchemas(ctx context.Context, revision int64) {
+	var wg sync.WaitGroup
+	for _, ns := range con.usedNamespaces() {
+		wg.Add(1)
+		go func(ns string) {
+			defer wg.Done()
+			con.watchSchemas(ctx, ns, revision)
+		}(ns)
+	}
+	wg.Wait()
+}
+
+// watchSchemas applies the changes of the stored schemas of a namespace
+func (con *DBServer) watchSchemas(ctx context.Context, ns string, revision int64) {
+	prefix := ns + SCHEMAS_PREFIX
+	wch := con.cli.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(revision+1))
+	for wresp := range wch {
+		if err := wresp.Err(); err != nil {
+			con.log.WithName("databases").Error(err, "Schemas watch failed", "namespace", ns)
+			return
+		}
+		for _, ev := range wresp.Events {
+			dbName := strings.TrimPrefix(string(ev.Kv.Key), prefix)
+			if con.namespace(dbName) != ns {
+				continue
+			}
+			if ev.Type == mvccpb.DELETE {
+				con.log.WithName("databases").Info("Database was removed", "db", dbName)
+				con.removeSchema(dbName)
+				con.stopCampaign(dbName)
+				con.dropCache(dbName)
+				con.disconnectChangeUnaware()
+				continue
+			}
+			changed, err := con.loadSchema(dbName, ev.Kv.Value)
+			if err != nil {
+				con.log.WithName("databases").Error(err, "Wrong stored schema", "db", dbName)
+				continue
+			}
+			if changed {
+				con.startCampaign(dbName)
+				con.dropCache(dbName)
+				con.disconnectChangeUnaware()
+			}
+		}
+	}
+}
+
+// the singleton tables of the OVN databases, by database name
+var bootstrapTables = map[string]string{
+	"OVN_Northbound": "NB_Global",
+	"OVN_Southbound": "SB_Global",
+}
+
+// Bootstrap creates the singleton NB_Global and SB_Global rows of the OVN databases, unless their tables already have
+// rows, so ovn-northd can use a fresh cluster. The columns of the rows have their default values.
+func (con *DBServer) Bootstrap() error {
+	ctx, cancel := context.WithTimeout(context.Background(), con.requestTimeout)
+	defer cancel()
+	for dbName, table := range bootstrapTables {
+		_, dbSchema, ok := con.lookupSchema(dbName)
+		if !ok {
+			continue
+		}
+		tableSchema, ok := dbSchema.Tables[table]
+		if !ok {
+			return fmt.Errorf("schema of %s doesn't have table %s", dbName, table)
+		}
+		tablePrefix := con.dbPrefix(dbName) + table + "/"
+		rowPrefix := tablePrefix + uuid.NewString() + "/"
+		ops := []clientv3.Op{}
+		for colName, colSchema := range tableSchema.Columns {
+			value, err := encodeValue(defaultValue(&colSchema.Type), &colSchema.Type)
+			if err == nil {
+				value, err = con.storedValue(rowPrefix+colName, value)
+			}
+			if err != nil {
+				return fmt.Errorf("column %s of %s: %v", colName, table, err)
+			}
+			ops = append(ops, clientv3.OpPut(rowPrefix+colName, value))
+		}
+		resp, err := con.cli.Txn(ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(tablePrefix), "=", 0).WithPrefix()).
+			Then(ops...).
+			Commit()
+		if err != nil {
+			return err
+		}
+		if resp.Succeeded {
+			con.log.WithName("databases").Info("Bootstrap, created a row", "db", dbName, "table", table)
+		}
+	}
+	return nil
+}
+
+// AddDatabase adds a database with the schema at runtime, all the servers start to serve it. The rows of a database
+// that was removed by RemoveDatabase are kept in etcd, so adding it again restores them.
+func (con *DBServer) AddDatabase(ctx context.Context, schema []byte) (string, error) {
+	if con.relay != nil {
+		return "", fmt.Errorf("databases cannot be changed by relays")
+	}
+	dbSchema, err := ovsdbjson.NewDatabaseSchema(schema)
+	if err != nil {
+		return "", err
+	}
+	dbName := dbSchema.Name
+	if len(dbName) == 0 || dbName == "_Server" || strings.Contains(dbName, "/") {
+		return "", fmt.Errorf("wrong database name %q", dbName)
+	}
+	db := _Server.Database{Model: "standalone", Name: dbName, Uuid: ovsdbjson.Uuid(uuid.NewString()),
+		Connected: true, Leader: true, Schema: string(schema), Version: ovsdbjson.Uuid(uuid.NewString())}
+	data, err := json.Marshal(db)
+	if err != nil {
+		return "", err
+	}
+	key := con.schemaKey(dbName)
+	ctx, cancel := context.WithTimeout(ctx, con.requestTimeout)
+	defer cancel()
+	resp, err := con.cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, string(schema)), clientv3.OpPut(con.serverRowKey(dbName), string(data))).
+		Commit()
+	if err != nil {
+		return "", err
+	}
+	if !resp.Succeeded {
+		return "", fmt.Errorf("database %s already exists", dbName)
+	}
+	// the schema is loaded by WatchSchemas too, loading it here serves it right away
+	if _, err := con.loadSchema(dbName, schema); err != nil {
+		return "", err
+	}
+	con.startCampaign(dbName)
+	return dbName, nil
+}
+
+// RemoveDatabase stops serving the database on all the servers, the monitors of the database are canceled and the
+// clients that are not aware of database changes are disconnected. The rows of the database are kept in etcd.
+func (con *DBServer) RemoveDatabase(ctx context.Context, dbName string) error {
+	if con.relay != nil {
+		return fmt.Errorf("databases cannot be changed by relays")
+	}
+	if dbName == "_Server" {
+		return fmt.Errorf("cannot remove database %s", dbName)
+	}
+	key := con.schemaKey(dbName)
+	ctx, cancel := context.WithTimeout(ctx, con.requestTimeout)
+	defer cancel()
+	resp, err := con.cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), ">", 0)).
+		Then(clientv3.OpDelete(key), clientv3.OpDelete(con.serverRowKey(dbName))).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return fmt.Errorf("unknown database %s", dbName)
+	}
+	return nil
+}
+
+func (con *DBServer) removeSchema(dbName string) {
+	con.schemasMu.Lock()
+	defer con.schemasMu.Unlock()
+	delete(con.schemas, dbName)
+	delete(con.dbSchemas, dbName)
+}
+
+// loadSchema sets the schema of the database to a stored schema, it returns false if the schema is not changed
+func (con *DBServer) loadSchema(dbName string, data []byte) (bool, error) {
+	schema, _, ok := con.lookupSchema(dbName)
+	if ok && schema == string(data) {
+		return false, nil
+	}
+	dbSchema, err := ovsdbjson.NewDatabaseSchema(data)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		con.log.WithName("databases").Info("Schema was replaced by the stored schema", "db", dbName,
+			"version", dbSchema.Version)
+	}
+	con.setSchema(dbName, string(data), dbSchema)
+	return true, nil
+}
@@ -2,97 +2,365 @@ package ovsdb
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/creachadair/jrpc2"
+	"github.com/go-logr/logr"
 	"github.com/google/uuid"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	clientv3 "go.etcd.io/etcd/client/v3"
-	"go.etcd.io/etcd/client/v3/concurrency"
+	"go.etcd.io/etcd/client/v3/namespace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
 
 	ovsdbjson "github.com/ibm/ovsdb-etcd/pkg/json"
 	"github.com/ibm/ovsdb-etcd/pkg/json/_Server"
+	"github.com/ibm/ovsdb-etcd/pkg/klogr"
+	"github.com/ibm/ovsdb-etcd/pkg/stats"
+	"github.com/ibm/ovsdb-etcd/pkg/trace"
 )
 
+const (
+	COL_UUID    = "_uuid"
+	COL_VERSION = "_version"
+)
+
+const SERVERS_PREFIX = "servers/"
+
 type DBServer struct {
-	cli         *clientv3.Client
-	uuid        string
-	schemas     map[string]string
-	schemaTypes map[string]map[string]map[string]string
+	cli  *clientv3.Client
+	uuid string
+	// schemasMu protects the schemas, they are changed when the schemas that are stored in etcd change
+	schemasMu sync.RWMutex
+	schemas   map[string]string
+	dbSchemas map[string]*ovsdbjson.DatabaseSchema
+	mu        sync.Mutex
+	sessions  map[*jrpc2.Server]*ClientSession
+	// set when the server starts to shut down, protected by mu
+	shuttingDown bool
+	// the client connections that were numbered for the metrics, protected by mu
+	connections  uint64
+	transactions sync.WaitGroup
+	// relay is set if the server relays the databases of an upstream server, rather than serving them from etcd
+	relay *relay
+	// the databases that this server leads, and the elections of their leaders
+	leadersMu  sync.Mutex
+	leaders    map[string]bool
+	elections  *elections
+	leaderOnly bool
+	// set when the server is ready to serve clients, protected by leadersMu
+	ready bool
+	// cachesMu protects the caches, every database has a single cache
+	cachesMu sync.Mutex
+	caches   map[string]*dbCache
+	// the interval of merging monitor notifications, 0 if every change is sent immediately
+	monitorFlushInterval time.Duration
+	notificationLimits   NotificationLimits
+	// the number of recent revisions that every database cache keeps for monitor_cond_since requests
+	monitorHistorySize int
+	// set if the selects read the rows from the database caches rather than from etcd
+	cachedSelects bool
+	// the maximal number of rows of a select, 0 if it's unlimited
+	selectLimit int
+	// set if the selects that are read from etcd are served by the local etcd member without consensus
+	serializableReads bool
+	// the encoding of the stored column values
+	encoding valueEncoding
+	// the stored values whose checksums didn't match, and the key of the last one, protected by mu
+	corruptedValues  int64
+	lastCorruptedKey string
+	// the tables of ephemeral rows, by <db-name>/<table>
+	ephemeralTables map[string]bool
+	// the timeout of the etcd requests
+	requestTimeout time.Duration
+	// the configured etcd endpoints, and their state by the health checks
+	endpoints []string
+	healthMu  sync.Mutex
+	health    map[string]*EndpointHealth
+	// the circuit breaker of the etcd requests
+	breaker breaker
+	// the access control list of the clients, nil if all the clients may run all the operations
+	acl []ACLRule
+	// the key prefixes of the etcd namespaces of the databases, by database name
+	namespaces map[string]string
+	metrics    *serverMetrics
+	log        logr.Logger
+	// the thresholds of logging the summaries of slow and large transactions
+	slowTransactionLimits SlowTransactionLimits
+}
+
+// The default timeouts of the etcd client
+const (
+	ETCD_DIAL_TIMEOUT    = 5 * time.Second
+	ETCD_REQUEST_TIMEOUT = 5 * time.Second
+)
+
+// EtcdConfig configures the etcd client of the server
+type EtcdConfig struct {
+	Endpoints []string
+	// the prefix of all the keys of the server, so several deployments can share an etcd cluster
+	Prefix string
+	// the TLS configuration of the connections, with the client certificate and the CA certificates of the etcd
+	// members, nil for plaintext connections
+	TLS *tls.Config
+	// the user of etcd authentication, if it's set the client gets an authentication token by the password
+	Username string
+	Password string
+	// an authentication token, that is used instead of Username and Password
+	Token string
+	// the timeout of connecting to the etcd members, ETCD_DIAL_TIMEOUT if it's 0
+	DialTimeout time.Duration
+	// the timeout of every etcd request, ETCD_REQUEST_TIMEOUT if it's 0
+	RequestTimeout time.Duration
+	// the client pings the etcd members after KeepAliveTime of inactivity, also without active requests, and closes
+	// the connection if the ping isn't answered within KeepAliveTimeout, 0 disables the pings
+	KeepAliveTime    time.Duration
+	KeepAliveTimeout time.Duration
+	// the size limits of the gRPC messages, the etcd client defaults if they are 0
+	MaxSendMsgSize int
+	MaxRecvMsgSize int
+	// the backoff of reconnecting to the etcd members, the gRPC defaults if they are 0
+	ReconnectBaseDelay time.Duration
+	ReconnectMaxDelay  time.Duration
+}
+
+// EtcdTLSConfig returns the TLS configuration of etcd connections, with the client certificate, if certFile is set,
+// and the CA certificates that verify the etcd members, if caFile is set
+func EtcdTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	config := &tls.Config{}
+	if len(certFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if len(caFile) > 0 {
+		data, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+	}
+	return config, nil
+}
+
+// tokenCredentials sends the etcd authentication token with every request
+type tokenCredentials string
+
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{rpctypes.TokenFieldNameGRPC: string(t)}, nil
+}
+
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return false
 }
 
-func NewDBServer(endpoints []string) (*DBServer, error) {
-	cli, err := clientv3.New(clientv3.Config{
-		Endpoints:   endpoints,
-		DialTimeout: 5 * time.Second,
-	})
+func NewDBServer(config EtcdConfig) (*DBServer, error) {
+	if config.DialTimeout == 0 {
+		config.DialTimeout = ETCD_DIAL_TIMEOUT
+	}
+	if config.RequestTimeout == 0 {
+		config.RequestTimeout = ETCD_REQUEST_TIMEOUT
+	}
+	etcdConfig := clientv3.Config{
+		Endpoints:            config.Endpoints,
+		DialTimeout:          config.DialTimeout,
+		TLS:                  config.TLS,
+		Username:             config.Username,
+		Password:             config.Password,
+		DialKeepAliveTime:    config.KeepAliveTime,
+		DialKeepAliveTimeout: config.KeepAliveTimeout,
+		PermitWithoutStream:  config.KeepAliveTime > 0,
+		MaxCallSendMsgSize:   config.MaxSendMsgSize,
+		MaxCallRecvMsgSize:   config.MaxRecvMsgSize,
+	}
+	if len(config.Token) > 0 {
+		etcdConfig.DialOptions = append(etcdConfig.DialOptions,
+			grpc.WithPerRPCCredentials(tokenCredentials(config.Token)))
+	}
+	if config.ReconnectBaseDelay > 0 || config.ReconnectMaxDelay > 0 {
+		// every connection attempt is bounded by the dial timeout
+		params := grpc.ConnectParams{Backoff: backoff.DefaultConfig, MinConnectTimeout: config.DialTimeout}
+		if config.ReconnectBaseDelay > 0 {
+			params.Backoff.BaseDelay = config.ReconnectBaseDelay
+		}
+		if config.ReconnectMaxDelay > 0 {
+			params.Backoff.MaxDelay = config.ReconnectMaxDelay
+		}
+		etcdConfig.DialOptions = append(etcdConfig.DialOptions, grpc.WithConnectParams(params))
+	}
+	cli, err := clientv3.New(etcdConfig)
 	if err != nil {
-		fmt.Println("NewETCDConenctor , error: ", err)
 		return nil, err
 	}
+	if len(config.Prefix) > 0 {
+		cli.KV = namespace.NewKV(cli.KV, config.Prefix)
+		cli.Watcher = namespace.NewWatcher(cli.Watcher, config.Prefix)
+		cli.Lease = namespace.NewLease(cli.Lease, config.Prefix)
+	}
 	// TODO
 	//defer cli.Close()
-	fmt.Println("etcd client is connected")
-	return &DBServer{cli: cli,
-		uuid:        uuid.NewString(),
-		schemas:     make(map[string]string),
-		schemaTypes: make(map[string]map[string]map[string]string)}, nil
+	con := &DBServer{cli: cli,
+		uuid:           uuid.NewString(),
+		schemas:        make(map[string]string),
+		dbSchemas:      make(map[string]*ovsdbjson.DatabaseSchema),
+		sessions:       make(map[*jrpc2.Server]*ClientSession),
+		caches:         make(map[string]*dbCache),
+		leaders:        make(map[string]bool),
+		requestTimeout: config.RequestTimeout,
+		endpoints:      config.Endpoints,
+		metrics:        newServerMetrics(stats.NewRegistry()),
+		log:            klogr.New()}
+	con.log.WithName("etcd").V(4).Info("Created the etcd client", "endpoints", strings.Join(config.Endpoints, ","))
+	return con, nil
+}
+
+// SetLogger sets the logger of the server, the messages are written by klog by default. The messages have the
+// key-value pairs of their context, e.g. "db", "table", "op", "uuid" and "revision", so embedders can route them to
+// a structured logger. It should be called before the server is used.
+func (con *DBServer) SetLogger(log logr.Logger) {
+	con.log = log
+}
+
+// LOG_MODULES are the names of the loggers of the server, i.e. the first names of their messages. The verbosity of
+// every module can be set by klogr.SetVerbosity, e.g. the requests of the clients are logged by "rpc" at V(6), and the
+// operations of the transactions are logged by "transact" at V(6).
+var LOG_MODULES = []string{"cache", "databases", "elections", "etcd", "locks", "maintenance", "monitor", "notifier",
+	"relay", "rpc", "shutdown", "sync", "transact"}
+
+// SetMonitorFlushInterval sets the interval during which the changes of every monitor are collected and sent to the
+// client as a single notification. The changes of a row during the interval are merged into a single row update.
+func (con *DBServer) SetMonitorFlushInterval(interval time.Duration) {
+	con.monitorFlushInterval = interval
+}
+
+// SetMonitorHistorySize sets the number of recent revisions that are kept in memory for every monitored database, so
+// monitor_cond_since requests of reconnecting clients can be served without reading the etcd history. 0 disables the
+// history.
+func (con *DBServer) SetMonitorHistorySize(size int) {
+	con.monitorHistorySize = size
+}
+
+// SetCachedSelects sets whether the selects read the rows from the database caches, which are kept up to date by the
+// etcd watches, rather than by etcd requests. The rows of a cache may lag behind etcd by the latency of the watch.
+// All the selects of a transaction read the same revision, when the cache has moved on to a newer revision, the
+// rows of the revision of the transaction are read from etcd.
+func (con *DBServer) SetCachedSelects(enabled bool) {
+	con.cachedSelects = enabled
 }
 
-func (con *DBServer) Lock(ctx context.Context, id string) (bool, error) {
-	cnx := context.TODO()
-	session, err := concurrency.NewSession(con.cli, concurrency.WithContext(cnx))
+// The number of keys of every etcd request of a select or of a cache load, the rows of a large table are decoded page
+// by page, so the whole etcd response of the table isn't held in memory together with the decoded rows
+const SELECT_PAGE_SIZE = 10000
+
+// errSelectLimit is returned for the selects of more rows than the select limit
+type errSelectLimit struct {
+	table string
+	limit int
+}
+
+func (e errSelectLimit) Error() string {
+	return fmt.Sprintf("the select of table %s exceeds the limit of %d rows", e.table, e.limit)
+}
+
+// SetSelectLimit sets the maximal number of rows of a select, the selects of more rows fail with a "resources
+// exhausted" error rather than holding the whole table in memory. When a limit is set, the selects don't load the
+// database caches, which hold the whole database, they read a cache only if it was already loaded for the monitors.
+// 0 disables the limit.
+func (con *DBServer) SetSelectLimit(limit int) {
+	con.selectLimit = limit
+}
+
+// SetSerializableReads sets whether the selects that are read from etcd use serializable reads, which are served by
+// the etcd member that the client is connected to, without a round of consensus. The reads are faster, but they may
+// return a revision that is older than the latest one. All the selects of a transaction still read a single
+// revision, and the reads of a revision that the member didn't apply yet are retried.
+func (con *DBServer) SetSerializableReads(enabled bool) {
+	con.serializableReads = enabled
+}
+
+// SetValueCompression sets the length above which the column values are compressed by gzip when they are stored, to
+// keep large rows within the etcd request limits. The compressed values are decompressed when they are read,
+// regardless of the threshold. 0 disables the compression.
+func (con *DBServer) SetValueCompression(threshold int) {
+	con.encoding.compressionThreshold = threshold
+}
+
+// SetValueChecksums sets whether the column values are stored with checksums. The checksums of the values are
+// verified when they are read, regardless of the setting, and the values that don't match are reported as corrupted.
+func (con *DBServer) SetValueChecksums(enabled bool) {
+	con.encoding.checksums = enabled
+}
+
+// SetValueEncryption sets the AES key, of 16, 24 or 32 bytes, by which the column values are encrypted by AES-GCM when
+// they are stored, for deployments whose etcd doesn't encrypt its data. Embedders can get the key from their key
+// management service. The keys of the values and the schemas are not encrypted. The encrypted values are decrypted
+// when they are read, so all the servers of the deployment need the key.
+func (con *DBServer) SetValueEncryption(key []byte) error {
+	aead, err := newAEAD(key)
 	if err != nil {
-		return false, err
-	}
-	mutex := concurrency.NewMutex(session, "locks/"+id)
-	err = mutex.TryLock(cnx)
-	unlock := func() {
-		server := jrpc2.ServerFromContext(ctx)
-		server.Wait()
-		fmt.Println("UNLOCK")
-		err = mutex.Unlock(cnx)
-		if err != nil {
-			fmt.Errorf("Unlock returned %v\n", err)
-		} else {
-			fmt.Printf("UNLOCKED done\n")
-		}
-	}
-	if err == nil {
-		go unlock()
-		return true, nil
+		return err
 	}
-	go func() {
-		err = mutex.Lock(cnx)
-		if err == nil {
-			// Send notification
-			fmt.Println("Locked")
-			go unlock()
-			if err := jrpc2.PushNotify(ctx, "locked", []string{id}); err != nil {
-				fmt.Printf("notification %v\n", err)
-				return
+	con.encoding.aead = aead
+	return nil
+}
 
-			}
-		} else {
-			fmt.Printf("Lock error %v\n", err)
-		}
-	}()
-	return false, nil
+// storedValue returns the encoded column value as it's stored in the etcd key, according to the settings of the server
+func (con *DBServer) storedValue(key, encoded string) (string, error) {
+	return con.encoding.store(key, encoded)
+}
+
+// valueCorrupted reports a stored value of the database whose checksum doesn't match
+func (con *DBServer) valueCorrupted(dbName, key string) {
+	con.metrics.corruptedValues.Inc(dbName)
+	con.mu.Lock()
+	con.corruptedValues++
+	con.lastCorruptedKey = key
+	con.mu.Unlock()
+	con.log.WithName("etcd").Error(errChecksum, "Corrupted value", "db", dbName, "key", key)
+}
+
+// SetNotificationLimits sets the limits of the notifications that are queued for a single client, and the policy of
+// handling clients that exceed them.
+func (con *DBServer) SetNotificationLimits(limits NotificationLimits) {
+	con.notificationLimits = limits
 }
 
-func (con *DBServer) Unlock(ctx context.Context, id string) error {
-	cnx := context.TODO()
-	session, err := concurrency.NewSession(con.cli, concurrency.WithContext(cnx))
+// LoadServerID sets the server id to the id that is stored in etcd for the server name, a new id is generated and
+// stored when the server starts for the first time, so the id of a server doesn't change when it's restarted.
+func (con *DBServer) LoadServerID(serverName string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), con.requestTimeout)
+	defer cancel()
+	key := con.serverNamespace() + SERVERS_PREFIX + serverName + "/id"
+	resp, err := con.cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, con.uuid)).
+		Else(clientv3.OpGet(key)).
+		Commit()
 	if err != nil {
 		return err
 	}
-	mutex := concurrency.NewMutex(session, "locks/"+id)
-	// TODO
-	fmt.Printf("is owner %+v\n", mutex.IsOwner())
-	mutex.Unlock(ctx)
+	if !resp.Succeeded {
+		kvs := resp.Responses[0].GetResponseRange().Kvs
+		if len(kvs) == 0 {
+			return fmt.Errorf("server id of %s was deleted", serverName)
+		}
+		con.uuid = string(kvs[0].Value)
+	}
+	con.log.Info("Loaded the server id", "server", serverName, "id", con.uuid)
 	return nil
 }
 
@@ -101,24 +369,75 @@ func (con *DBServer) AddSchema(schemaName, schemaFile string) error {
 	if err != nil {
 		return err
 	}
-	con.schemas[schemaName] = string(data)
+	return con.AddSchemaData(schemaName, data)
+}
+
+// AddSchemaData adds a schema that is not read from a file, e.g. a bundled schema
+func (con *DBServer) AddSchemaData(schemaName string, data []byte) error {
+	dbSchema, err := ovsdbjson.NewDatabaseSchema(data)
+	if err != nil {
+		return err
+	}
+	con.setSchema(schemaName, string(data), dbSchema)
 	return nil
 }
 
-func (con *DBServer) LoadServerData() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+func (con *DBServer) setSchema(dbName, schema string, dbSchema *ovsdbjson.DatabaseSchema) {
+	con.schemasMu.Lock()
+	defer con.schemasMu.Unlock()
+	con.schemas[dbName] = schema
+	con.dbSchemas[dbName] = dbSchema
+}
+
+// lookupSchema returns the schema of the database and its parsed form
+func (con *DBServer) lookupSchema(dbName string) (string, *ovsdbjson.DatabaseSchema, bool) {
+	con.schemasMu.RLock()
+	defer con.schemasMu.RUnlock()
+	dbSchema, ok := con.dbSchemas[dbName]
+	return con.schemas[dbName], dbSchema, ok
+}
+
+// databaseSchemas returns the parsed schemas of all the databases
+func (con *DBServer) databaseSchemas() map[string]*ovsdbjson.DatabaseSchema {
+	con.schemasMu.RLock()
+	defer con.schemasMu.RUnlock()
+	dbSchemas := make(map[string]*ovsdbjson.DatabaseSchema, len(con.dbSchemas))
+	for dbName, dbSchema := range con.dbSchemas {
+		dbSchemas[dbName] = dbSchema
+	}
+	return dbSchemas
+}
+
+// StoreServerRows stores the _Server Database rows of the databases
+func (con *DBServer) StoreServerRows() error {
+	ctx, cancel := context.WithTimeout(context.Background(), con.requestTimeout)
+	defer cancel()
+	con.schemasMu.RLock()
+	schemas := make(map[string]string, len(con.schemas))
 	for schemaName, schema := range con.schemas {
+		schemas[schemaName] = schema
+	}
+	con.schemasMu.RUnlock()
+	for schemaName, schema := range schemas {
 		srv := _Server.Database{Model: "standalone", Name: schemaName, Uuid: ovsdbjson.Uuid(uuid.NewString()),
 			Connected: true, Leader: true, Schema: schema, Version: ovsdbjson.Uuid(uuid.NewString())}
 		data, err := json.Marshal(srv)
 		if err != nil {
 			return err
 		}
-		_, err = con.cli.Put(ctx, "ovsdb/_Server/Database/"+schemaName, string(data))
+		_, err = con.cli.Put(ctx, con.serverRowKey(schemaName), string(data))
 		if err != nil {
 			return err
 		}
 	}
+	return nil
+}
+
+func (con *DBServer) LoadServerData() error {
+	if err := con.StoreServerRows(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), con.requestTimeout)
 
 	// OVN_Northbound
 	// NB_Global
@@ -191,8 +510,7 @@ func (con *DBServer) LoadServerData() error {
 }
 
 func (con *DBServer) GetData(prefix string, keysOnly bool) (*clientv3.GetResponse, error) {
-	fmt.Printf("GetData " + prefix)
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), con.requestTimeout)
 	var resp *clientv3.GetResponse
 	var err error
 	if keysOnly {
@@ -204,50 +522,221 @@ func (con *DBServer) GetData(prefix string, keysOnly bool) (*clientv3.GetRespons
 	if err != nil {
 		return nil, err
 	}
-	fmt.Printf(" GetDatatype %T \n", resp.Kvs)
-	for k, v := range resp.Kvs {
-		fmt.Printf("GetData k %v, v %v\n", k, v)
-	}
+	con.log.WithName("etcd").V(6).Info("GetData", "prefix", prefix, "keys", len(resp.Kvs))
 	return resp, err
 }
 
-func (con *DBServer) GetMarshaled(prefix string, columns []interface{}) (*[]map[string]string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
-	resp, err := con.cli.Get(ctx, prefix, clientv3.WithFromKey())
+// ListDatabases returns the sorted names of the hosted databases, which are the rows of the _Server Database table
+func (con *DBServer) ListDatabases(ctx context.Context) ([]string, error) {
+	if con.relay != nil {
+		return con.relay.databases(), nil
+	}
+	prefix := con.serverRowKey("")
+	ctx, cancel := context.WithTimeout(ctx, con.requestTimeout)
+	resp, err := con.cli.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
 	cancel()
 	if err != nil {
 		return nil, err
 	}
-	retMaps := map[string]map[string]string{}
-	columnsMap := map[string]bool{}
-	for _, col := range columns {
-		columnsMap[col.(string)] = true
-	}
-	fmt.Printf("GetMarshaled columnsMap = %+v\n", columnsMap)
-	for _, v := range resp.Kvs {
-		keys := strings.Split(string(v.Key), "/")
-		len := len(keys)
-		fmt.Printf("GetMarshaled col name = %s %s\n", keys[len-1], string(v.Key))
-		if _, ok := columnsMap[keys[len-1]]; !ok {
-			fmt.Printf("GetMarshaled NO\n")
+	dbs := []string{}
+	for _, kv := range resp.Kvs {
+		dbName := strings.TrimPrefix(string(kv.Key), prefix)
+		if dbName == "" || strings.Contains(dbName, "/") {
 			continue
 		}
-		valsmap, ok := retMaps[keys[len-3]]
-		fmt.Printf("GetMarshaled valsmap=%v\n", valsmap)
+		dbs = append(dbs, dbName)
+	}
+	sort.Strings(dbs)
+	return dbs, nil
+}
+
+// GetSchema returns the <database-schema> of the database, as it was loaded, including its version and cksum. The
+// schemas of databases that were added by other servers are read from their _Server Database rows.
+func (con *DBServer) GetSchema(ctx context.Context, dbName string) (json.RawMessage, error) {
+	if schema, _, ok := con.lookupSchema(dbName); ok {
+		return json.RawMessage(schema), nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, con.requestTimeout)
+	resp, err := con.cli.Get(ctx, con.serverRowKey(dbName))
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, fmt.Errorf("unknown database")
+	}
+	var db _Server.Database
+	if err := json.Unmarshal(resp.Kvs[0].Value, &db); err != nil {
+		return nil, err
+	}
+	if db.Schema == "" || !json.Valid([]byte(db.Schema)) {
+		return nil, fmt.Errorf("wrong schema of database %s", dbName)
+	}
+	return json.RawMessage(db.Schema), nil
+}
+
+// Select returns the rows of the table in the OVSDB wire format. Every column of a row is stored under its own key:
+// ovsdb/<db-name>/<table>/<uuid>/<column>. If columns is nil, all the stored columns are returned. The "_uuid" column
+// is always returned, "_version" is returned if columns is nil or if it is requested explicitly. The rows are read at
+// the given etcd revision, or at the current revision if it's 0, and the revision of the rows is returned. If cached
+// selects are enabled, the rows are read from the database cache when it's at the revision.
+func (con *DBServer) Select(ctx context.Context, dbName, table string, columns []interface{},
+	revision int64) ([]map[string]interface{}, int64, error) {
+	_, dbSchema, ok := con.lookupSchema(dbName)
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown database %s", dbName)
+	}
+	tableSchema, ok := dbSchema.Tables[table]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown table %s", table)
+	}
+	columnsMap, err := selectColumns(table, tableSchema, columns)
+	if err != nil {
+		return nil, 0, err
+	}
+	if con.relay != nil {
+		return con.relay.selectRows(dbName, table, columnsMap)
+	}
+	withVersion := columnsMap == nil || columnsMap[COL_VERSION]
+	if con.cachedSelects {
+		var c *dbCache
+		var err error
+		if con.selectLimit > 0 {
+			// the select limit bounds the memory of a select, while a cache holds the whole database, so the selects
+			// use only the caches that the monitors already loaded
+			var ok bool
+			if c, ok = con.loadedCache(dbName); !ok {
+				err = fmt.Errorf("the cache is not loaded")
+			}
+		} else {
+			c, err = con.getCache(ctx, dbName)
+		}
+		if err != nil {
+			con.log.WithName("cache").V(5).Info("Selecting from etcd, the cache can't be loaded", "db", dbName,
+				"error", err)
+		} else if rows, rev, ok := c.selectRows(table, columnsMap, withVersion, revision); ok {
+			if con.selectLimit > 0 && len(rows) > con.selectLimit {
+				return nil, 0, errSelectLimit{table: table, limit: con.selectLimit}
+			}
+			trace.FromContext(ctx).SetAttribute("cached", true)
+			return rows, rev, nil
+		}
+	}
+	prefix := con.dbPrefix(dbName) + table + "/"
+	end := clientv3.GetPrefixRangeEnd(prefix)
+	rowsMap := map[string]map[string]interface{}{}
+	versions := map[string]int64{}
+	var uuids []string
+	// the pages are read at the revision of the first page, so together they are a consistent snapshot of the table
+	for key := prefix; ; {
+		opts := []clientv3.OpOption{clientv3.WithRange(end), clientv3.WithLimit(SELECT_PAGE_SIZE)}
+		if revision > 0 {
+			opts = append(opts, clientv3.WithRev(revision))
+		}
+		if con.serializableReads {
+			opts = append(opts, clientv3.WithSerializable())
+		}
+		var resp *clientv3.GetResponse
+		err = con.retry(ctx, "get", func(ctx context.Context) error {
+			resp, err = con.cli.Get(ctx, key, opts...)
+			return err
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		if revision == 0 {
+			revision = resp.Header.Revision
+		}
+		for _, kv := range resp.Kvs {
+			keys := strings.Split(strings.TrimPrefix(string(kv.Key), prefix), "/")
+			if len(keys) != 2 {
+				continue
+			}
+			rowUuid, colName := keys[0], keys[1]
+			row, ok := rowsMap[rowUuid]
+			if !ok {
+				if con.selectLimit > 0 && len(uuids) == con.selectLimit {
+					return nil, 0, errSelectLimit{table: table, limit: con.selectLimit}
+				}
+				row = map[string]interface{}{COL_UUID: ovsdbjson.Uuid(rowUuid)}
+				rowsMap[rowUuid] = row
+				uuids = append(uuids, rowUuid)
+			}
+			// the row version is the last revision in which one of its columns was modified
+			if kv.ModRevision > versions[rowUuid] {
+				versions[rowUuid] = kv.ModRevision
+			}
+			if columnsMap != nil && !columnsMap[colName] {
+				continue
+			}
+			colSchema, ok := tableSchema.Columns[colName]
+			if !ok {
+				return nil, 0, fmt.Errorf("unknown column %s in table %s", colName, table)
+			}
+			text, err := con.encoding.load(string(kv.Key), string(kv.Value))
+			if err == errChecksum {
+				con.valueCorrupted(dbName, string(kv.Key))
+			}
+			var value interface{}
+			if err == nil {
+				value, err = decodeValue(text, &colSchema.Type)
+			}
+			if err != nil {
+				return nil, 0, fmt.Errorf("column %s of %s/%s: %v", colName, table, rowUuid, err)
+			}
+			row[colName] = value
+		}
+		if !resp.More || len(resp.Kvs) == 0 {
+			break
+		}
+		key = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+	}
+	rows := []map[string]interface{}{}
+	for _, rowUuid := range uuids {
+		row := rowsMap[rowUuid]
+		if withVersion {
+			row[COL_VERSION] = revisionToUuid(versions[rowUuid])
+		}
+		rows = append(rows, row)
+	}
+	return rows, revision, nil
+}
+
+// selectColumns returns the set of the selected columns of the table, or nil if columns is nil
+func selectColumns(table string, tableSchema *ovsdbjson.TableSchema, columns []interface{}) (map[string]bool, error) {
+	if columns == nil {
+		return nil, nil
+	}
+	columnsMap := map[string]bool{}
+	for _, col := range columns {
+		colName, ok := col.(string)
 		if !ok {
-			valsmap = map[string]string{}
+			return nil, fmt.Errorf("wrong column name %v", col)
 		}
-		valsmap[keys[len-1]] = string(v.Value)
-		fmt.Printf("GetMarshaled %v=%v\n", keys[len-1], string(v.Value))
+		if _, ok := tableSchema.Columns[colName]; !ok && colName != COL_UUID && colName != COL_VERSION {
+			return nil, fmt.Errorf("unknown column %s in table %s", colName, table)
+		}
+		columnsMap[colName] = true
+	}
+	return columnsMap, nil
+}
 
-		// TODO
-		retMaps[keys[len-3]] = valsmap
+// revisionToUuid represents an etcd revision as a UUID, so it can be used where OVSDB expects UUIDs, e.g. row versions
+func revisionToUuid(revision int64) ovsdbjson.Uuid {
+	return ovsdbjson.Uuid(fmt.Sprintf("00000000-0000-0000-0000-%012x", revision))
+}
+
+// uuidToRevision returns the etcd revision represented by the UUID, or false if it wasn't created by revisionToUuid
+func uuidToRevision(u string) (int64, bool) {
+	const prefix = "00000000-0000-0000-0000-"
+	if len(u) != len(prefix)+12 || !strings.HasPrefix(u, prefix) {
+		return 0, false
 	}
-	values := []map[string]string{}
-	for _, value := range retMaps {
-		values = append(values, value)
+	revision, err := strconv.ParseInt(strings.TrimPrefix(u, prefix), 16, 64)
+	if err != nil || revision <= 0 {
+		return 0, false
 	}
-	return &values, nil
+	return revision, true
 }
 
 /*func Marshal(kv []*mvccpb.KeyValue) (*[]map[string]string, error) {
@@ -0,0 +1,390 @@
+package ovsdb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	ovsjson "github.com/ibm/ovsdb-etcd/pkg/json"
+)
+
+// The magics of the record headers of OVSDB files
+const (
+	STANDALONE_MAGIC = "OVSDB JSON"
+	CLUSTERED_MAGIC  = "OVSDB CLUSTER"
+)
+
+// the comment of the transaction of exported files, with the etcd revision of their rows
+const EXPORT_COMMENT = "ovsdb-etcd export, etcd revision %d"
+
+// dbFile is the database of an OVSDB file, its schema and its rows after the replay of the file transactions
+type dbFile struct {
+	schema   json.RawMessage
+	dbSchema *ovsjson.DatabaseSchema
+	rows     tablesRows
+	// the etcd revision of exported files, 0 for other files
+	revision int64
+	// the tables of the transactions that are not in the schema, their rows are skipped
+	unknownTables map[string]bool
+}
+
+// readRecords reads the records of an OVSDB file. Every record is a header line "<magic> <length> <sha1>", followed
+// by <length> bytes of JSON text, whose SHA-1 digest is <sha1>. It returns the magic of the records.
+func readRecords(r io.Reader) (string, []json.RawMessage, error) {
+	reader := bufio.NewReader(r)
+	var magic string
+	records := []json.RawMessage{}
+	for {
+		header, err := reader.ReadString('\n')
+		if err == io.EOF && len(header) == 0 {
+			return magic, records, nil
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("record %d header: %v", len(records), err)
+		}
+		fields := strings.Fields(header)
+		if len(fields) < 3 {
+			return "", nil, fmt.Errorf("record %d, wrong header %q", len(records), header)
+		}
+		recordMagic := strings.Join(fields[:len(fields)-2], " ")
+		if (recordMagic != STANDALONE_MAGIC && recordMagic != CLUSTERED_MAGIC) || (magic != "" && recordMagic != magic) {
+			return "", nil, fmt.Errorf("record %d, wrong magic %q", len(records), recordMagic)
+		}
+		magic = recordMagic
+		length, err := strconv.Atoi(fields[len(fields)-2])
+		if err != nil || length < 0 {
+			return "", nil, fmt.Errorf("record %d, wrong length %q", len(records), fields[len(fields)-2])
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return "", nil, fmt.Errorf("record %d: %v", len(records), err)
+		}
+		digest := sha1.Sum(data)
+		if hex.EncodeToString(digest[:]) != fields[len(fields)-1] {
+			return "", nil, fmt.Errorf("record %d, wrong sha1 %s", len(records), fields[len(fields)-1])
+		}
+		records = append(records, json.RawMessage(data))
+	}
+}
+
+// writeRecord writes a record of an OVSDB file
+func writeRecord(w io.Writer, magic string, record interface{}) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if _, err := fmt.Fprintf(w, "%s %d %x\n", magic, len(data), sha1.Sum(data)); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// writeDBFile writes a standalone OVSDB file, whose only transaction inserts all the rows
+func writeDBFile(w io.Writer, schema json.RawMessage, rows tablesRows, comment string) error {
+	if err := writeRecord(w, STANDALONE_MAGIC, schema); err != nil {
+		return err
+	}
+	txn := map[string]interface{}{"_date": time.Now().UnixNano() / int64(time.Millisecond), "_comment": comment}
+	for table, tableRows := range rows {
+		if len(tableRows) > 0 {
+			txn[table] = tableRows
+		}
+	}
+	return writeRecord(w, STANDALONE_MAGIC, txn)
+}
+
+// readDBFile reads a standalone or a clustered OVSDB file. The first record of standalone files is the schema, and the
+// following records are the transactions. Clustered files begin with a header record, whose "prev_data" is the
+// snapshot of the database, and are followed by the raft records, the "data" of raft entries is the schema, if it was
+// changed, and the transaction. All the raft entries are replayed, including the ones that were not committed.
+func readDBFile(r io.Reader) (*dbFile, error) {
+	magic, records, err := readRecords(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("empty file")
+	}
+	f := &dbFile{}
+	if magic == STANDALONE_MAGIC {
+		if err := f.setSchema(records[0]); err != nil {
+			return nil, err
+		}
+		for i, record := range records[1:] {
+			if err := f.replay(record); err != nil {
+				return nil, fmt.Errorf("transaction %d: %v", i+1, err)
+			}
+		}
+		return f, nil
+	}
+	var header struct {
+		PrevData json.RawMessage `json:"prev_data"`
+	}
+	if err := json.Unmarshal(records[0], &header); err != nil {
+		return nil, fmt.Errorf("header: %v", err)
+	}
+	if len(header.PrevData) > 0 {
+		if err := f.replayEntry(header.PrevData); err != nil {
+			return nil, fmt.Errorf("snapshot: %v", err)
+		}
+	}
+	for i, record := range records[1:] {
+		var entry struct {
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(record, &entry); err != nil {
+			return nil, fmt.Errorf("record %d: %v", i+1, err)
+		}
+		// votes and commit indexes don't have data
+		if len(entry.Data) == 0 || string(entry.Data) == "null" {
+			continue
+		}
+		if err := f.replayEntry(entry.Data); err != nil {
+			return nil, fmt.Errorf("record %d: %v", i+1, err)
+		}
+	}
+	if f.dbSchema == nil {
+		return nil, fmt.Errorf("the file doesn't have a schema")
+	}
+	return f, nil
+}
+
+func (f *dbFile) setSchema(schema json.RawMessage) error {
+	dbSchema, err := ovsjson.NewDatabaseSchema(schema)
+	if err != nil {
+		return fmt.Errorf("schema: %v", err)
+	}
+	f.schema = schema
+	f.dbSchema = dbSchema
+	f.rows = tablesRows{}
+	for table := range dbSchema.Tables {
+		f.rows[table] = map[string]row{}
+	}
+	return nil
+}
+
+// replayEntry replays the [<schema>, <transaction>] data of a raft entry, a new schema replaces the database
+func (f *dbFile) replayEntry(data json.RawMessage) error {
+	var entry []json.RawMessage
+	if err := json.Unmarshal(data, &entry); err != nil || len(entry) != 2 {
+		return fmt.Errorf("wrong entry data %s", string(data))
+	}
+	if string(entry[0]) != "null" {
+		if err := f.setSchema(entry[0]); err != nil {
+			return err
+		}
+	}
+	if string(entry[1]) == "null" {
+		return nil
+	}
+	if f.dbSchema == nil {
+		return fmt.Errorf("transaction before the schema")
+	}
+	return f.replay(entry[1])
+}
+
+// replay applies a transaction record. The rows of the transaction are null for deleted rows, and the changed columns
+// of inserted or modified rows, which are column diffs, like in update2 notifications, if "_is_diff" is true.
+func (f *dbFile) replay(record json.RawMessage) error {
+	var txn map[string]json.RawMessage
+	// the numbers are decoded as json.Number, so large integers keep their precision
+	decoder := json.NewDecoder(bytes.NewReader(record))
+	decoder.UseNumber()
+	if err := decoder.Decode(&txn); err != nil {
+		return err
+	}
+	var comment string
+	if c, ok := txn["_comment"]; ok && json.Unmarshal(c, &comment) == nil {
+		var revision int64
+		if n, _ := fmt.Sscanf(comment, EXPORT_COMMENT, &revision); n == 1 {
+			f.revision = revision
+		}
+	}
+	isDiff := false
+	if d, ok := txn["_is_diff"]; ok {
+		if err := json.Unmarshal(d, &isDiff); err != nil {
+			return fmt.Errorf("wrong _is_diff %s", string(d))
+		}
+	}
+	for table, data := range txn {
+		if strings.HasPrefix(table, "_") {
+			continue
+		}
+		tableSchema, ok := f.dbSchema.Tables[table]
+		if !ok {
+			if f.unknownTables == nil {
+				f.unknownTables = map[string]bool{}
+			}
+			f.unknownTables[table] = true
+			continue
+		}
+		var tableRows map[string]row
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&tableRows); err != nil {
+			return fmt.Errorf("table %s: %v", table, err)
+		}
+		for rowUuid, r := range tableRows {
+			if r == nil {
+				delete(f.rows[table], rowUuid)
+				continue
+			}
+			current := f.rows[table][rowUuid].copy()
+			if current == nil {
+				current = row{}
+			}
+			for colName, value := range r {
+				colSchema, ok := tableSchema.Columns[colName]
+				if !ok {
+					continue
+				}
+				if isDiff {
+					var err error
+					if value, err = applyDiff(&colSchema.Type, current[colName], value); err != nil {
+						return fmt.Errorf("column %s of %s/%s: %v", colName, table, rowUuid, err)
+					}
+				}
+				current[colName] = value
+			}
+			f.rows[table][rowUuid] = current
+		}
+	}
+	return nil
+}
+
+// ImportFile imports the database of a standalone or a clustered OVSDB file into etcd. The database is added if it
+// doesn't exist, otherwise the version of its stored schema has to be the version of the file schema. The rows of
+// the file replace the etcd rows of the database, by several etcd transactions if they have more than
+// SYNC_MAX_TXN_OPS columns. It returns the name of the database.
+func (con *DBServer) ImportFile(ctx context.Context, fileName string) (string, error) {
+	f, err := openDBFile(fileName)
+	if err != nil {
+		return "", err
+	}
+	if err := con.storeDBFile(ctx, f, false); err != nil {
+		return "", err
+	}
+	return f.dbSchema.Name, nil
+}
+
+// RestoreFile restores a database from a backup that was written by ExportFile, like ImportFile, but the stored
+// schema has to be the backup schema, with the same version and cksum. It returns the name of the database and the
+// etcd revision of the backup.
+func (con *DBServer) RestoreFile(ctx context.Context, fileName string) (string, int64, error) {
+	f, err := openDBFile(fileName)
+	if err != nil {
+		return "", 0, err
+	}
+	if f.revision == 0 {
+		return "", 0, fmt.Errorf("%s is not a backup of ovsdb-etcd", fileName)
+	}
+	if err := con.storeDBFile(ctx, f, true); err != nil {
+		return "", 0, err
+	}
+	return f.dbSchema.Name, f.revision, nil
+}
+
+func openDBFile(fileName string) (*dbFile, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	f, err := readDBFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+	return f, nil
+}
+
+// storeDBFile replaces the etcd rows of the database by the rows of the file, after it verifies that the stored schema
+// has the version of the file schema, and its cksum too if sameCksum is true
+func (con *DBServer) storeDBFile(ctx context.Context, f *dbFile, sameCksum bool) error {
+	dbName := f.dbSchema.Name
+	resp, err := con.cli.Get(ctx, con.schemaKey(dbName))
+	if err != nil {
+		return err
+	}
+	dbSchema := f.dbSchema
+	if len(resp.Kvs) == 0 {
+		if _, err := con.AddDatabase(ctx, f.schema); err != nil {
+			return err
+		}
+	} else {
+		if dbSchema, err = ovsjson.NewDatabaseSchema(resp.Kvs[0].Value); err != nil {
+			return fmt.Errorf("stored schema of %s: %v", dbName, err)
+		}
+		if dbSchema.Version != f.dbSchema.Version || (sameCksum && dbSchema.Cksum != f.dbSchema.Cksum) {
+			return fmt.Errorf("schema of %s, file version %s cksum %q, stored version %s cksum %q", dbName,
+				f.dbSchema.Version, f.dbSchema.Cksum, dbSchema.Version, dbSchema.Cksum)
+		}
+	}
+	if len(f.unknownTables) > 0 {
+		con.log.WithName("databases").V(5).Info("Skipped the rows of unknown tables of the OVSDB file", "db", dbName,
+			"table", strings.Join(sortedKeys(f.unknownTables), ","))
+	}
+	changes := tablesChanges{}
+	for table, tableRows := range f.rows {
+		tableChanges := map[string]*rowChange{}
+		for rowUuid, r := range tableRows {
+			tableChanges[rowUuid] = &rowChange{new: r}
+		}
+		changes[table] = tableChanges
+	}
+	return con.mirror(ctx, dbName, dbSchema, changes, true)
+}
+
+// ExportFile writes the database to a standalone OVSDB file, which ovsdb-tool and ovsdb-server can read, and which
+// is a backup that RestoreFile restores. The schema and the rows are read by a single etcd revision, without blocking
+// the writers, the schema record has the version and cksum of the schema, and the etcd revision is stored in the
+// comment of the transaction. It returns the etcd revision. The file is replaced only if it's completely written.
+func (con *DBServer) ExportFile(ctx context.Context, dbName, fileName string) (int64, error) {
+	resp, err := con.cli.Get(ctx, con.schemaKey(dbName))
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		return 0, fmt.Errorf("unknown database %s", dbName)
+	}
+	schema := json.RawMessage(resp.Kvs[0].Value)
+	dbSchema, err := ovsjson.NewDatabaseSchema(schema)
+	if err != nil {
+		return 0, fmt.Errorf("stored schema of %s: %v", dbName, err)
+	}
+	c, err := con.loadCache(ctx, dbName, dbSchema, resp.Header.Revision)
+	if err != nil {
+		return 0, err
+	}
+	tmpName := fileName + ".tmp"
+	file, err := os.Create(tmpName)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(file)
+	err = writeDBFile(w, schema, c.rows, fmt.Sprintf(EXPORT_COMMENT, c.revision))
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, fileName)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return 0, err
+	}
+	return c.revision, nil
+}
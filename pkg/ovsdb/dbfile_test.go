@@ -0,0 +1,74 @@
+package ovsdb
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	ovsjson "github.com/ibm/ovsdb-etcd/pkg/json"
+)
+
+var fileSchema = `{"name":"Test","version":"1.0.0","tables":{"Port":{"columns":{` +
+	`"name":{"type":"string"},"tag":{"type":{"key":"integer","min":0,"max":1}},` +
+	`"options":{"type":{"key":"string","value":"string","min":0,"max":"unlimited"}}}}}}`
+
+func testRecords(magic string, records ...string) string {
+	var b strings.Builder
+	for _, record := range records {
+		data := record + "\n"
+		fmt.Fprintf(&b, "%s %d %x\n%s", magic, len(data), sha1.Sum([]byte(data)), data)
+	}
+	return b.String()
+}
+
+func testFileRows(t *testing.T, f *dbFile, expected string) {
+	b, err := json.Marshal(f.rows)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, string(b))
+}
+
+func TestReadStandaloneFile(t *testing.T) {
+	data := testRecords(STANDALONE_MAGIC, fileSchema,
+		`{"Port":{"p1":{"name":"a","tag":["set",[9007199254740993]]},"p2":{"name":"b"}},"_date":1}`,
+		`{"Port":{"p1":{"options":["map",[["k","v"]]]},"p2":null}}`)
+	f, err := readDBFile(strings.NewReader(data))
+	assert.Nil(t, err)
+	assert.Equal(t, "Test", f.dbSchema.Name)
+	testFileRows(t, f, `{"Port":{"p1":{"name":"a","options":["map",[["k","v"]]],"tag":["set",[9007199254740993]]}}}`)
+
+	data = testRecords(STANDALONE_MAGIC, fileSchema,
+		`{"Port":{"p1":{"name":"a","options":["map",[["k","v"]]]}}}`,
+		`{"Port":{"p1":{"options":["map",[["k","v"],["l","w"]]]}},"_is_diff":true}`)
+	f, err = readDBFile(strings.NewReader(data))
+	assert.Nil(t, err)
+	testFileRows(t, f, `{"Port":{"p1":{"name":"a","options":["map",[["l","w"]]]}}}`)
+
+	_, err = readDBFile(strings.NewReader(strings.Replace(data, `"name":"a"`, `"name":"b"`, 1)))
+	assert.NotNil(t, err)
+}
+
+func TestReadClusteredFile(t *testing.T) {
+	data := testRecords(CLUSTERED_MAGIC,
+		`{"name":"Test","cluster_id":"c","server_id":"s","prev_term":1,"prev_index":1,`+
+			`"prev_data":[`+fileSchema+`,{"Port":{"p1":{"name":"a"}}}]}`,
+		`{"term":2,"vote":"s"}`,
+		`{"term":2,"index":2,"data":[null,{"Port":{"p2":{"name":"b"}}}],"eid":"e"}`,
+		`{"commit_index":2}`)
+	f, err := readDBFile(strings.NewReader(data))
+	assert.Nil(t, err)
+	testFileRows(t, f, `{"Port":{"p1":{"name":"a"},"p2":{"name":"b"}}}`)
+}
+
+func TestWriteDBFile(t *testing.T) {
+	rows := tablesRows{"Port": {"p1": row{"name": "a", "options": ovsjson.Map{"k": "v"}}}}
+	var b strings.Builder
+	assert.Nil(t, writeDBFile(&b, json.RawMessage(fileSchema), rows, fmt.Sprintf(EXPORT_COMMENT, 42)))
+	f, err := readDBFile(strings.NewReader(b.String()))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(42), f.revision)
+	testFileRows(t, f, `{"Port":{"p1":{"name":"a","options":["map",[["k","v"]]]}}}`)
+}
@@ -0,0 +1,129 @@
+package ovsdb
+
+import (
+	"context"
+
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+const ELECTIONS_PREFIX = "elections/"
+
+type elections struct {
+	ctx     context.Context
+	session *concurrency.Session
+	// cancels the campaigns, by database name
+	campaigns map[string]context.CancelFunc
+}
+
+// StartElections campaigns for the leadership of every database, the servers that share the etcd cluster elect one
+// leader per database. The leadership of the server is reported by the "leader" column of the _Server Database rows
+// that the server serves. The campaigns end when the context is canceled.
+func (con *DBServer) StartElections(ctx context.Context) error {
+	session, err := concurrency.NewSession(con.cli)
+	if err != nil {
+		return err
+	}
+	con.leadersMu.Lock()
+	con.elections = &elections{ctx: ctx, session: session, campaigns: map[string]context.CancelFunc{}}
+	con.leadersMu.Unlock()
+	for dbName := range con.databaseSchemas() {
+		con.startCampaign(dbName)
+	}
+	go func() {
+		<-ctx.Done()
+		if err := session.Close(); err != nil {
+			con.log.WithName("elections").Error(err, "Closing the election session failed")
+		}
+	}()
+	return nil
+}
+
+// startCampaign campaigns for the leadership of a database, if the elections were started
+func (con *DBServer) startCampaign(dbName string) {
+	if dbName == "_Server" {
+		return
+	}
+	con.leadersMu.Lock()
+	defer con.leadersMu.Unlock()
+	e := con.elections
+	if e == nil {
+		return
+	}
+	if _, ok := e.campaigns[dbName]; ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(e.ctx)
+	e.campaigns[dbName] = cancel
+	go con.campaign(ctx, e.session, dbName)
+}
+
+// stopCampaign ends the campaign for the leadership of a database that was removed
+func (con *DBServer) stopCampaign(dbName string) {
+	con.leadersMu.Lock()
+	defer con.leadersMu.Unlock()
+	if con.elections == nil {
+		return
+	}
+	if cancel, ok := con.elections.campaigns[dbName]; ok {
+		cancel()
+		delete(con.elections.campaigns, dbName)
+	}
+}
+
+func (con *DBServer) campaign(ctx context.Context, session *concurrency.Session, dbName string) {
+	election := concurrency.NewElection(session, con.namespace(dbName)+ELECTIONS_PREFIX+dbName)
+	if err := election.Campaign(ctx, con.uuid); err != nil {
+		if ctx.Err() == nil {
+			con.log.WithName("elections").Error(err, "Campaign failed", "db", dbName)
+		}
+		return
+	}
+	con.log.WithName("elections").Info("The server is the leader", "db", dbName, "server", con.uuid)
+	con.setLeader(dbName, true)
+	select {
+	case <-session.Done():
+		con.log.WithName("elections").Info("The server lost the leadership, the election session expired", "db", dbName,
+			"server", con.uuid)
+	case <-ctx.Done():
+		// let another server lead the database
+		if err := election.Resign(session.Client().Ctx()); err != nil {
+			con.log.WithName("elections").V(5).Info("Resign failed", "db", dbName, "error", err)
+		}
+	}
+	con.setLeader(dbName, false)
+}
+
+// IsLeader returns true if the server is the leader of the database
+func (con *DBServer) IsLeader(dbName string) bool {
+	con.leadersMu.Lock()
+	defer con.leadersMu.Unlock()
+	return con.leaders[dbName]
+}
+
+// SetLeaderOnly sets whether write transactions are refused by the servers that are not the leaders of their
+// databases. Monitors and reads are served by all the servers.
+func (con *DBServer) SetLeaderOnly(leaderOnly bool) {
+	con.leaderOnly = leaderOnly
+}
+
+func (con *DBServer) setLeader(dbName string, leader bool) {
+	con.leadersMu.Lock()
+	con.leaders[dbName] = leader
+	con.leadersMu.Unlock()
+	con.cachesMu.Lock()
+	c, ok := con.caches["_Server"]
+	con.cachesMu.Unlock()
+	if ok {
+		c.setColumn("Database", dbName, "leader", leader)
+	}
+}
+
+// overrideServerRow sets the columns of a _Server row that describe this server, rather than the database
+func (con *DBServer) overrideServerRow(table, rowUuid string, r row) {
+	if table == "Database" {
+		con.leadersMu.Lock()
+		r["leader"] = con.leaders[rowUuid]
+		r["connected"] = con.ready
+		con.leadersMu.Unlock()
+	}
+}
@@ -0,0 +1,163 @@
+package ovsdb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// The markers that start the stored column values that are not textual. The textual values are valid UTF-8, so they
+// can't start with any of them.
+const (
+	// gzip compressed values start with the gzip magic
+	GZIP_MAGIC = "\x1f\x8b"
+	// the marker is followed by the CRC-32C of the rest of the value as 8 hex digits
+	CHECKSUM_MARKER = "\xff"
+	// the marker is followed by the AES-GCM nonce and the sealed value, the etcd key of the value is its associated
+	// data, so a value that is copied to another key can't be decrypted
+	ENCRYPTION_MARKER = "\xfe"
+)
+
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errChecksum is returned for the stored values whose checksum doesn't match
+var errChecksum = fmt.Errorf("checksum mismatch")
+
+// A valueEncoding converts the textual column values to the values that are stored in etcd, and back. The values are
+// compressed, encrypted and checksummed, in this order, according to the settings of the server. Every stored value
+// is loaded regardless of the settings, except for the encrypted values when the server doesn't have the key.
+type valueEncoding struct {
+	// the values that are longer are compressed, 0 if the values are not compressed
+	compressionThreshold int
+	checksums            bool
+	// nil if the values are not encrypted
+	aead cipher.AEAD
+}
+
+// store returns the value that is stored in the etcd key for a textual value
+func (e *valueEncoding) store(key, value string) (string, error) {
+	value, err := compressValue(value, e.compressionThreshold)
+	if err != nil {
+		return "", err
+	}
+	if e.aead != nil {
+		if value, err = encryptValue(e.aead, key, value); err != nil {
+			return "", err
+		}
+	}
+	if e.checksums {
+		value = addChecksum(value)
+	}
+	return value, nil
+}
+
+// load returns the textual value of the value that is stored in the etcd key, it returns errChecksum if the checksum of
+// the value doesn't match
+func (e *valueEncoding) load(key, value string) (string, error) {
+	value, err := verifyChecksum(value)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(value, ENCRYPTION_MARKER) {
+		if e.aead == nil {
+			return "", fmt.Errorf("the value is encrypted, and the encryption key is not set")
+		}
+		if value, err = decryptValue(e.aead, key, value); err != nil {
+			return "", err
+		}
+	}
+	return decompressValue(value)
+}
+
+// compressValue compresses the value by gzip if it's longer than threshold bytes, and if the compressed value is
+// shorter. 0 disables the compression.
+func compressValue(value string, threshold int) (string, error) {
+	if threshold == 0 || len(value) <= threshold {
+		return value, nil
+	}
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write([]byte(value)); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	if b.Len() >= len(value) {
+		return value, nil
+	}
+	return b.String(), nil
+}
+
+// decompressValue returns the value, the values that were compressed by compressValue are decompressed
+func decompressValue(value string) (string, error) {
+	if !strings.HasPrefix(value, GZIP_MAGIC) {
+		return value, nil
+	}
+	r, err := gzip.NewReader(strings.NewReader(value))
+	if err != nil {
+		return "", fmt.Errorf("wrong compressed value: %v", err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("wrong compressed value: %v", err)
+	}
+	return string(data), nil
+}
+
+// newAEAD returns the AES-GCM cipher of the key, which is 16, 24 or 32 bytes long
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptValue seals the value of the etcd key by a random nonce, and prepends the encryption marker and the nonce
+func encryptValue(aead cipher.AEAD, key, value string) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return ENCRYPTION_MARKER + string(aead.Seal(nonce, nonce, []byte(value), []byte(key))), nil
+}
+
+// decryptValue opens a value that was encrypted by encryptValue for the same etcd key
+func decryptValue(aead cipher.AEAD, key, value string) (string, error) {
+	sealed := []byte(strings.TrimPrefix(value, ENCRYPTION_MARKER))
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("wrong encrypted value")
+	}
+	data, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(key))
+	if err != nil {
+		return "", fmt.Errorf("wrong encrypted value: %v", err)
+	}
+	return string(data), nil
+}
+
+// addChecksum prepends the checksum marker and the checksum of the value
+func addChecksum(value string) string {
+	return fmt.Sprintf("%s%08x%s", CHECKSUM_MARKER, crc32.Checksum([]byte(value), checksumTable), value)
+}
+
+// verifyChecksum returns the value without its checksum, or errChecksum if the checksum doesn't match. The values
+// without checksums are returned as is.
+func verifyChecksum(value string) (string, error) {
+	if !strings.HasPrefix(value, CHECKSUM_MARKER) {
+		return value, nil
+	}
+	n := len(CHECKSUM_MARKER) + 8
+	if len(value) < n || fmt.Sprintf("%08x", crc32.Checksum([]byte(value[n:]), checksumTable)) !=
+		value[len(CHECKSUM_MARKER):n] {
+		return "", errChecksum
+	}
+	return value[n:], nil
+}
@@ -0,0 +1,51 @@
+package ovsdb
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValueEncoding(t *testing.T) {
+	key := "ovsdb/nb/OVN_Northbound/ACL/413afe3e-79ff-4583-88a6-f02b70b8e927/match"
+	value := "[" + strings.Repeat("10.244.0.3, ", 100) + "10.244.0.3]"
+	aead, err := newAEAD([]byte("0123456789abcdef0123456789abcdef"))
+	assert.Nil(t, err)
+	encodings := []*valueEncoding{{}, {compressionThreshold: 100}, {checksums: true}, {aead: aead},
+		{compressionThreshold: 100, checksums: true, aead: aead}}
+	for _, e := range encodings {
+		stored, err := e.store(key, value)
+		assert.Nil(t, err)
+		loaded, err := e.load(key, stored)
+		assert.Nil(t, err)
+		assert.Equal(t, value, loaded)
+	}
+
+	compressed, err := (&valueEncoding{compressionThreshold: 100}).store(key, value)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(compressed, GZIP_MAGIC))
+	assert.True(t, len(compressed) < len(value))
+	small, err := (&valueEncoding{compressionThreshold: 100}).store(key, "[10.244.0.3]")
+	assert.Nil(t, err)
+	assert.Equal(t, "[10.244.0.3]", small)
+
+	stored, err := (&valueEncoding{checksums: true, aead: aead}).store(key, "allow-related")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(stored, CHECKSUM_MARKER))
+	assert.False(t, strings.Contains(stored, "allow-related"))
+	// the values are loaded regardless of the settings, except for encrypted values without the key
+	_, err = (&valueEncoding{}).load(key, stored)
+	assert.NotNil(t, err)
+	loaded, err := (&valueEncoding{aead: aead}).load(key, stored)
+	assert.Nil(t, err)
+	assert.Equal(t, "allow-related", loaded)
+	_, err = (&valueEncoding{aead: aead}).load(key, stored[:len(stored)-1]+"x")
+	assert.Equal(t, errChecksum, err)
+	_, err = (&valueEncoding{}).load(key, CHECKSUM_MARKER+"0")
+	assert.Equal(t, errChecksum, err)
+
+	// an encrypted value that is moved to another key can't be decrypted
+	_, err = (&valueEncoding{aead: aead}).load(strings.TrimSuffix(key, "match")+"action", stored)
+	assert.NotNil(t, err)
+}
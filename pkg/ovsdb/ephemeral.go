@@ -0,0 +1,111 @@
+package ovsdb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/google/uuid"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// SetEphemeralTables designates the tables whose rows can be inserted by insert_ephemeral requests, by database name
+func (con *DBServer) SetEphemeralTables(tables map[string][]string) {
+	con.ephemeralTables = map[string]bool{}
+	for dbName, dbTables := range tables {
+		for _, table := range dbTables {
+			con.ephemeralTables[dbName+"/"+table] = true
+		}
+	}
+}
+
+// InsertEphemeral inserts a row of a designated table, whose columns are stored with the etcd lease of the client
+// session, the one of its locks. When the client connection is closed, or the server stops keeping the lease alive,
+// the row is deleted, and the monitors of the table are notified about the deletion like about any other deletion.
+// The columns of the row are stored even if they have default values, so the row exists even if all of them have. It
+// returns the uuid of the new row.
+func (con *DBServer) InsertEphemeral(ctx context.Context, dbName, table string, r map[string]interface{}) (string,
+	error) {
+	if !con.ephemeralTables[dbName+"/"+table] {
+		return "", fmt.Errorf("table %s of %s is not ephemeral", table, dbName)
+	}
+	if con.isReadOnly(ctx) {
+		return "", fmt.Errorf("the connection is read-only")
+	}
+	if err := con.authorize(ctx, dbName, ACL_WRITE); err != nil {
+		return "", err
+	}
+	if con.leaderOnly && !con.IsLeader(dbName) {
+		return "", fmt.Errorf("the server is not the leader of %s", dbName)
+	}
+	_, dbSchema, ok := con.lookupSchema(dbName)
+	if !ok {
+		return "", fmt.Errorf("unknown database %s", dbName)
+	}
+	tableSchema, ok := dbSchema.Tables[table]
+	if !ok {
+		return "", fmt.Errorf("unknown table %s", table)
+	}
+	for colName := range r {
+		if _, ok := tableSchema.Columns[colName]; !ok {
+			return "", fmt.Errorf("unknown column %s", colName)
+		}
+	}
+	ls, err := con.getLockSession(ctx)
+	if err != nil {
+		return "", err
+	}
+	rowUuid := uuid.NewString()
+	rowPrefix := con.dbPrefix(dbName) + table + "/" + rowUuid + "/"
+	ops := []clientv3.Op{}
+	for colName, colSchema := range tableSchema.Columns {
+		value, ok := r[colName]
+		if !ok {
+			value = defaultValue(&colSchema.Type)
+		}
+		encoded, err := encodeValue(value, &colSchema.Type)
+		if err == nil {
+			encoded, err = con.storedValue(rowPrefix+colName, encoded)
+		}
+		if err != nil {
+			return "", fmt.Errorf("column %s: %v", colName, err)
+		}
+		ops = append(ops, clientv3.OpPut(rowPrefix+colName, encoded, clientv3.WithLease(ls.session.Lease())))
+	}
+	con.metrics.etcdTxnOps.Observe(float64(len(ops)))
+	err = con.retry(ctx, "txn", func(ctx context.Context) error {
+		_, err := con.cli.Txn(ctx).Then(ops...).Commit()
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	con.mu.Lock()
+	cs := con.session(jrpc2.ServerFromContext(ctx))
+	if cs.ephemeral == nil {
+		cs.ephemeral = map[string]bool{}
+	}
+	cs.ephemeral[rowPrefix] = true
+	con.mu.Unlock()
+	con.metrics.rows.Inc(dbName, "insert_ephemeral")
+	return rowUuid, nil
+}
+
+// DeleteEphemeral deletes an ephemeral row that was inserted by the client
+func (con *DBServer) DeleteEphemeral(ctx context.Context, dbName, table, rowUuid string) error {
+	rowPrefix := con.dbPrefix(dbName) + table + "/" + rowUuid + "/"
+	con.mu.Lock()
+	cs := con.session(jrpc2.ServerFromContext(ctx))
+	owned := cs.ephemeral[rowPrefix]
+	con.mu.Unlock()
+	if !owned {
+		return fmt.Errorf("row %s of %s is not an ephemeral row of the client", rowUuid, table)
+	}
+	if _, err := con.cli.Delete(ctx, rowPrefix, clientv3.WithPrefix()); err != nil {
+		return err
+	}
+	con.mu.Lock()
+	delete(cs.ephemeral, rowPrefix)
+	con.mu.Unlock()
+	return nil
+}
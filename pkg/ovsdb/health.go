@@ -0,0 +1,115 @@
+package ovsdb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/creachadair/jrpc2/metrics"
+)
+
+// EndpointHealth is the state of an etcd endpoint by the last health check
+type EndpointHealth struct {
+	Endpoint string
+	Healthy  bool
+	// the latency of the last status request
+	Latency time.Duration
+	// the error of the last status request, if it failed
+	Err string
+	// the number of failed status requests
+	Errors int64
+}
+
+// StartHealthCheck checks the status of every configured etcd endpoint every interval, until the context is
+// canceled. The client uses only the healthy endpoints, and all of them if none is healthy. The latency and the errors
+// of every endpoint are recorded by m, the metrics of the server, if it's not nil, as
+// ovsdb.etcd.endpoint.<endpoint>.{latency,maxLatencyMicros,errors,healthy}.
+func (con *DBServer) StartHealthCheck(ctx context.Context, interval time.Duration, m *metrics.M) {
+	con.healthMu.Lock()
+	con.health = map[string]*EndpointHealth{}
+	for _, ep := range con.endpoints {
+		con.health[ep] = &EndpointHealth{Endpoint: ep, Healthy: true}
+	}
+	con.healthMu.Unlock()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			con.checkEndpoints(ctx, m)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (con *DBServer) checkEndpoints(ctx context.Context, m *metrics.M) {
+	healthy := []string{}
+	for _, ep := range con.endpoints {
+		reqCtx, cancel := context.WithTimeout(ctx, con.requestTimeout)
+		start := time.Now()
+		resp, err := con.cli.Status(reqCtx, ep)
+		latency := time.Since(start)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil && len(resp.Errors) > 0 {
+			err = fmt.Errorf("%s", strings.Join(resp.Errors, ", "))
+		}
+		con.healthMu.Lock()
+		h := con.health[ep]
+		h.Latency = latency
+		h.Err = ""
+		if err != nil {
+			h.Err = err.Error()
+			h.Errors++
+		}
+		if h.Healthy != (err == nil) {
+			if err != nil {
+				con.log.WithName("etcd").Info("Endpoint is unhealthy", "endpoint", ep, "error", err)
+			} else {
+				con.log.WithName("etcd").Info("Endpoint is healthy", "endpoint", ep)
+			}
+		}
+		h.Healthy = err == nil
+		con.healthMu.Unlock()
+		if m != nil {
+			name := "ovsdb.etcd.endpoint." + ep
+			m.SetLabel(name+".latency", latency.String())
+			m.SetMaxValue(name+".maxLatencyMicros", latency.Microseconds())
+			m.SetLabel(name+".healthy", err == nil)
+			if err != nil {
+				m.Count(name+".errors", 1)
+			}
+		}
+		if err == nil {
+			healthy = append(healthy, ep)
+		}
+	}
+	if len(healthy) == 0 {
+		healthy = con.endpoints
+	}
+	current := con.cli.Endpoints()
+	if strings.Join(current, ",") != strings.Join(healthy, ",") {
+		con.log.WithName("etcd").Info("Endpoints switched", "from", current, "to", healthy)
+		con.cli.SetEndpoints(healthy...)
+	}
+}
+
+// EndpointsHealth returns the state of the etcd endpoints by the last health check, sorted by endpoint. It's empty if
+// the endpoints are not checked.
+func (con *DBServer) EndpointsHealth() []EndpointHealth {
+	con.healthMu.Lock()
+	defer con.healthMu.Unlock()
+	health := []EndpointHealth{}
+	for _, h := range con.health {
+		health = append(health, *h)
+	}
+	sort.Slice(health, func(i, j int) bool { return health[i].Endpoint < health[j].Endpoint })
+	return health
+}
@@ -0,0 +1,354 @@
+package ovsdb
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/go-logr/logr"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+const LOCKS_PREFIX = "locks/"
+
+// The OVSDB locks of a single client connection. All the locks share one etcd session, so when the connection is
+// closed (or the process dies) the lease is revoked and the etcd keys of the owned locks disappear, which lets the
+// next waiting client to acquire them.
+type lockSession struct {
+	mu      sync.Mutex
+	session *concurrency.Session
+	locks   map[string]*ovsdbLock
+	// closed when the session is closed and its locks are released
+	closed  chan struct{}
+	log     logr.Logger
+	metrics *serverMetrics
+}
+
+type ovsdbLock struct {
+	id     string
+	owned  bool
+	cancel context.CancelFunc
+	// the time that the client started to wait for the lock
+	waitStart time.Time
+	// the time that the client acquired the lock, if it owns it
+	ownedSince time.Time
+	// the etcd revision that the client acquired the lock at, if it owns it
+	rev int64
+}
+
+// setOwned records that the client acquired or lost the lock, with the time that it waited for the lock or owned it
+func (lock *ovsdbLock) setOwned(owned bool, m *serverMetrics) {
+	now := time.Now()
+	if owned {
+		m.lockWait.Observe(now.Sub(lock.waitStart).Seconds())
+		m.addLockWaiters(lock.id, -1)
+		lock.ownedSince = now
+	} else {
+		m.lockHold.Observe(now.Sub(lock.ownedSince).Seconds())
+		m.addLockWaiters(lock.id, 1)
+		lock.waitStart = now
+		lock.ownedSince = time.Time{}
+	}
+	lock.owned = owned
+}
+
+// release records that the client released the lock or stopped waiting for it
+func (lock *ovsdbLock) release(m *serverMetrics) {
+	if lock.owned {
+		m.lockHold.Observe(time.Since(lock.ownedSince).Seconds())
+	} else {
+		m.addLockWaiters(lock.id, -1)
+	}
+}
+
+// the value stored in the lock key, it identifies the owner session
+func (ls *lockSession) owner() string {
+	return strconv.FormatInt(int64(ls.session.Lease()), 16)
+}
+
+// returns the locks session of the client connection, creates it if it is the first lock request of the connection.
+// The etcd session is created without holding the server mutex, so if two requests of the connection create it at
+// the same time, the session of the second one is closed.
+func (con *DBServer) getLockSession(ctx context.Context) (*lockSession, error) {
+	if con.relay != nil {
+		return nil, fmt.Errorf("locks are not supported by relays")
+	}
+	srv := jrpc2.ServerFromContext(ctx)
+	con.mu.Lock()
+	cs := con.session(srv)
+	ls, closed := cs.locks, cs.closed
+	con.mu.Unlock()
+	if ls != nil {
+		return ls, nil
+	}
+	if closed {
+		return nil, fmt.Errorf("connection is closed")
+	}
+	session, err := concurrency.NewSession(con.cli)
+	if err != nil {
+		return nil, err
+	}
+	con.mu.Lock()
+	if cs.locks == nil && !cs.closed {
+		cs.locks = &lockSession{session: session, locks: map[string]*ovsdbLock{}, closed: make(chan struct{}),
+			log: con.log.WithName("locks"), metrics: con.metrics}
+	}
+	ls = cs.locks
+	con.mu.Unlock()
+	if ls == nil || ls.session != session {
+		if err := session.Close(); err != nil {
+			con.log.WithName("locks").Error(err, "Closing the lock session failed")
+		}
+	}
+	if ls == nil {
+		return nil, fmt.Errorf("connection is closed")
+	}
+	return ls, nil
+}
+
+// close releases all the locks of the session, it's called when the client connection is closed
+func (ls *lockSession) close() {
+	ls.mu.Lock()
+	for _, lock := range ls.locks {
+		lock.cancel()
+		lock.release(ls.metrics)
+	}
+	ls.locks = map[string]*ovsdbLock{}
+	ls.mu.Unlock()
+	if err := ls.session.Close(); err != nil {
+		ls.log.Error(err, "Closing the lock session failed")
+	}
+	close(ls.closed)
+}
+
+// Lock requests the lock id for the client. If the lock is not available, the client waits for it, and receives a
+// "locked" notification when it acquires the lock. If steal is true, the lock is taken from the current owner, which
+// receives a "stolen" notification. The waiting clients are not queued, when the lock is released they all try to
+// acquire it, and the first one to reach etcd gets it.
+func (con *DBServer) Lock(ctx context.Context, id string, steal bool) (bool, error) {
+	ls, err := con.getLockSession(ctx)
+	if err != nil {
+		return false, err
+	}
+	// the lock is reserved before the etcd request, which is sent without holding the session mutex
+	lockCtx, cancel := context.WithCancel(context.Background())
+	lock := &ovsdbLock{id: id, cancel: cancel, waitStart: time.Now()}
+	ls.mu.Lock()
+	if _, ok := ls.locks[id]; ok {
+		ls.mu.Unlock()
+		cancel()
+		return false, fmt.Errorf("duplicate lock")
+	}
+	ls.locks[id] = lock
+	con.metrics.addLockWaiters(id, 1)
+	ls.mu.Unlock()
+	key := con.serverNamespace() + LOCKS_PREFIX + id
+	me := ls.owner()
+	var locked bool
+	var rev int64
+	if steal {
+		var resp *clientv3.PutResponse
+		resp, err = con.cli.Put(ctx, key, me, clientv3.WithLease(ls.session.Lease()))
+		if err == nil {
+			locked, rev = true, resp.Header.Revision
+		}
+	} else {
+		locked, rev, err = con.tryLock(ctx, key, me, ls.session.Lease())
+	}
+	ls.mu.Lock()
+	if lockCtx.Err() != nil {
+		// the client released the lock during the request
+		ls.mu.Unlock()
+		if locked {
+			con.releaseKey(ls, key, lock.id, rev)
+		}
+		return false, fmt.Errorf("unlocked")
+	}
+	if err != nil {
+		cancel()
+		lock.release(con.metrics)
+		delete(ls.locks, id)
+		ls.mu.Unlock()
+		return false, err
+	}
+	if locked {
+		lock.setOwned(true, con.metrics)
+		lock.rev = rev
+	}
+	ls.mu.Unlock()
+	go con.watchLock(lockCtx, jrpc2.ServerFromContext(ctx), ls, lock, rev)
+	return locked, nil
+}
+
+// Unlock releases the lock id if the client owns it, or cancels the client's pending lock request.
+func (con *DBServer) Unlock(ctx context.Context, id string) error {
+	ls, err := con.getLockSession(ctx)
+	if err != nil {
+		return err
+	}
+	ls.mu.Lock()
+	lock, ok := ls.locks[id]
+	if !ok {
+		ls.mu.Unlock()
+		return fmt.Errorf("unknown lock")
+	}
+	lock.cancel()
+	lock.release(con.metrics)
+	delete(ls.locks, id)
+	owned, rev := lock.owned, lock.rev
+	ls.mu.Unlock()
+	if !owned {
+		return nil
+	}
+	// the key is deleted only if it was not changed since the client acquired the lock, so a later lock request of
+	// the client, or a steal by another client, is not released
+	key := con.serverNamespace() + LOCKS_PREFIX + id
+	_, err = con.cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", rev)).
+		Then(clientv3.OpDelete(key)).
+		Commit()
+	return err
+}
+
+// IsLockOwner returns true if the client connection owns the lock id.
+func (con *DBServer) IsLockOwner(ctx context.Context, id string) bool {
+	srv := jrpc2.ServerFromContext(ctx)
+	con.mu.Lock()
+	ls := con.session(srv).locks
+	con.mu.Unlock()
+	if ls == nil {
+		return false
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	lock, ok := ls.locks[id]
+	return ok && lock.owned
+}
+
+// tryLock creates the lock key if it doesn't exist, returns true if the key was created, and the etcd revision of the
+// operation.
+func (con *DBServer) tryLock(ctx context.Context, key, me string, lease clientv3.LeaseID) (bool, int64, error) {
+	resp, err := con.cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, me, clientv3.WithLease(lease))).
+		Commit()
+	if err != nil {
+		return false, 0, err
+	}
+	return resp.Succeeded, resp.Header.Revision, nil
+}
+
+// watchLock follows the lock key from the revision rev. A waiting client tries to acquire the lock when the key is
+// deleted, and an owner client that sees the key overwritten by someone else has lost its lock. If the watch fails,
+// e.g. when its revision was compacted, the changes may be lost, so the state of the key is read again, and it's
+// watched from the revision of the read.
+func (con *DBServer) watchLock(ctx context.Context, srv *jrpc2.Server, ls *lockSession, lock *ovsdbLock, rev int64) {
+	key := con.serverNamespace() + LOCKS_PREFIX + lock.id
+	for {
+		watchCtx, cancel := context.WithCancel(ctx)
+		for wresp := range con.cli.Watch(watchCtx, key, clientv3.WithRev(rev+1)) {
+			if err := wresp.Err(); err != nil {
+				if ctx.Err() == nil {
+					ls.log.Error(err, "Lock watch failed, reading the lock again", "lock", lock.id)
+				}
+				break
+			}
+			for _, ev := range wresp.Events {
+				rev = ev.Kv.ModRevision
+				con.lockChanged(ctx, srv, ls, lock, ev.Type == mvccpb.DELETE, string(ev.Kv.Value))
+			}
+		}
+		cancel()
+		for ctx.Err() == nil {
+			resp, err := con.cli.Get(ctx, key)
+			if err == nil {
+				rev = resp.Header.Revision
+				if len(resp.Kvs) == 0 {
+					con.lockChanged(ctx, srv, ls, lock, true, "")
+				} else {
+					con.lockChanged(ctx, srv, ls, lock, false, string(resp.Kvs[0].Value))
+				}
+				break
+			}
+			ls.log.Error(err, "Reading the lock failed", "lock", lock.id)
+			select {
+			case <-ctx.Done():
+			case <-time.After(ETCD_RETRY_MAX_BACKOFF):
+			}
+		}
+		if ctx.Err() != nil {
+			// the lock was released by the client
+			return
+		}
+	}
+}
+
+// lockChanged updates the lock by a change of its key, it notifies the client if the lock was stolen, and tries to
+// acquire the lock if the key was deleted. The etcd requests and the notifications are sent without holding the
+// session mutex, so a slow etcd or client doesn't block the other locks of the session.
+func (con *DBServer) lockChanged(ctx context.Context, srv *jrpc2.Server, ls *lockSession, lock *ovsdbLock,
+	deleted bool, value string) {
+	me := ls.owner()
+	ls.mu.Lock()
+	if ctx.Err() != nil {
+		ls.mu.Unlock()
+		return
+	}
+	stolen := lock.owned && (deleted || value != me)
+	if stolen {
+		lock.setOwned(false, con.metrics)
+	}
+	try := !lock.owned && deleted
+	ls.mu.Unlock()
+	if stolen {
+		notify(ctx, ls.log, srv, "stolen", lock.id)
+	}
+	if !try {
+		return
+	}
+	key := con.serverNamespace() + LOCKS_PREFIX + lock.id
+	locked, lockRev, err := con.tryLock(ctx, key, me, ls.session.Lease())
+	if err != nil {
+		if ctx.Err() == nil {
+			ls.log.Error(err, "Lock failed", "lock", lock.id)
+		}
+		return
+	}
+	if !locked {
+		return
+	}
+	ls.mu.Lock()
+	if ctx.Err() != nil {
+		// the client released the lock while it was acquired
+		ls.mu.Unlock()
+		con.releaseKey(ls, key, lock.id, lockRev)
+		return
+	}
+	lock.setOwned(true, con.metrics)
+	lock.rev = lockRev
+	ls.mu.Unlock()
+	notify(ctx, ls.log, srv, "locked", lock.id)
+}
+
+// releaseKey deletes the lock key that the client acquired at the revision rev after it released the lock, unless the
+// key was changed since then, e.g. because the client already requested the lock again
+func (con *DBServer) releaseKey(ls *lockSession, key, id string, rev int64) {
+	_, err := con.cli.Txn(context.Background()).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", rev)).
+		Then(clientv3.OpDelete(key)).
+		Commit()
+	if err != nil {
+		ls.log.Error(err, "Releasing the lock failed", "lock", id)
+	}
+}
+
+func notify(ctx context.Context, log logr.Logger, srv *jrpc2.Server, method string, id string) {
+	if err := srv.Notify(ctx, method, []string{id}); err != nil {
+		log.Error(err, "Lock notification failed", "method", method, "lock", id)
+	}
+}
@@ -0,0 +1,328 @@
+package ovsdb
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/channel"
+	"github.com/creachadair/jrpc2/handler"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ibm/ovsdb-etcd/pkg/klogr"
+	"github.com/ibm/ovsdb-etcd/pkg/stats"
+)
+
+func TestLockWaitersMetric(t *testing.T) {
+	m := newServerMetrics(stats.NewRegistry())
+	waiters := func() string {
+		var b strings.Builder
+		assert.Nil(t, m.registry.WriteText(&b))
+		for _, line := range strings.Split(b.String(), "\n") {
+			if strings.HasPrefix(line, "ovsdb_lock_waiters{") {
+				return line
+			}
+		}
+		return ""
+	}
+	owner := &ovsdbLock{id: "ovn_northd", waitStart: time.Now()}
+	waiter := &ovsdbLock{id: "ovn_northd", waitStart: time.Now()}
+	m.addLockWaiters(owner.id, 1)
+	owner.setOwned(true, m)
+	assert.Equal(t, "", waiters())
+	m.addLockWaiters(waiter.id, 1)
+	assert.Equal(t, `ovsdb_lock_waiters{lock="ovn_northd"} 1`, waiters())
+
+	// the owner loses the lock, and the waiter acquires it
+	owner.setOwned(false, m)
+	assert.Equal(t, `ovsdb_lock_waiters{lock="ovn_northd"} 2`, waiters())
+	waiter.setOwned(true, m)
+	assert.Equal(t, `ovsdb_lock_waiters{lock="ovn_northd"} 1`, waiters())
+
+	// the unlocks remove the gauge of the lock after its last waiter
+	owner.release(m)
+	assert.Equal(t, "", waiters())
+	waiter.release(m)
+	assert.Equal(t, "", waiters())
+	assert.Empty(t, m.lockWaitersCounts)
+}
+
+// fakeEtcd is an in-memory etcd with the requests that the locks send. It keeps every change, so the watches can start
+// at any revision, and it doesn't bind the keys to their leases.
+type fakeEtcd struct {
+	clientv3.KV
+	clientv3.Lease
+	clientv3.Watcher
+	mu     sync.Mutex
+	cond   *sync.Cond
+	rev    int64
+	kvs    map[string]*mvccpb.KeyValue
+	events []*clientv3.Event
+	leases int64
+}
+
+func newFakeEtcd() *fakeEtcd {
+	e := &fakeEtcd{kvs: map[string]*mvccpb.KeyValue{}}
+	e.cond = sync.NewCond(&e.mu)
+	return e
+}
+
+// client returns an etcd client whose requests are served by the fake
+func (e *fakeEtcd) client() *clientv3.Client {
+	cli := clientv3.NewCtxClient(context.Background())
+	cli.KV, cli.Lease, cli.Watcher = e, e, e
+	return cli
+}
+
+func (e *fakeEtcd) header() *etcdserverpb.ResponseHeader {
+	return &etcdserverpb.ResponseHeader{Revision: e.rev}
+}
+
+func (e *fakeEtcd) put(key, value string) {
+	e.rev++
+	kv := &mvccpb.KeyValue{Key: []byte(key), Value: []byte(value), CreateRevision: e.rev, ModRevision: e.rev}
+	if prev, ok := e.kvs[key]; ok {
+		kv.CreateRevision = prev.CreateRevision
+	}
+	e.kvs[key] = kv
+	e.events = append(e.events, &clientv3.Event{Type: mvccpb.PUT, Kv: kv})
+	e.cond.Broadcast()
+}
+
+func (e *fakeEtcd) delete(key string) {
+	if _, ok := e.kvs[key]; !ok {
+		return
+	}
+	e.rev++
+	delete(e.kvs, key)
+	e.events = append(e.events, &clientv3.Event{Type: mvccpb.DELETE,
+		Kv: &mvccpb.KeyValue{Key: []byte(key), ModRevision: e.rev}})
+	e.cond.Broadcast()
+}
+
+func (e *fakeEtcd) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse,
+	error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.put(key, val)
+	return &clientv3.PutResponse{Header: e.header()}, nil
+}
+
+func (e *fakeEtcd) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	resp := &clientv3.GetResponse{Header: e.header()}
+	if kv, ok := e.kvs[key]; ok {
+		resp.Kvs = []*mvccpb.KeyValue{kv}
+	}
+	return resp, nil
+}
+
+func (e *fakeEtcd) Txn(ctx context.Context) clientv3.Txn {
+	return &fakeTxn{etcd: e}
+}
+
+type fakeTxn struct {
+	etcd  *fakeEtcd
+	cmps  []clientv3.Cmp
+	thens []clientv3.Op
+	elses []clientv3.Op
+}
+
+func (txn *fakeTxn) If(cs ...clientv3.Cmp) clientv3.Txn {
+	txn.cmps = append(txn.cmps, cs...)
+	return txn
+}
+
+func (txn *fakeTxn) Then(ops ...clientv3.Op) clientv3.Txn {
+	txn.thens = append(txn.thens, ops...)
+	return txn
+}
+
+func (txn *fakeTxn) Else(ops ...clientv3.Op) clientv3.Txn {
+	txn.elses = append(txn.elses, ops...)
+	return txn
+}
+
+// Commit supports the equality comparisons of the values and the revisions of the keys
+func (txn *fakeTxn) Commit() (*clientv3.TxnResponse, error) {
+	e := txn.etcd
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	succeeded := true
+	for _, cmp := range txn.cmps {
+		kv, ok := e.kvs[string(cmp.Key)]
+		if !ok {
+			kv = &mvccpb.KeyValue{}
+		}
+		pb := etcdserverpb.Compare(cmp)
+		switch cmp.Target {
+		case etcdserverpb.Compare_CREATE:
+			succeeded = succeeded && kv.CreateRevision == pb.GetCreateRevision()
+		case etcdserverpb.Compare_MOD:
+			succeeded = succeeded && kv.ModRevision == pb.GetModRevision()
+		case etcdserverpb.Compare_VALUE:
+			succeeded = succeeded && ok && string(kv.Value) == string(pb.GetValue())
+		default:
+			panic("unsupported comparison")
+		}
+	}
+	ops := txn.elses
+	if succeeded {
+		ops = txn.thens
+	}
+	for _, op := range ops {
+		if op.IsPut() {
+			e.put(string(op.KeyBytes()), string(op.ValueBytes()))
+		} else if op.IsDelete() {
+			e.delete(string(op.KeyBytes()))
+		}
+	}
+	return &clientv3.TxnResponse{Header: e.header(), Succeeded: succeeded}, nil
+}
+
+// Watch sends the changes of the key from the revision of the options until the context is canceled
+func (e *fakeEtcd) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	rev := clientv3.OpGet(key, opts...).Rev()
+	ch := make(chan clientv3.WatchResponse)
+	go func() {
+		<-ctx.Done()
+		e.mu.Lock()
+		e.cond.Broadcast()
+		e.mu.Unlock()
+	}()
+	go func() {
+		defer close(ch)
+		next := 0
+		for {
+			e.mu.Lock()
+			for next == len(e.events) && ctx.Err() == nil {
+				e.cond.Wait()
+			}
+			events := e.events[next:]
+			next = len(e.events)
+			e.mu.Unlock()
+			if ctx.Err() != nil {
+				return
+			}
+			var resp clientv3.WatchResponse
+			for _, ev := range events {
+				if string(ev.Kv.Key) == key && ev.Kv.ModRevision >= rev {
+					resp.Events = append(resp.Events, ev)
+				}
+			}
+			if len(resp.Events) == 0 {
+				continue
+			}
+			select {
+			case ch <- resp:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func (e *fakeEtcd) Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.leases++
+	return &clientv3.LeaseGrantResponse{ID: clientv3.LeaseID(e.leases), TTL: ttl}, nil
+}
+
+func (e *fakeEtcd) KeepAlive(ctx context.Context, id clientv3.LeaseID) (<-chan *clientv3.LeaseKeepAliveResponse,
+	error) {
+	ch := make(chan *clientv3.LeaseKeepAliveResponse)
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch, nil
+}
+
+func (e *fakeEtcd) Revoke(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseRevokeResponse, error) {
+	return &clientv3.LeaseRevokeResponse{}, nil
+}
+
+func (e *fakeEtcd) Close() error {
+	return nil
+}
+
+// lockClient is a client connection that records the lock notifications that it receives
+type lockClient struct {
+	cli           *jrpc2.Client
+	notifications chan string
+}
+
+func newLockClient(t *testing.T, con *DBServer) *lockClient {
+	cch, sch := channel.Direct()
+	service := NewService(con)
+	srv := jrpc2.NewServer(handler.Map{"lock": handler.New(service.Lock), "steal": handler.New(service.Steal),
+		"unlock": handler.New(service.Unlock)}, &jrpc2.ServerOptions{AllowV1: true, AllowPush: true})
+	con.AddClient(srv, sch, ClientConnection{})
+	lc := &lockClient{notifications: make(chan string, 10)}
+	lc.cli = jrpc2.NewClient(cch, &jrpc2.ClientOptions{AllowV1: true, OnNotify: func(req *jrpc2.Request) {
+		var params []string
+		assert.Nil(t, req.UnmarshalParams(&params))
+		lc.notifications <- req.Method() + " " + strings.Join(params, ",")
+	}})
+	t.Cleanup(func() { lc.cli.Close() })
+	return lc
+}
+
+func (lc *lockClient) call(t *testing.T, method string) bool {
+	var result map[string]bool
+	assert.Nil(t, lc.cli.CallResult(context.Background(), method, []string{"ovn_northd"}, &result))
+	return result["locked"]
+}
+
+func (lc *lockClient) notification(t *testing.T) string {
+	select {
+	case n := <-lc.notifications:
+		return n
+	case <-time.After(5 * time.Second):
+		t.Fatal("no lock notification")
+		return ""
+	}
+}
+
+func TestLocks(t *testing.T) {
+	con := &DBServer{cli: newFakeEtcd().client(), log: klogr.New(), sessions: map[*jrpc2.Server]*ClientSession{},
+		metrics: newServerMetrics(stats.NewRegistry())}
+	owner, waiter, stealer := newLockClient(t, con), newLockClient(t, con), newLockClient(t, con)
+
+	assert.True(t, owner.call(t, "lock"))
+	assert.False(t, waiter.call(t, "lock"))
+
+	// the stealer takes the lock from the owner, which waits for it again
+	assert.True(t, stealer.call(t, "steal"))
+	assert.Equal(t, "stolen ovn_northd", owner.notification(t))
+
+	// the stealer unlocks, and one of the waiting clients acquires the lock
+	stealer.call(t, "unlock")
+	var next, other *lockClient
+	select {
+	case n := <-owner.notifications:
+		assert.Equal(t, "locked ovn_northd", n)
+		next, other = owner, waiter
+	case n := <-waiter.notifications:
+		assert.Equal(t, "locked ovn_northd", n)
+		next, other = waiter, owner
+	case <-time.After(5 * time.Second):
+		t.Fatal("the lock was not handed off")
+	}
+
+	// the lock is handed off to the last waiting client when its owner unlocks
+	next.call(t, "unlock")
+	assert.Equal(t, "locked ovn_northd", other.notification(t))
+	assert.Empty(t, owner.notifications)
+	assert.Empty(t, waiter.notifications)
+	assert.Empty(t, stealer.notifications)
+}
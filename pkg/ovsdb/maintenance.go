@@ -0,0 +1,123 @@
+package ovsdb
+
+import (
+	"context"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+// the election of the server that maintains etcd
+const MAINTENANCE_PREFIX = "maintenance/"
+
+// MaintenanceConfig configures the etcd maintenance
+type MaintenanceConfig struct {
+	// the interval between the maintenance rounds
+	Interval time.Duration
+	// the etcd history of the last Retention is kept, so clients that reconnect within it get only the changes they
+	// missed by monitor_cond_since
+	Retention time.Duration
+	// the etcd members are defragmented after a compaction, if there were at most QuietRevisions revisions since the
+	// previous round, a negative value disables the defragmentation
+	QuietRevisions int64
+}
+
+type revisionSample struct {
+	time     time.Time
+	revision int64
+}
+
+// StartMaintenance compacts the etcd history that is older than the retention window, and defragments the etcd
+// members during quiet periods. The servers that share the etcd cluster elect one server that maintains it, until the
+// context is canceled.
+func (con *DBServer) StartMaintenance(ctx context.Context, config MaintenanceConfig) error {
+	session, err := concurrency.NewSession(con.cli, concurrency.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	go func() {
+		defer session.Close()
+		election := concurrency.NewElection(session, MAINTENANCE_PREFIX)
+		if err := election.Campaign(ctx, con.uuid); err != nil {
+			if ctx.Err() == nil {
+				con.log.WithName("maintenance").Error(err, "Campaign failed")
+			}
+			return
+		}
+		con.log.WithName("maintenance").Info("The server maintains etcd", "server", con.uuid)
+		con.maintain(ctx, session.Done(), config)
+	}()
+	return nil
+}
+
+func (con *DBServer) maintain(ctx context.Context, sessionDone <-chan struct{}, config MaintenanceConfig) {
+	log := con.log.WithName("maintenance")
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+	// the revisions of the rounds within the retention window, the oldest first
+	samples := []revisionSample{}
+	var lastRevision, compacted int64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sessionDone:
+			log.Info("The server stopped maintaining etcd, the election session expired", "server", con.uuid)
+			return
+		case <-ticker.C:
+		}
+		resp, err := con.cli.Get(ctx, MAINTENANCE_PREFIX, clientv3.WithPrefix(), clientv3.WithCountOnly())
+		if err != nil {
+			log.Error(err, "Reading the etcd revision failed")
+			continue
+		}
+		now, revision := time.Now(), resp.Header.Revision
+		quiet := lastRevision > 0 && revision-lastRevision <= config.QuietRevisions
+		lastRevision = revision
+		samples = append(samples, revisionSample{time: now, revision: revision})
+		// compact the revisions before the latest revision that is out of the window
+		i := 0
+		for i < len(samples) && now.Sub(samples[i].time) >= config.Retention {
+			i++
+		}
+		if i == 0 {
+			continue
+		}
+		compactRevision := samples[i-1].revision
+		samples = samples[i:]
+		if compactRevision <= compacted {
+			continue
+		}
+		if _, err := con.cli.Compact(ctx, compactRevision); err != nil && err != rpctypes.ErrCompacted {
+			log.Error(err, "Compaction failed", "revision", compactRevision)
+			continue
+		}
+		log.V(5).Info("Compacted etcd", "revision", compactRevision)
+		compacted = compactRevision
+		if quiet {
+			con.defragment(ctx)
+		}
+	}
+}
+
+// defragment defragments the etcd members one by one, a member doesn't serve requests while it's defragmented
+func (con *DBServer) defragment(ctx context.Context) {
+	log := con.log.WithName("maintenance")
+	resp, err := con.cli.MemberList(ctx)
+	if err != nil {
+		log.Error(err, "Listing the etcd members failed")
+		return
+	}
+	for _, member := range resp.Members {
+		if len(member.ClientURLs) == 0 {
+			continue
+		}
+		if _, err := con.cli.Defragment(ctx, member.ClientURLs[0]); err != nil {
+			log.Error(err, "Defragmentation failed", "member", member.Name)
+			continue
+		}
+		log.Info("Defragmented an etcd member", "member", member.Name)
+	}
+}
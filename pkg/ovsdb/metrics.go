@@ -0,0 +1,149 @@
+package ovsdb
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ibm/ovsdb-etcd/pkg/stats"
+)
+
+// The buckets of the lock wait and hold times, the locks are usually held by long running clients, so the buckets
+// extend to hours
+var LOCK_BUCKETS = []float64{0.01, 0.1, 1, 10, 60, 300, 900, 3600, 4 * 3600, 24 * 3600}
+
+// serverMetrics are the metrics of the transactions, the etcd requests and the locks of the server
+type serverMetrics struct {
+	registry *stats.Registry
+	// by operation type and result, "ok" or the error of the operation
+	operations   *stats.Counter
+	transactions *stats.Histogram
+	// the rows that were read or written, by operation type
+	rows        *stats.Counter
+	etcdLatency *stats.Histogram
+	etcdTxnOps  *stats.Histogram
+	etcdRetries *stats.Counter
+	// the time that the clients waited for the locks and owned them
+	lockWait *stats.Histogram
+	lockHold *stats.Histogram
+	// by lock id, the clients that wait for the locks
+	lockWaiters *stats.Gauge
+	sessions    *stats.Gauge
+	// by database
+	monitors *stats.Gauge
+	// the notifications that are queued for all the clients, and their total size
+	notificationQueue      *stats.Gauge
+	notificationQueueBytes *stats.Gauge
+	notificationsDropped   *stats.Counter
+	// by database, the cache watches that were restarted after their revision was compacted
+	watchRestarts *stats.Counter
+	// by client, the counters of a client are removed when it disconnects
+	sentBytes *stats.Counter
+	// by database, the stored values whose checksums didn't match
+	corruptedValues *stats.Counter
+	// the counts of lockWaiters, the lock ids are chosen by the clients, so the gauge of a lock is removed when it has
+	// no waiters
+	lockWaitersMu     sync.Mutex
+	lockWaitersCounts map[string]int
+}
+
+func newServerMetrics(registry *stats.Registry) *serverMetrics {
+	return &serverMetrics{
+		registry: registry,
+		operations: registry.Counter("ovsdb_operations_total",
+			"Operations of transactions, by database, operation type and result.", "db", "op", "result"),
+		transactions: registry.Histogram("ovsdb_transaction_duration_seconds",
+			"Duration of transactions, by database.", stats.LATENCY_BUCKETS, "db"),
+		rows: registry.Counter("ovsdb_rows_total",
+			"Rows that were read or written, by database and operation type.", "db", "op"),
+		etcdLatency: registry.Histogram("ovsdb_etcd_request_duration_seconds",
+			"Duration of etcd requests, by request type.", stats.LATENCY_BUCKETS, "request"),
+		etcdTxnOps: registry.Histogram("ovsdb_etcd_txn_ops",
+			"Operations per etcd transaction.", stats.SIZE_BUCKETS),
+		etcdRetries: registry.Counter("ovsdb_etcd_retries_total",
+			"Etcd requests that were retried after transient errors, by request type.", "request"),
+		lockWait: registry.Histogram("ovsdb_lock_wait_seconds",
+			"Time that clients waited for OVSDB locks.", LOCK_BUCKETS),
+		lockHold: registry.Histogram("ovsdb_lock_hold_seconds",
+			"Time that clients owned OVSDB locks.", LOCK_BUCKETS),
+		lockWaiters: registry.Gauge("ovsdb_lock_waiters",
+			"Clients that wait for OVSDB locks, by lock.", "lock"),
+		sessions: registry.Gauge("ovsdb_sessions", "Active client sessions."),
+		monitors: registry.Gauge("ovsdb_monitors", "Active monitors, by database.", "db"),
+		notificationQueue: registry.Gauge("ovsdb_notification_queue_length",
+			"Notifications that are queued for the clients."),
+		notificationQueueBytes: registry.Gauge("ovsdb_notification_queue_bytes",
+			"Total size of the notifications that are queued for the clients."),
+		notificationsDropped: registry.Counter("ovsdb_notifications_dropped_total",
+			"Notifications that were dropped because the clients didn't read them fast enough."),
+		watchRestarts: registry.Counter("ovsdb_watch_restarts_total",
+			"Database watches that were restarted after their revision was compacted, by database.", "db"),
+		sentBytes: registry.Counter("ovsdb_client_sent_bytes_total",
+			"Bytes that were sent to the clients, by client connection.", "client"),
+		corruptedValues: registry.Counter("ovsdb_corrupted_values_total",
+			"Stored values whose checksums didn't match, by database.", "db"),
+		lockWaitersCounts: map[string]int{},
+	}
+}
+
+// Metrics returns the registry of the server metrics, which can be served to Prometheus
+func (con *DBServer) Metrics() *stats.Registry {
+	return con.metrics.registry
+}
+
+// transaction records the operations of a transaction by their results
+func (m *serverMetrics) transaction(dbName string, operations []interface{}, result interface{}, err error,
+	duration time.Duration) {
+	m.transactions.Observe(duration.Seconds(), dbName)
+	results, ok := result.([]interface{})
+	if err != nil || !ok {
+		return
+	}
+	for i, res := range results {
+		op := "unknown"
+		if i < len(operations) {
+			if valuesMap, ok := operations[i].(map[string]interface{}); ok {
+				if name, ok := valuesMap["op"].(string); ok {
+					op = name
+				}
+			}
+		}
+		switch r := res.(type) {
+		case map[string]string:
+			m.operations.Inc(dbName, op, r["error"])
+		case map[string]interface{}:
+			m.operations.Inc(dbName, op, "ok")
+			if rows, ok := r["rows"].([]map[string]interface{}); ok {
+				m.rows.Add(float64(len(rows)), dbName, op)
+			}
+		}
+	}
+}
+
+// addLockWaiters adds delta to the clients that wait for the lock id, and removes the gauge of the lock when it has no
+// waiters
+func (m *serverMetrics) addLockWaiters(id string, delta int) {
+	m.lockWaitersMu.Lock()
+	defer m.lockWaitersMu.Unlock()
+	n := m.lockWaitersCounts[id] + delta
+	if n <= 0 {
+		delete(m.lockWaitersCounts, id)
+		m.lockWaiters.Delete(id)
+		return
+	}
+	m.lockWaitersCounts[id] = n
+	m.lockWaiters.Set(float64(n), id)
+}
+
+// SentBytesCounter returns a function that counts the bytes that are sent to the client of the connection. It numbers
+// the connection, so it should be called before AddClient. The counter is removed when the session of the client is
+// closed.
+func (con *DBServer) SentBytesCounter(conn *ClientConnection) func(bytes int) {
+	con.mu.Lock()
+	con.connections++
+	conn.number = con.connections
+	con.mu.Unlock()
+	client := conn.metricsName()
+	return func(bytes int) {
+		con.metrics.sentBytes.Add(float64(bytes), client)
+	}
+}
@@ -0,0 +1,856 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/go-logr/logr"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+
+	ovsjson "github.com/ibm/ovsdb-etcd/pkg/json"
+)
+
+type monitorVersion int
+
+const (
+	// "monitor" requests, the updates are sent as <table-updates> in "update" notifications
+	monitorV1 monitorVersion = iota + 1
+	// "monitor_cond" requests, the updates are sent as <table-updates2> in "update2" notifications
+	monitorV2
+	// "monitor_cond_since" requests, the updates are sent as <table-updates2> in "update3" notifications, together
+	// with the transaction id of the update
+	monitorV3
+)
+
+// A monitor replicates the monitored tables of a database to a client. The rows are sent by the database cache, the
+// monitor filters them according to its conditions and monitored columns, and sends the updates to the client.
+type monitor struct {
+	// the JSON encoding of id, the monitor key in DBServer.monitors, protected by DBServer.mu
+	key      string
+	dbName   string
+	version  monitorVersion
+	dbSchema *ovsjson.DatabaseSchema
+	srv      *jrpc2.Server
+	tables   map[string]*tableMonitor
+	cache    *dbCache
+	notifier *notifier
+	log      logr.Logger
+	// the changes are sent to the client at most once per flushInterval, if it's not 0
+	flushInterval time.Duration
+	// mu protects the fields below, which can be modified by monitor_cond_change
+	mu sync.Mutex
+	// the <json-value> of the monitor request, it's sent back with every update notification
+	id interface{}
+	// conditions of the monitored tables, rows of tables without conditions are always sent
+	where map[string][]*condition
+	// the etcd revision of the last update that was sent to the client, or added to pending
+	revision int64
+	// the changes that weren't sent to the client yet, merged per row
+	pending    tablesChanges
+	flushTimer *time.Timer
+	stopped    bool
+	// the conditions that replace the conditions of their tables when the monitor reaches the revision of the rows
+	// that they were changed by, nil if there are none
+	nextWhere *whereChange
+	// signaled when nextWhere is applied, or the monitor is stopped
+	whereApplied *sync.Cond
+}
+
+// the new conditions of the tables of a monitor_cond_change request, and the changes of the rows that start or stop
+// matching them in the revision of the cache rows that they were computed from
+type whereChange struct {
+	revision int64
+	where    map[string][]*condition
+	changes  tablesChanges
+}
+
+// the monitored columns and the <monitor-select> of a table, merged from all the table monitor requests
+type tableMonitor struct {
+	// nil if all the columns are monitored
+	columns map[string]bool
+	initial bool
+	insert  bool
+	delete  bool
+	modify  bool
+}
+
+// AddMonitor registers a new monitor for the client connection, and returns the initial contents of the monitored
+// tables as <table-updates> or <table-updates2>, according to the monitor version. The requests map the monitored
+// tables to their <monitor-request> or <monitor-cond-request> objects, or arrays of them.
+func (con *DBServer) AddMonitor(ctx context.Context, version monitorVersion, dbName string, id interface{},
+	requests map[string]interface{}) (interface{}, error) {
+	_, _, updates, err := con.addMonitor(ctx, version, dbName, id, requests, 0)
+	return updates, err
+}
+
+// AddMonitorSince registers a new monitor like AddMonitor, but if the client already has the contents of the monitored
+// tables as of lastRevision, only the changes since that revision are returned. It returns whether lastRevision was
+// found, i.e. it's still available in the etcd history, and the revision of the returned contents.
+func (con *DBServer) AddMonitorSince(ctx context.Context, dbName string, id interface{}, requests map[string]interface{},
+	lastRevision int64) (bool, int64, interface{}, error) {
+	return con.addMonitor(ctx, monitorV3, dbName, id, requests, lastRevision)
+}
+
+func (con *DBServer) addMonitor(ctx context.Context, version monitorVersion, dbName string, id interface{},
+	requests map[string]interface{}, lastRevision int64) (bool, int64, interface{}, error) {
+	cache, err := con.getCache(ctx, dbName)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	dbSchema := cache.dbSchema
+	m := &monitor{id: id, dbName: dbName, version: version, dbSchema: dbSchema, srv: jrpc2.ServerFromContext(ctx),
+		tables: map[string]*tableMonitor{}, cache: cache, flushInterval: con.monitorFlushInterval,
+		where: map[string][]*condition{}, log: con.log.WithName("monitor").WithValues("db", dbName)}
+	tables := []string{}
+	for table, request := range requests {
+		tableSchema, ok := dbSchema.Tables[table]
+		if !ok {
+			return false, 0, nil, fmt.Errorf("unknown table %s", table)
+		}
+		tm, err := newTableMonitor(table, tableSchema, request)
+		if err != nil {
+			return false, 0, nil, err
+		}
+		where, err := parseWhere(table, tableSchema, request)
+		if err != nil {
+			return false, 0, nil, err
+		}
+		m.tables[table] = tm
+		m.where[table] = where
+		tables = append(tables, table)
+	}
+	monitorID, err := json.Marshal(id)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	m.key = string(monitorID)
+	m.log = m.log.WithValues("monitor", m.key)
+	con.mu.Lock()
+	cs := con.session(m.srv)
+	if cs.closed {
+		con.mu.Unlock()
+		return false, 0, nil, fmt.Errorf("connection is closed")
+	}
+	if _, ok := cs.monitors[m.key]; ok {
+		con.mu.Unlock()
+		return false, 0, nil, fmt.Errorf("duplicate monitor ID")
+	}
+	if cs.notifier == nil {
+		cs.notifier = newNotifier(m.srv, jrpc2.ServerMetrics(ctx), con.metrics, con.log.WithName("notifier"),
+			con.notificationLimits, con.resyncMonitor)
+	}
+	m.notifier = cs.notifier
+	cs.monitors[m.key] = m
+	con.metrics.monitors.Add(1, m.dbName)
+	con.mu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if lastRevision > 0 {
+		// recent revisions are served from the cache history, without reading the etcd history
+		if changes, revision, ok := cache.changesSince(m, tables, lastRevision); ok {
+			m.revision = revision
+			m.notifier.addMonitor(m, revision)
+			return true, revision, m.tableUpdates(m.filter(changes), false), nil
+		}
+	}
+	// the monitor is notified only about the changes after the revision of the snapshot, so no change is lost or sent
+	// twice
+	rows, revision := cache.snapshot(m, tables)
+	found, lastRows := false, tablesRows{}
+	// relays don't have the etcd history, so they send all the rows
+	if lastRevision > 0 && lastRevision <= revision && con.relay == nil {
+		// load the contents the client already has, they are the previous state of the returned changes
+		lastCache, err := con.loadCache(ctx, dbName, dbSchema, lastRevision)
+		switch err {
+		case nil:
+			found, lastRows = true, lastCache.rows
+		case rpctypes.ErrCompacted:
+			m.log.V(5).Info("Revision was compacted", "revision", lastRevision)
+		default:
+			con.removeMonitor(m)
+			return false, 0, nil, err
+		}
+	}
+	m.revision = revision
+	m.notifier.addMonitor(m, revision)
+	return found, revision, m.tableUpdates(m.diffRows(tables, lastRows, rows), !found), nil
+}
+
+// diffRows returns the changes of the tables rows from lastRows to rows, rows that don't match the monitor conditions
+// are considered missing
+func (m *monitor) diffRows(tables []string, lastRows, rows tablesRows) tablesChanges {
+	changes := tablesChanges{}
+	for _, table := range tables {
+		tableChanges := map[string]*rowChange{}
+		for rowUuid, r := range lastRows[table] {
+			tableChanges[rowUuid] = &rowChange{old: m.matching(table, rowUuid, r)}
+		}
+		for rowUuid, r := range rows[table] {
+			change, ok := tableChanges[rowUuid]
+			if !ok {
+				change = &rowChange{}
+				tableChanges[rowUuid] = change
+			}
+			change.new = m.matching(table, rowUuid, r)
+		}
+		changes[table] = tableChanges
+	}
+	return changes
+}
+
+// resyncMonitor sends the client a single update with all the changes since the given revision, after the queued
+// notifications of the monitor were dropped. The revision is the revision of the last notification that was sent.
+func (con *DBServer) resyncMonitor(m *monitor, revision int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped {
+		return
+	}
+	if m.nextWhere != nil {
+		// the resync replaces the changes of the new conditions
+		m.revision = m.nextWhere.revision
+		m.applyWhere()
+	}
+	m.pending = nil
+	tables := []string{}
+	for table := range m.tables {
+		tables = append(tables, table)
+	}
+	rows, current := m.cache.snapshot(nil, tables)
+	lastCache, err := con.loadCache(context.Background(), m.dbName, m.dbSchema, revision)
+	if err != nil {
+		// the client can't get the changes it missed, so it has to reconnect and monitor the database again
+		m.log.Error(err, "Resync failed, closing the connection", "revision", revision)
+		m.srv.Stop()
+		return
+	}
+	m.revision = current
+	updates := m.tableUpdates(m.diffRows(tables, lastCache.rows, rows), false)
+	if len(updates) == 0 {
+		m.notifier.resynced(m, "", nil, current)
+		return
+	}
+	method, params := m.notification(updates)
+	m.notifier.resynced(m, method, params, current)
+}
+
+// ChangeMonitor replaces the conditions of the tables in the requests of an existing monitor, and renames the monitor
+// to newID. The client is notified about the rows that start or stop matching the new conditions, as if they were
+// inserted or deleted.
+func (con *DBServer) ChangeMonitor(ctx context.Context, oldID, newID interface{}, requests map[string]interface{}) error {
+	srv := jrpc2.ServerFromContext(ctx)
+	oldKey, err := json.Marshal(oldID)
+	if err != nil {
+		return err
+	}
+	newKey, err := json.Marshal(newID)
+	if err != nil {
+		return err
+	}
+	con.mu.Lock()
+	cs := con.session(srv)
+	m, ok := cs.monitors[string(oldKey)]
+	if !ok {
+		con.mu.Unlock()
+		return fmt.Errorf("unknown monitor")
+	}
+	if _, ok := cs.monitors[string(newKey)]; ok && string(newKey) != m.key {
+		con.mu.Unlock()
+		return fmt.Errorf("duplicate monitor ID")
+	}
+	con.mu.Unlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	where := map[string][]*condition{}
+	for table, request := range requests {
+		if m.tables[table] == nil {
+			return fmt.Errorf("table %s is not monitored", table)
+		}
+		conditions, err := parseWhere(table, m.dbSchema.Tables[table], request)
+		if err != nil {
+			return err
+		}
+		where[table] = conditions
+	}
+
+	con.mu.Lock()
+	if cs.monitors[m.key] == m {
+		delete(cs.monitors, m.key)
+		m.key = string(newKey)
+		cs.monitors[m.key] = m
+	}
+	con.mu.Unlock()
+	m.id = newID
+	m.changeWhere(where)
+	return nil
+}
+
+// changeWhere replaces the conditions of the tables, and notifies the client about the rows that start or stop
+// matching them. The changes are computed from the current rows of the cache, which may be newer than the last update
+// of the monitor, if the cache didn't notify the monitor about its recent revisions yet. In that case the conditions
+// are replaced only when the monitor is updated to the revision of the rows, so the changes of the tables until then
+// are filtered by the previous conditions, and their rows are not reported twice. It's called with m.mu locked.
+func (m *monitor) changeWhere(where map[string][]*condition) {
+	if m.whereApplied == nil {
+		m.whereApplied = sync.NewCond(&m.mu)
+	}
+	for m.nextWhere != nil && !m.stopped {
+		m.whereApplied.Wait()
+	}
+	if m.stopped {
+		return
+	}
+	// the client should get the pending changes before the changes of the new conditions
+	m.flush()
+	tables := []string{}
+	for table := range where {
+		tables = append(tables, table)
+	}
+	rows, revision := m.cache.snapshot(nil, tables)
+	changes := tablesChanges{}
+	for table, conditions := range where {
+		tableChanges := map[string]*rowChange{}
+		for rowUuid, r := range rows[table] {
+			tableChanges[rowUuid] = &rowChange{old: m.matching(table, rowUuid, r)}
+		}
+		previous := m.where[table]
+		m.where[table] = conditions
+		for rowUuid, r := range rows[table] {
+			tableChanges[rowUuid].new = m.matching(table, rowUuid, r)
+		}
+		if revision > m.revision {
+			m.where[table] = previous
+		}
+		changes[table] = tableChanges
+	}
+	if revision > m.revision {
+		m.nextWhere = &whereChange{revision: revision, where: where, changes: changes}
+		return
+	}
+	m.notify(changes)
+}
+
+// applyWhere replaces the conditions by the next conditions if the monitor reached their revision, and adds the
+// changes of their rows to the pending changes. It's called with m.mu locked.
+func (m *monitor) applyWhere() {
+	if m.nextWhere == nil || m.nextWhere.revision > m.revision {
+		return
+	}
+	for table, conditions := range m.nextWhere.where {
+		m.where[table] = conditions
+	}
+	m.addPending(m.nextWhere.changes)
+	m.nextWhere = nil
+	m.whereApplied.Broadcast()
+}
+
+// CancelMonitor cancels the monitor with the given id of the client connection
+func (con *DBServer) CancelMonitor(ctx context.Context, id interface{}) error {
+	srv := jrpc2.ServerFromContext(ctx)
+	monitorID, err := json.Marshal(id)
+	if err != nil {
+		return err
+	}
+	con.mu.Lock()
+	m, ok := con.session(srv).monitors[string(monitorID)]
+	con.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown monitor")
+	}
+	con.removeMonitor(m)
+	return nil
+}
+
+func (con *DBServer) removeMonitor(m *monitor) {
+	m.cache.removeMonitor(m)
+	m.stop()
+	m.notifier.removeMonitor(m)
+	con.mu.Lock()
+	defer con.mu.Unlock()
+	if cs, ok := con.sessions[m.srv]; ok && cs.monitors[m.key] == m {
+		delete(cs.monitors, m.key)
+		con.metrics.monitors.Add(-1, m.dbName)
+	}
+}
+
+// update notifies the client about the changes of an etcd revision, unless they are already included in the rows that
+// were sent to the client. If the monitor has a flush interval, the changes are merged with the other changes of the
+// interval, and sent together when it ends.
+func (m *monitor) update(revision int64, changes tablesChanges) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped || revision <= m.revision {
+		return
+	}
+	m.revision = revision
+	m.addPending(m.filter(changes))
+	m.applyWhere()
+	m.schedule()
+}
+
+// updateLocal notifies the client about changes that are not stored in etcd, so they don't have a revision of their
+// own
+func (m *monitor) updateLocal(changes tablesChanges) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped {
+		return
+	}
+	m.addPending(m.filter(changes))
+	m.schedule()
+}
+
+// schedule sends the pending changes, immediately or at the end of the flush interval. It's called with m.mu locked.
+func (m *monitor) schedule() {
+	if m.flushInterval == 0 {
+		m.flush()
+		return
+	}
+	if m.flushTimer == nil {
+		m.flushTimer = time.AfterFunc(m.flushInterval, func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			m.flushTimer = nil
+			m.flush()
+		})
+	}
+}
+
+// filter returns the changes of the monitored tables, the previous and the new states of the rows that don't match the
+// monitor conditions are considered missing
+func (m *monitor) filter(changes tablesChanges) tablesChanges {
+	filtered := tablesChanges{}
+	for table, tableChanges := range changes {
+		if m.tables[table] == nil {
+			continue
+		}
+		filteredChanges := map[string]*rowChange{}
+		for rowUuid, change := range tableChanges {
+			filteredChanges[rowUuid] = &rowChange{old: m.matching(table, rowUuid, change.old),
+				new: m.matching(table, rowUuid, change.new)}
+		}
+		filtered[table] = filteredChanges
+	}
+	return filtered
+}
+
+// addPending merges the changes into the pending changes, a row that was changed several times keeps its first
+// previous state and its last new state
+func (m *monitor) addPending(changes tablesChanges) {
+	if m.pending == nil {
+		m.pending = tablesChanges{}
+	}
+	for table, tableChanges := range changes {
+		pendingChanges, ok := m.pending[table]
+		if !ok {
+			m.pending[table] = tableChanges
+			continue
+		}
+		for rowUuid, change := range tableChanges {
+			if pending, ok := pendingChanges[rowUuid]; ok {
+				pending.new = change.new
+			} else {
+				pendingChanges[rowUuid] = change
+			}
+		}
+	}
+}
+
+// flush sends the pending changes to the client, it's called with m.mu locked
+func (m *monitor) flush() {
+	if m.stopped || len(m.pending) == 0 {
+		return
+	}
+	changes := m.pending
+	m.pending = nil
+	m.notify(changes)
+}
+
+// stop drops the pending changes, no more updates are sent to the client after it returns
+func (m *monitor) stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stopped = true
+	m.pending = nil
+	if m.whereApplied != nil {
+		m.whereApplied.Broadcast()
+	}
+	if m.flushTimer != nil {
+		m.flushTimer.Stop()
+		m.flushTimer = nil
+	}
+}
+
+// notify queues the update notification of the changes to the client
+func (m *monitor) notify(changes tablesChanges) {
+	updates := m.tableUpdates(changes, false)
+	if len(updates) == 0 {
+		return
+	}
+	method, params := m.notification(updates)
+	m.notifier.enqueue(m, method, params, m.revision, false)
+}
+
+// notification returns the method and the params of the update notification of the monitor version
+func (m *monitor) notification(updates map[string]map[string]interface{}) (string, interface{}) {
+	switch m.version {
+	case monitorV2:
+		return "update2", []interface{}{m.id, updates}
+	case monitorV3:
+		// "params": [<json-value>, <last-txn-id>, <table-updates2>]
+		return "update3", []interface{}{m.id, revisionToUuid(m.revision), updates}
+	}
+	return "update", []interface{}{m.id, updates}
+}
+
+// tableRequests returns the <monitor-request> objects of a table, the request of a table can be a single object or an
+// array of them
+func tableRequests(table string, request interface{}) ([]map[string]interface{}, error) {
+	var requests []interface{}
+	switch r := request.(type) {
+	case []interface{}:
+		requests = r
+	default:
+		requests = []interface{}{r}
+	}
+	var objects []map[string]interface{}
+	for _, r := range requests {
+		req, ok := r.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("wrong monitor request of table %s: %v", table, r)
+		}
+		objects = append(objects, req)
+	}
+	return objects, nil
+}
+
+// newTableMonitor parses the "columns" and "select" members of the table monitor requests. The table monitor includes
+// the columns and the types of changes that are requested by at least one of the requests.
+func newTableMonitor(table string, tableSchema *ovsjson.TableSchema, request interface{}) (*tableMonitor, error) {
+	requests, err := tableRequests(table, request)
+	if err != nil {
+		return nil, err
+	}
+	if len(requests) == 0 {
+		return &tableMonitor{initial: true, insert: true, delete: true, modify: true}, nil
+	}
+	tm := &tableMonitor{columns: map[string]bool{}}
+	allColumns := false
+	for _, req := range requests {
+		if columns, ok := req["columns"]; ok {
+			list, ok := columns.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("wrong columns of table %s: %v", table, columns)
+			}
+			for _, c := range list {
+				colName, ok := c.(string)
+				if !ok {
+					return nil, fmt.Errorf("wrong column of table %s: %v", table, c)
+				}
+				if colName == COL_UUID || colName == COL_VERSION {
+					// requested by the replication of ovsdb-server, the rows uuids are always sent, and the row
+					// versions are not reported
+					continue
+				}
+				if _, ok := tableSchema.Columns[colName]; !ok {
+					return nil, fmt.Errorf("unknown column %s in table %s", colName, table)
+				}
+				tm.columns[colName] = true
+			}
+		} else {
+			allColumns = true
+		}
+		sel := map[string]interface{}{}
+		if s, ok := req["select"]; ok {
+			if sel, ok = s.(map[string]interface{}); !ok {
+				return nil, fmt.Errorf("wrong select of table %s: %v", table, s)
+			}
+		}
+		for name, value := range map[string]*bool{"initial": &tm.initial, "insert": &tm.insert, "delete": &tm.delete,
+			"modify": &tm.modify} {
+			v, ok := sel[name]
+			if !ok {
+				// all the changes are selected by default
+				*value = true
+				continue
+			}
+			b, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("wrong select %s of table %s: %v", name, table, v)
+			}
+			*value = *value || b
+		}
+	}
+	if allColumns {
+		tm.columns = nil
+	}
+	return tm, nil
+}
+
+// selected returns true if the type of the row change is selected. The previous state of inserted rows is nil, and
+// the new state of deleted rows is nil.
+func (tm *tableMonitor) selected(change *rowChange, initial bool) bool {
+	switch {
+	case change.old == nil && initial:
+		return tm.initial
+	case change.old == nil:
+		return tm.insert
+	case change.new == nil:
+		return tm.delete
+	}
+	return tm.modify
+}
+
+// project returns a copy of the row with the monitored columns only
+func (tm *tableMonitor) project(r row) row {
+	if r == nil || tm.columns == nil {
+		return r
+	}
+	p := row{}
+	for colName, value := range r {
+		if tm.columns[colName] {
+			p[colName] = value
+		}
+	}
+	return p
+}
+
+// parseWhere returns the conditions of the table monitor requests. A row is sent if it matches at least one of the
+// "where" clauses, so if one of the requests doesn't have a "where" clause, all the rows are sent and nil is returned.
+func parseWhere(table string, tableSchema *ovsjson.TableSchema, request interface{}) ([]*condition, error) {
+	requests, err := tableRequests(table, request)
+	if err != nil {
+		return nil, err
+	}
+	var conditions []*condition
+	for _, req := range requests {
+		where, ok := req["where"]
+		if !ok {
+			return nil, nil
+		}
+		clauses, ok := where.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("wrong where clause of table %s: %v", table, where)
+		}
+		if len(clauses) == 0 {
+			return nil, nil
+		}
+		for _, clause := range clauses {
+			c, err := newCondition(clause, table, tableSchema)
+			if err != nil {
+				return nil, err
+			}
+			conditions = append(conditions, c)
+		}
+	}
+	return conditions, nil
+}
+
+// match returns true if the row of the table should be sent to the client
+func (m *monitor) match(table, rowUuid string, r row) bool {
+	ok, err := matchAny(m.where[table], rowUuid, r)
+	if err != nil {
+		m.log.Error(err, "Evaluating the conditions failed", "table", table, "uuid", rowUuid)
+		return false
+	}
+	return ok
+}
+
+// matching returns the row if it matches the monitor conditions of the table, and nil otherwise
+func (m *monitor) matching(table, rowUuid string, r row) row {
+	if r == nil || !m.match(table, rowUuid, r) {
+		return nil
+	}
+	return r
+}
+
+// tableUpdates builds the <table-updates> or <table-updates2> object, according to the monitor version, from the row
+// changes. The changes contain the previous state only of the rows that matched the monitor conditions, so rows that
+// start matching them are reported as inserted, and rows that stop matching them as deleted. If initial is true, the
+// inserted rows of <table-updates2> are reported as "initial". Only the monitored columns and the selected types of
+// changes are included.
+func (m *monitor) tableUpdates(changes tablesChanges, initial bool) map[string]map[string]interface{} {
+	updates := map[string]map[string]interface{}{}
+	for table, tableChanges := range changes {
+		for rowUuid, change := range tableChanges {
+			tm := m.tables[table]
+			if !tm.selected(change, initial) {
+				continue
+			}
+			projected := &rowChange{old: tm.project(change.old), new: tm.project(change.new)}
+			var update interface{}
+			if m.version == monitorV1 {
+				update = projected.rowUpdate(m.dbSchema.Tables[table])
+			} else {
+				update = projected.rowUpdate2(m.log, m.dbSchema.Tables[table], initial)
+			}
+			if update == nil {
+				continue
+			}
+			tableUpdates, ok := updates[table]
+			if !ok {
+				tableUpdates = map[string]interface{}{}
+				updates[table] = tableUpdates
+			}
+			tableUpdates[rowUuid] = update
+		}
+	}
+	return updates
+}
+
+// rowUpdate returns the <row-update> of the change, or nil if the row wasn't changed. Inserted rows contain only "new",
+// deleted rows only "old", and modified rows contain the complete "new" row and the previous values of the modified
+// columns in "old".
+func (change *rowChange) rowUpdate(tableSchema *ovsjson.TableSchema) interface{} {
+	switch {
+	case change.old == nil && change.new == nil:
+		return nil
+	case change.old == nil:
+		return map[string]row{"new": change.new}
+	case change.new == nil:
+		return map[string]row{"old": change.old}
+	}
+	// columns with default values are not stored, but the "old" and "new" rows of a modification contain the
+	// default values of the modified columns, so replicas that apply the updates, e.g. backup ovsdb-servers, clear
+	// the columns
+	old, newRow := row{}, change.new.copy()
+	for colName := range columnsUnion(change.old, change.new) {
+		if reflect.DeepEqual(change.old[colName], change.new[colName]) {
+			continue
+		}
+		colSchema, ok := tableSchema.Columns[colName]
+		if !ok {
+			continue
+		}
+		if value, ok := change.old[colName]; ok {
+			old[colName] = value
+		} else {
+			old[colName] = defaultValue(&colSchema.Type)
+		}
+		if _, ok := change.new[colName]; !ok {
+			newRow[colName] = defaultValue(&colSchema.Type)
+		}
+	}
+	if len(old) == 0 {
+		return nil
+	}
+	return map[string]row{"old": old, "new": newRow}
+}
+
+// rowUpdate2 returns the <row-update2> of the change, or nil if the row wasn't changed. Inserted rows contain the
+// complete row in "initial" or "insert", deleted rows contain null "delete", and modified rows contain in "modify" the
+// new values of the modified atomic columns, and the differences between the old and the new values of the modified
+// set and map columns.
+func (change *rowChange) rowUpdate2(log logr.Logger, tableSchema *ovsjson.TableSchema, initial bool) interface{} {
+	switch {
+	case change.old == nil && change.new == nil:
+		return nil
+	case change.old == nil && initial:
+		return map[string]interface{}{"initial": change.new}
+	case change.old == nil:
+		return map[string]interface{}{"insert": change.new}
+	case change.new == nil:
+		return map[string]interface{}{"delete": nil}
+	}
+	modify := row{}
+	for colName := range columnsUnion(change.old, change.new) {
+		if reflect.DeepEqual(change.old[colName], change.new[colName]) {
+			continue
+		}
+		colSchema, ok := tableSchema.Columns[colName]
+		if !ok {
+			continue
+		}
+		diff, err := diffValue(&colSchema.Type, change.old[colName], change.new[colName])
+		if err != nil {
+			log.Error(err, "Comparing values failed", "column", colName)
+			continue
+		}
+		if diff != nil {
+			modify[colName] = diff
+		}
+	}
+	if len(modify) == 0 {
+		return nil
+	}
+	return map[string]interface{}{"modify": modify}
+}
+
+// diffValue returns the difference between the old and the new value of a column, as it's sent in the "modify" member
+// of <row-update2>, or nil if the values are equal. Missing values are replaced by the column default value.
+// For atomic columns the difference is the new value. For sets it's the set of the elements that were added or
+// removed. For maps it's a map with the removed pairs, the added pairs, and the new values of the modified keys.
+func diffValue(colType *ovsjson.ColumnType, oldValue, newValue interface{}) (interface{}, error) {
+	if oldValue == nil {
+		oldValue = defaultValue(colType)
+	}
+	if newValue == nil {
+		newValue = defaultValue(colType)
+	}
+	if !colType.IsSet() && !colType.IsMap() {
+		if reflect.DeepEqual(oldValue, newValue) {
+			return nil, nil
+		}
+		return newValue, nil
+	}
+	oldNorm, err := normalizeValue(oldValue)
+	if err != nil {
+		return nil, err
+	}
+	newNorm, err := normalizeValue(newValue)
+	if err != nil {
+		return nil, err
+	}
+	if colType.IsSet() {
+		oldElements, newElements := wireSetElements(oldNorm), wireSetElements(newNorm)
+		diff := []interface{}{}
+		for _, e := range oldElements {
+			if !contains(newElements, e) {
+				diff = append(diff, e)
+			}
+		}
+		for _, e := range newElements {
+			if !contains(oldElements, e) {
+				diff = append(diff, e)
+			}
+		}
+		if len(diff) == 0 {
+			return nil, nil
+		}
+		return []interface{}{"set", diff}, nil
+	}
+	oldPairs, newPairs := wireMapPairs(oldNorm), wireMapPairs(newNorm)
+	diff := []interface{}{}
+	for key, oldPair := range oldPairs {
+		if _, ok := newPairs[key]; !ok {
+			diff = append(diff, oldPair)
+		}
+	}
+	for key, newPair := range newPairs {
+		if oldPair, ok := oldPairs[key]; !ok || !reflect.DeepEqual(oldPair, newPair) {
+			diff = append(diff, newPair)
+		}
+	}
+	if len(diff) == 0 {
+		return nil, nil
+	}
+	return []interface{}{"map", diff}, nil
+}
+
+func columnsUnion(r1, r2 row) map[string]bool {
+	columns := map[string]bool{}
+	for colName := range r1 {
+		columns[colName] = true
+	}
+	for colName := range r2 {
+		columns[colName] = true
+	}
+	return columns
+}
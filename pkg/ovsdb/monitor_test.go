@@ -0,0 +1,99 @@
+package ovsdb
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	ovsjson "github.com/ibm/ovsdb-etcd/pkg/json"
+	"github.com/ibm/ovsdb-etcd/pkg/klogr"
+)
+
+func testDiff(t *testing.T, colType ovsjson.ColumnType, oldValue, newValue interface{}, expected string) {
+	diff, err := diffValue(&colType, oldValue, newValue)
+	assert.Nil(t, err)
+	b, err := json.Marshal(diff)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, string(b))
+}
+
+func TestDiffAtoms(t *testing.T) {
+	testDiff(t, ovsjson.ColumnType{Key: integerType, Min: 1, Max: 1}, int64(1), int64(2), `2`)
+	testDiff(t, ovsjson.ColumnType{Key: integerType, Min: 1, Max: 1}, int64(2), int64(2), `null`)
+	testDiff(t, ovsjson.ColumnType{Key: stringType, Min: 1, Max: 1}, "a", nil, `""`)
+}
+
+func TestDiffSets(t *testing.T) {
+	setType := ovsjson.ColumnType{Key: stringType, Min: 0, Max: ovsjson.Unlimited}
+	testDiff(t, setType, ovsjson.Set{"a", "b"}, ovsjson.Set{"b", "c"}, `["set",["a","c"]]`)
+	testDiff(t, setType, "a", ovsjson.Set{"a", "b"}, `["set",["b"]]`)
+	testDiff(t, setType, "a", nil, `["set",["a"]]`)
+	testDiff(t, setType, ovsjson.Set{"a", "b"}, ovsjson.Set{"b", "a"}, `null`)
+	optionalType := ovsjson.ColumnType{Key: uuidType, Min: 0, Max: 1}
+	testDiff(t, optionalType, ovsjson.Uuid("25f2e69e-4bac-4529-9082-9f94da060cf1"), nil,
+		`["set",[["uuid","25f2e69e-4bac-4529-9082-9f94da060cf1"]]]`)
+}
+
+func TestDiffMaps(t *testing.T) {
+	mapType := ovsjson.ColumnType{Key: stringType, Value: stringType, Min: 0, Max: ovsjson.Unlimited}
+	testDiff(t, mapType, ovsjson.Map{"a": "1"}, ovsjson.Map{"a": "1", "b": "2"}, `["map",[["b","2"]]]`)
+	testDiff(t, mapType, ovsjson.Map{"a": "1"}, ovsjson.Map{"a": "2"}, `["map",[["a","2"]]]`)
+	testDiff(t, mapType, ovsjson.Map{"a": "1"}, nil, `["map",[["a","1"]]]`)
+	testDiff(t, mapType, ovsjson.Map{"a": "1"}, ovsjson.Map{"a": "1"}, `null`)
+}
+
+func TestRowUpdateDefaults(t *testing.T) {
+	var tableSchema ovsjson.TableSchema
+	assert.Nil(t, json.Unmarshal([]byte(portBindingSchema), &tableSchema))
+	change := &rowChange{old: row{"logical_port": "lsp1", "options": ovsjson.Map{"peer": "lrp1"}},
+		new: row{"logical_port": "lsp1", "tunnel_key": int64(3)}}
+	b, err := json.Marshal(change.rowUpdate(&tableSchema))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"new":{"logical_port":"lsp1","options":["map",[]],"tunnel_key":3},`+
+		`"old":{"options":["map",[["peer","lrp1"]]],"tunnel_key":0}}`, string(b))
+	assert.Nil(t, (&rowChange{old: change.new, new: change.new}).rowUpdate(&tableSchema))
+}
+
+func TestChangeWhereBeforeUpdate(t *testing.T) {
+	var tableSchema ovsjson.TableSchema
+	assert.Nil(t, json.Unmarshal([]byte(portBindingSchema), &tableSchema))
+	dbSchema := &ovsjson.DatabaseSchema{Tables: map[string]*ovsjson.TableSchema{"Port_Binding": &tableSchema,
+		"Chassis": {Columns: map[string]*ovsjson.ColumnSchema{}}}}
+	c := newDBCache(klogr.New(), "OVN_Southbound", dbSchema)
+	all := &tableMonitor{initial: true, insert: true, delete: true, modify: true}
+	// the monitor is created before lsp1 is inserted, the flush interval keeps the changes pending
+	m := &monitor{version: monitorV2, dbSchema: dbSchema, cache: c, log: klogr.New(), flushInterval: time.Hour,
+		tables: map[string]*tableMonitor{"Port_Binding": all, "Chassis": all}, where: map[string][]*condition{},
+		revision: 3}
+	c.monitors[m] = true
+	lsp1 := row{"logical_port": "lsp1"}
+	changes := tablesChanges{"Port_Binding": {"u1": {new: lsp1}}, "Chassis": {"c1": {new: row{}}}}
+	c.rows["Port_Binding"]["u1"] = lsp1
+	c.rows["Chassis"]["c1"] = row{}
+	c.revision = 4
+
+	// the cache rows include lsp1, but the monitor wasn't updated yet
+	var cond interface{}
+	assert.Nil(t, json.Unmarshal([]byte(`["logical_port", "==", "lsp1"]`), &cond))
+	lsp1Condition, err := newCondition(cond, "Port_Binding", &tableSchema)
+	assert.Nil(t, err)
+	m.mu.Lock()
+	m.changeWhere(map[string][]*condition{"Port_Binding": {lsp1Condition}})
+	m.mu.Unlock()
+	assert.Empty(t, m.pending)
+
+	// lsp1 and the chassis are reported once, as inserted
+	m.update(4, changes)
+	assert.Equal(t, map[string]map[string]interface{}{
+		"Port_Binding": {"u1": map[string]interface{}{"insert": row{"logical_port": "lsp1"}}},
+		"Chassis":      {"c1": map[string]interface{}{"insert": row{}}},
+	}, m.tableUpdates(m.pending, false))
+
+	// the next changes are filtered by the new conditions
+	m.pending = nil
+	m.update(5, tablesChanges{"Port_Binding": {"u2": {new: row{"logical_port": "lsp2"}}}})
+	assert.Empty(t, m.tableUpdates(m.pending, false))
+	m.stop()
+}
@@ -0,0 +1,70 @@
+package ovsdb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SetDatabaseNamespaces sets the etcd namespaces of the databases, by database name, so several deployments can share
+// an etcd cluster and some of their databases, e.g. every ovn-interconnect zone has its own OVN_Northbound and
+// OVN_Southbound databases, and all the zones share the interconnect databases. The keys of a database, its rows, its
+// schema and its election, are prefixed by <namespace>/. The server-wide keys, the locks and the server ids, are in
+// the namespace of the _Server database. The databases without a namespace are in the root of the keyspace, or of the
+// etcd prefix of the server.
+func (con *DBServer) SetDatabaseNamespaces(namespaces map[string]string) error {
+	con.namespaces = map[string]string{}
+	for dbName, ns := range namespaces {
+		ns = strings.Trim(ns, "/")
+		if len(ns) == 0 {
+			continue
+		}
+		for _, reserved := range []string{"ovsdb/", SCHEMAS_PREFIX, ELECTIONS_PREFIX, LOCKS_PREFIX, SERVERS_PREFIX,
+			MAINTENANCE_PREFIX} {
+			if strings.HasPrefix(ns+"/", reserved) {
+				return fmt.Errorf("namespace %s of %s is reserved", ns, dbName)
+			}
+		}
+		con.namespaces[dbName] = ns + "/"
+	}
+	return nil
+}
+
+// namespace returns the key prefix of the namespace of the database, empty if it's in the root namespace
+func (con *DBServer) namespace(dbName string) string {
+	return con.namespaces[dbName]
+}
+
+// usedNamespaces returns the sorted key prefixes of the namespaces of the databases, including the root namespace
+func (con *DBServer) usedNamespaces() []string {
+	used := map[string]bool{"": true}
+	for _, ns := range con.namespaces {
+		used[ns] = true
+	}
+	namespaces := make([]string, 0, len(used))
+	for ns := range used {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// dbPrefix returns the prefix of the data keys of the database, in its namespace
+func (con *DBServer) dbPrefix(dbName string) string {
+	return con.namespace(dbName) + dataPrefix(dbName)
+}
+
+// schemaKey returns the key of the stored schema of the database, in its namespace
+func (con *DBServer) schemaKey(dbName string) string {
+	return con.namespace(dbName) + SCHEMAS_PREFIX + dbName
+}
+
+// serverRowKey returns the key of the _Server Database row of the database
+func (con *DBServer) serverRowKey(dbName string) string {
+	return con.dbPrefix("_Server") + "Database/" + dbName
+}
+
+// serverNamespace returns the key prefix of the server-wide keys, the namespace of the _Server database
+func (con *DBServer) serverNamespace() string {
+	return con.namespace("_Server")
+}
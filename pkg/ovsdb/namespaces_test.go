@@ -0,0 +1,23 @@
+package ovsdb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDatabaseNamespaces(t *testing.T) {
+	con := &DBServer{}
+	assert.Nil(t, con.SetDatabaseNamespaces(map[string]string{"OVN_Northbound": "zone1/", "_Server": "zone1",
+		"OVN_IC_Northbound": ""}))
+	assert.Equal(t, "zone1/ovsdb/OVN_Northbound/", con.dbPrefix("OVN_Northbound"))
+	assert.Equal(t, "zone1/schemas/OVN_Northbound", con.schemaKey("OVN_Northbound"))
+	assert.Equal(t, "zone1/ovsdb/_Server/Database/OVN_IC_Northbound", con.serverRowKey("OVN_IC_Northbound"))
+	assert.Equal(t, "ovsdb/OVN_IC_Northbound/", con.dbPrefix("OVN_IC_Northbound"))
+	assert.Equal(t, "schemas/OVN_IC_Northbound", con.schemaKey("OVN_IC_Northbound"))
+	assert.Equal(t, "zone1/", con.serverNamespace())
+	assert.Equal(t, []string{"", "zone1/"}, con.usedNamespaces())
+
+	assert.NotNil(t, con.SetDatabaseNamespaces(map[string]string{"OVN_Northbound": "locks"}))
+	assert.NotNil(t, con.SetDatabaseNamespaces(map[string]string{"OVN_Northbound": "ovsdb/zone1"}))
+}
@@ -0,0 +1,233 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/metrics"
+	"github.com/go-logr/logr"
+)
+
+// The policies of handling clients that don't read their notifications fast enough
+const (
+	// the monitors of the client wait until there is room in the client queue, which delays the updates of the other
+	// clients of the database
+	SLOW_CLIENT_BLOCK = "block"
+	// the queued notifications are dropped, and every monitor that lost notifications sends the client a single
+	// update with all the changes since the last notification that was sent
+	SLOW_CLIENT_RESYNC = "resync"
+	// the client connection is closed
+	SLOW_CLIENT_DISCONNECT = "disconnect"
+)
+
+// NotificationLimits limit the notifications that are queued for a single client
+type NotificationLimits struct {
+	// the maximal number of queued notifications, 0 if it's not limited
+	MaxNotifications int
+	// the maximal total size of the queued notifications, 0 if it's not limited
+	MaxBytes int
+	// the policy of handling clients that exceed the limits
+	Policy string
+}
+
+type queuedNotification struct {
+	m        *monitor
+	method   string
+	params   json.RawMessage
+	revision int64
+}
+
+// A notifier sends the monitor notifications to a client from a queue, so a client that doesn't read its
+// notifications doesn't block the database cache and the other clients.
+type notifier struct {
+	srv     *jrpc2.Server
+	metrics *metrics.M
+	stats   *serverMetrics
+	log     logr.Logger
+	limits  NotificationLimits
+	// resync is called for monitors that lost notifications, with the revision of the last notification that was sent
+	resync func(m *monitor, revision int64)
+	mu     sync.Mutex
+	// signaled when the queue is changed or the notifier is closed
+	cond  *sync.Cond
+	queue []*queuedNotification
+	bytes int
+	// the revision of the last notification of every monitor that was sent, or of its initial contents
+	sent map[*monitor]int64
+	// the monitors that wait for resync, their notifications are dropped
+	resyncing map[*monitor]bool
+	closed    bool
+	// the queue length and size that were added to the server-wide gauges
+	reportedLength int
+	reportedBytes  int
+}
+
+func newNotifier(srv *jrpc2.Server, m *metrics.M, stats *serverMetrics, log logr.Logger, limits NotificationLimits,
+	resync func(m *monitor, revision int64)) *notifier {
+	n := &notifier{srv: srv, metrics: m, stats: stats, log: log, limits: limits, resync: resync,
+		sent: map[*monitor]int64{}, resyncing: map[*monitor]bool{}}
+	n.cond = sync.NewCond(&n.mu)
+	go n.run()
+	return n
+}
+
+// addMonitor registers a monitor, whose initial contents of the given revision were sent to the client
+func (n *notifier) addMonitor(m *monitor, revision int64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sent[m] = revision
+}
+
+// removeMonitor drops the queued notifications of the monitor
+func (n *notifier) removeMonitor(m *monitor) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	queue := n.queue[:0]
+	for _, next := range n.queue {
+		if next.m == m {
+			n.bytes -= len(next.params)
+		} else {
+			queue = append(queue, next)
+		}
+	}
+	n.queue = queue
+	delete(n.sent, m)
+	delete(n.resyncing, m)
+	n.report()
+	n.cond.Broadcast()
+}
+
+// report updates the server-wide gauges of the queued notifications by the changes of the queue, it's called with
+// n.mu locked
+func (n *notifier) report() {
+	n.stats.notificationQueue.Add(float64(len(n.queue) - n.reportedLength))
+	n.stats.notificationQueueBytes.Add(float64(n.bytes - n.reportedBytes))
+	n.reportedLength, n.reportedBytes = len(n.queue), n.bytes
+}
+
+// enqueue adds a notification of the monitor to the client queue, if the queue is full it's handled according to the
+// limits policy. A forced notification is added even if the queue is full.
+func (n *notifier) enqueue(m *monitor, method string, params interface{}, revision int64, force bool) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		n.log.Error(err, "Marshaling the notification failed", "db", m.dbName, "method", method)
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.resyncing[m] && !force {
+		n.stats.notificationsDropped.Inc()
+		return
+	}
+	for !n.closed && !force && n.full(len(data)) {
+		switch n.limits.Policy {
+		case SLOW_CLIENT_RESYNC:
+			n.dropQueue(m)
+			return
+		case SLOW_CLIENT_DISCONNECT:
+			n.log.Info("Client notifications exceed the limits, closing the connection",
+				"maxNotifications", n.limits.MaxNotifications, "maxBytes", n.limits.MaxBytes)
+			n.metrics.Count("ovsdb.notifications.disconnectedClients", 1)
+			n.closeLocked()
+			go n.srv.Stop()
+			return
+		default:
+			n.cond.Wait()
+		}
+	}
+	if n.closed {
+		return
+	}
+	n.queue = append(n.queue, &queuedNotification{m: m, method: method, params: data, revision: revision})
+	n.bytes += len(data)
+	n.metrics.SetMaxValue("ovsdb.notifications.queueLength", int64(len(n.queue)))
+	n.metrics.SetMaxValue("ovsdb.notifications.queueBytes", int64(n.bytes))
+	n.report()
+	n.cond.Broadcast()
+}
+
+// full returns true if a notification of the given size exceeds the limits, a single notification is always allowed
+func (n *notifier) full(size int) bool {
+	if len(n.queue) == 0 {
+		return false
+	}
+	return (n.limits.MaxNotifications > 0 && len(n.queue) >= n.limits.MaxNotifications) ||
+		(n.limits.MaxBytes > 0 && n.bytes+size > n.limits.MaxBytes)
+}
+
+// dropQueue drops all the queued notifications, and resyncs their monitors and the monitor m, whose notification
+// couldn't be queued
+func (n *notifier) dropQueue(m *monitor) {
+	n.metrics.Count("ovsdb.notifications.dropped", int64(len(n.queue)+1))
+	n.stats.notificationsDropped.Add(float64(len(n.queue) + 1))
+	monitors := map[*monitor]bool{m: true}
+	for _, next := range n.queue {
+		monitors[next.m] = true
+	}
+	n.queue = nil
+	n.bytes = 0
+	n.report()
+	for dm := range monitors {
+		n.resyncing[dm] = true
+		// the monitors are locked by their callers, so the resync runs on its own
+		go n.resync(dm, n.sent[dm])
+	}
+	n.cond.Broadcast()
+}
+
+// resynced ends the resync of the monitor, and queues its resync notification
+func (n *notifier) resynced(m *monitor, method string, params interface{}, revision int64) {
+	n.mu.Lock()
+	delete(n.resyncing, m)
+	n.mu.Unlock()
+	if params != nil {
+		n.enqueue(m, method, params, revision, true)
+	}
+}
+
+func (n *notifier) run() {
+	for {
+		n.mu.Lock()
+		for len(n.queue) == 0 && !n.closed {
+			n.cond.Wait()
+		}
+		if n.closed {
+			n.mu.Unlock()
+			return
+		}
+		next := n.queue[0]
+		n.queue = n.queue[1:]
+		n.bytes -= len(next.params)
+		if _, ok := n.sent[next.m]; ok {
+			n.sent[next.m] = next.revision
+		}
+		n.report()
+		n.cond.Broadcast()
+		n.mu.Unlock()
+		err := n.srv.Notify(context.Background(), next.method, next.params)
+		if err == jrpc2.ErrConnClosed {
+			n.close()
+			return
+		}
+		if err != nil {
+			n.log.Error(err, "Notification failed", "db", next.m.dbName, "method", next.method)
+		}
+	}
+}
+
+// close drops the queued notifications and stops the notifier
+func (n *notifier) close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.closeLocked()
+}
+
+func (n *notifier) closeLocked() {
+	n.closed = true
+	n.queue = nil
+	n.bytes = 0
+	n.report()
+	n.cond.Broadcast()
+}
@@ -5,33 +5,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/creachadair/jrpc2"
-	"strconv"
-	"strings"
 	"time"
 
 	ovsjson "github.com/ibm/ovsdb-etcd/pkg/json"
+	"github.com/ibm/ovsdb-etcd/pkg/trace"
 )
 
 type ServOVSDB struct {
 	dbServer *DBServer
 }
 
-type InitialData struct {
-	Name      string `json:"name"`
-	Model     string `json:"model"`
-	Connected bool   `json:"connected"`
-	Schema    string `json:"schema"`
-	Leader    bool   `json:"leader"`
-}
-
-type Databases struct {
-	Database map[string]ovsjson.Initial `json:"Database"`
-}
-
-type TransactionResponse struct {
-	Rows []map[string]string
-}
-
 // This operation retrieves an array whose elements are the names of the
 //  databases that can be accessed over this management protocol
 //  connection.
@@ -40,18 +23,9 @@ type TransactionResponse struct {
 //  	"result": [<db-name>,...]
 //   	"error": null
 //   	"id": same "id" as request
+// The databases are the rows of the Database table of the _Server database.
 func (s *ServOVSDB) List_dbs(ctx context.Context, param interface{}) ([]string, error) {
-	// fmt.Printf("List_dbs param %T %v\n", param, param)
-	resp, err := s.dbServer.GetData("ovsdb/_Server/Database/", true)
-	if err != nil {
-		return nil, err
-	}
-	dbs := []string{}
-	for _, kv := range resp.Kvs {
-		slices := strings.Split(string(kv.Key), "/")
-		dbs = append(dbs, slices[len(slices) - 1])
-	}
-	return dbs, nil
+	return s.dbServer.ListDatabases(ctx)
 }
 
 // This operation retrieves a <database-schema> that describes hosted database <db-name>.
@@ -66,7 +40,7 @@ func (s *ServOVSDB) List_dbs(ctx context.Context, param interface{}) ([]string,
 //      "error": "unknown database"
 //      "id": same "id" as request
 func (s *ServOVSDB) Get_schema(ctx context.Context, param interface{}) (interface{}, error) {
-	fmt.Printf("Get_schema prame %T %v\n", param, param)
+	s.logRequest("get_schema", param)
 	var schemaName string
 	switch param.(type) {
 	case string:
@@ -74,21 +48,15 @@ func (s *ServOVSDB) Get_schema(ctx context.Context, param interface{}) (interfac
 	case []string:
 		schemaName = param.([]string)[0]
 	case []interface{}:
+		if len(param.([]interface{})) == 0 {
+			return nil, fmt.Errorf("empty params")
+		}
 		schemaName = fmt.Sprintf("%s", param.([]interface{})[0])
 	default:
 		// probably is a bad idea
 		schemaName = fmt.Sprintf("%s", param)
 	}
-	schema, ok := s.dbServer.schemas[schemaName]
-	if !ok {
-		return nil, fmt.Errorf("unknown database")
-	}
-	var f interface{}
-	err := json.Unmarshal([]byte(schema), &f)
-	if err != nil {
-		return nil, err
-	}
-	return f, nil
+	return s.dbServer.GetSchema(ctx, schemaName)
 }
 
 // This method causes the database server to execute a series of operations in the specified order on a given database.
@@ -100,38 +68,146 @@ func (s *ServOVSDB) Get_schema(ctx context.Context, param interface{}) (interfac
 // Regardless of whether errors occur in the database operations, the response is always a JSON-RPC response with null
 // "error" and a "result" member that is an array with the same number of elements as "params".  Each element of the
 // "result" array corresponds to the same element of the "params" array.
+// All the operations are executed at the same etcd revision. The result object of every successful operation contains
+// an additional "txn-id" member, which is the transaction id of that revision, as reported by "update3" notifications,
+// so clients can correlate the transaction with the monitor updates.
 func (s *ServOVSDB) Transact(ctx context.Context, param []interface{}) (interface{}, error) {
-	for k, v := range param {
-		fmt.Printf("Transact k = %d v= %#v\n", k, v)
+	s.logRequest("transact", param)
+	if len(param) == 0 {
+		return nil, fmt.Errorf("empty params")
+	}
+	dbName, ok := param[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("wrong database name %v", param[0])
+	}
+	cs, err := s.dbServer.beginTransaction(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.dbServer.endTransaction(cs)
+	ctx, span := trace.Start(ctx, "transact")
+	span.SetAttribute("db", dbName)
+	span.SetAttribute("operations", len(param)-1)
+	ctx, reqs := withEtcdRequests(ctx)
+	start := time.Now()
+	result, err := s.transact(ctx, cs, dbName, param)
+	duration := time.Since(start)
+	s.dbServer.metrics.transaction(dbName, param[1:], result, err, duration)
+	s.dbServer.logSlowTransaction(dbName, param[1:], result, err, reqs, duration)
+	span.End(err)
+	return result, err
+}
+
+// transact executes the operations of a transaction of the client session
+func (s *ServOVSDB) transact(ctx context.Context, cs *ClientSession, dbName string, param []interface{}) (interface{},
+	error) {
+	if err := s.dbServer.authorizeTransaction(ctx, dbName, param[1:]); err != nil {
+		return []interface{}{operationError("permission error", err.Error())}, nil
+	}
+	if s.dbServer.relay != nil && !cs.conn.ReadOnly && !readOnly(param[1:]) {
+		// relays serve only the selects, the other operations are executed by the upstream server
+		return s.dbServer.relay.transact(ctx, param)
+	}
+	if err := s.dbServer.breakerError(); err != nil {
+		// etcd is unavailable, the transaction fails without waiting for the timeouts of its requests
+		return []interface{}{operationError("I/O error", err.Error())}, nil
+	}
+	results := []interface{}{}
+	var revision int64
+	for k, v := range param[1:] {
+		s.dbServer.log.WithName("transact").V(6).Info("Operation", "db", dbName, "index", k, "op", v)
+		if ctx.Err() != nil {
+			// the transaction was canceled by a "cancel" request
+			return nil, fmt.Errorf("canceled")
+		}
 		valuesMap, ok := v.(map[string]interface{})
-		if ok {
-			for km, vm := range valuesMap {
-				fmt.Printf("\t  k = %v v= %+v\n", km, vm)
-			}
-			if valuesMap["op"] == "select" {
-				tabel, okt := valuesMap["table"]
-				if !okt {
-					return nil, fmt.Errorf("Table is not specified")
-				}
-				colomns, _ := valuesMap["columns"]
-				fmt.Printf("Columns type %T\n", colomns)
-				resp, err := s.dbServer.GetMarshaled("ovsdb/"+tabel.(string), colomns.([]interface{}))
-				if err != nil {
-					return nil, err
-				}
-				tr := TransactionResponse{Rows: *resp}
-				return tr, nil
+		if !ok {
+			results = append(results, operationError("syntax error", fmt.Sprintf("wrong operation %v", v)))
+			break
+		}
+		if valuesMap["op"] != "select" && cs.conn.ReadOnly {
+			results = append(results, operationError("permission error", "the connection is read-only"))
+			break
+		}
+		if valuesMap["op"] != "select" && s.dbServer.leaderOnly && !s.dbServer.IsLeader(dbName) {
+			results = append(results, operationError("not leader",
+				fmt.Sprintf("the server is not the leader of %s", dbName)))
+			break
+		}
+		if valuesMap["op"] != "select" {
+			results = append(results, operationError("not supported", fmt.Sprintf("operation %v", valuesMap["op"])))
+			break
+		}
+		table, okt := valuesMap["table"].(string)
+		if !okt {
+			results = append(results, operationError("syntax error", "table is not specified"))
+			break
+		}
+		var columns []interface{}
+		if cols, ok := valuesMap["columns"]; ok {
+			if columns, ok = cols.([]interface{}); !ok {
+				results = append(results, operationError("syntax error", fmt.Sprintf("wrong columns %v", cols)))
+				break
 			}
 		}
+		opCtx, span := trace.Start(ctx, "select")
+		span.SetAttribute("table", table)
+		rows, rev, err := s.dbServer.Select(opCtx, dbName, table, columns, revision)
+		span.SetAttribute("rows", len(rows))
+		span.End(err)
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("canceled")
+		}
+		if _, ok := err.(errSelectLimit); ok {
+			results = append(results, operationError("resources exhausted", err.Error()))
+			break
+		}
+		if err != nil && etcdError(err) {
+			// the etcd requests were retried if their errors were transient
+			results = append(results, operationError("I/O error", err.Error()))
+			break
+		}
+		if err != nil {
+			results = append(results, operationError("syntax error", err.Error()))
+			break
+		}
+		revision = rev
+		results = append(results, map[string]interface{}{"rows": rows, "txn-id": revisionToUuid(revision)})
 	}
+	return results, nil
+}
 
-	return "{Transact}", nil
+// readOnly returns true if all the operations are selects
+func readOnly(operations []interface{}) bool {
+	for _, op := range operations {
+		if valuesMap, ok := op.(map[string]interface{}); !ok || valuesMap["op"] != "select" {
+			return false
+		}
+	}
+	return true
 }
 
-func (s *ServOVSDB) Cancel(ctx context.Context, param interface{}) (interface{}, error) {
-	fmt.Printf("Cancel %T, %+v\n", param, param)
+func operationError(err string, details string) map[string]string {
+	return map[string]string{"error": err, "details": details}
+}
 
-	return "{Cancel}", nil
+// The "cancel" method is a JSON-RPC notification, i.e., no matching response is provided. It instructs the database
+// server to immediately complete or cancel the "transact" request whose "id" is the same as the notification's
+// "params" value.
+// "params": [the "id" for an outstanding request]
+// The canceled "transact" request gets the "canceled" error response.
+func (s *ServOVSDB) Cancel(ctx context.Context, param []interface{}) error {
+	s.logRequest("cancel", param)
+	if len(param) != 1 {
+		return fmt.Errorf("wrong number of params %d", len(param))
+	}
+	// the requests are identified by the JSON encoding of their ids
+	id, err := json.Marshal(param[0])
+	if err != nil {
+		return err
+	}
+	jrpc2.CancelRequest(ctx, string(id))
+	return nil
 }
 
 // The "monitor" request enables a client to replicate tables or subsets of tables within an OVSDB database by
@@ -142,93 +218,173 @@ func (s *ServOVSDB) Cancel(ctx context.Context, param interface{}) (interface{},
 //   "result": <table-updates>  If no tables' initial contents are requested, then "result" is an empty object
 //   "error": null
 //   "id": same "id" as request
-func (s *ServOVSDB) Monitor(ctx context.Context, param interface{}) (interface{}, error) {
-	fmt.Printf("Monitor %T, %+v\n", param, param)
+func (s *ServOVSDB) Monitor(ctx context.Context, param []interface{}) (interface{}, error) {
+	s.logRequest("monitor", param)
+	dbName, requests, err := monitorParams(param)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.dbServer.authorize(ctx, dbName, ACL_MONITOR); err != nil {
+		return nil, err
+	}
+	return s.dbServer.AddMonitor(ctx, monitorV1, dbName, param[1], requests)
+}
 
-	return ovsjson.EmptyStruct{}, nil
+// monitorParams parses the [<db-name>, <json-value>, <monitor-requests>] params of the monitor methods
+func monitorParams(param []interface{}) (string, map[string]interface{}, error) {
+	if len(param) != 3 {
+		return "", nil, fmt.Errorf("wrong number of params %d", len(param))
+	}
+	dbName, ok := param[0].(string)
+	if !ok {
+		return "", nil, fmt.Errorf("wrong database name %v", param[0])
+	}
+	requests, ok := param[2].(map[string]interface{})
+	if !ok {
+		return "", nil, fmt.Errorf("wrong monitor requests %v", param[2])
+	}
+	return dbName, requests, nil
 }
 
 func (s *ServOVSDB) Update(ctx context.Context, param interface{}) (interface{}, error) {
-	fmt.Printf("Update %T, %+v\n", param, param)
+	s.logRequest("update", param)
 
 	return "{Update}", nil
 }
 
-func (s *ServOVSDB) Monitor_cancel(ctx context.Context, param interface{}) (interface{}, error) {
-	fmt.Printf("Monitor_cancel %T, %+v\n", param, param)
-
-	return "{Monitor_cancel}", nil
+// The "monitor_cancel" request cancels a previously issued monitor request.
+// "params": [<json-value>]
+// The <json-value> in "params" matches the <json-value> in "params" for the ongoing "monitor" request that is to be
+// canceled. No more "update" messages will be sent for this table monitor.
+// The response to this request has the following members:
+//  "result": {}
+//  "error": null
+// If "params" does not match the <json-value> of an ongoing monitor request, the response has "error": "unknown monitor"
+func (s *ServOVSDB) Monitor_cancel(ctx context.Context, param []interface{}) (interface{}, error) {
+	s.logRequest("monitor_cancel", param)
+	if len(param) != 1 {
+		return nil, fmt.Errorf("wrong number of params %d", len(param))
+	}
+	if err := s.dbServer.CancelMonitor(ctx, param[0]); err != nil {
+		return nil, err
+	}
+	return ovsjson.EmptyStruct{}, nil
 }
 
 // The database server supports an arbitrary number of locks, each of which is identified by a client-defined ID.
 // At any given time, each lock may have at most one owner.
-// The database will assign the client ownership of the lock as soon as it becomes available.  When multiple clients
-// request the same lock, they will receive it in first-come, first-served order. The request completes and sends
-// a response quickly, without waiting. The "locked" and "stolen" notifications report asynchronous changes to ownership.
+// The database will assign the client ownership of the lock as soon as it becomes available.  Unlike ovsdb-server,
+// when multiple clients request the same lock, they don't receive it in first-come, first-served order, the waiting
+// clients race to acquire it when it's released. The request completes and sends a response quickly, without waiting. The "locked" and "stolen" notifications report asynchronous changes to ownership.
 // "params": [<id>]
 // Returns "result": {"locked": boolean}
 func (s *ServOVSDB) Lock(ctx context.Context, param interface{}) (interface{}, error) {
-	fmt.Printf("Lock %T, %+v\n", param, param)
-	//defer notification(ctx)
-	var id string
-	// param is []interface{}, but just in case ...
-	switch param.(type) {
-	case []interface{}:
-		intArray := param.([]interface{})
-		if len(intArray) == 0 {
-			// Error
-			fmt.Printf("Empty params")
-			return []interface{}{"locked", false}, nil
-		} else {
-			id = fmt.Sprintf("%s", intArray[0])
-		}
-	case string:
-		id = param.(string)
-	case interface{}:
-		id = fmt.Sprintf("%s", param)
+	s.logRequest("lock", param)
+	id, err := lockID(param)
+	if err != nil {
+		return nil, err
 	}
-	locked, err := s.dbServer.Lock(ctx, id)
+	locked, err := s.dbServer.Lock(ctx, id, false)
 	if err != nil {
-		// TODO should we return error ?
-		fmt.Printf("Lock returned error %v\n", err)
+		return nil, err
 	}
-	return []interface{}{"locked", locked}, nil
+	return map[string]bool{"locked": locked}, nil
 }
 
-func notification(ctx context.Context) {
-	go func() {
-		for {
-			time.Sleep(3 * time.Second)
-			if err := jrpc2.PushNotify(ctx, "pushback", []string{"hello, friend"}); err != nil {
-				fmt.Printf("notification %v\n", err)
-				return
+// The database server immediately assigns ownership of the lock to the client that sends the request. The previous
+// owner, if any, loses the lock and receives a "stolen" notification.
+// "params": [<id>]
+// Returns "result": {"locked": true}
+func (s *ServOVSDB) Steal(ctx context.Context, param interface{}) (interface{}, error) {
+	s.logRequest("steal", param)
+	id, err := lockID(param)
+	if err != nil {
+		return nil, err
+	}
+	locked, err := s.dbServer.Lock(ctx, id, true)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]bool{"locked": locked}, nil
+}
 
-			}
-		}
-	}()
+// An extension of ovsdb-etcd that inserts an ephemeral row, which is deleted when the client connection is closed or
+// the server is lost. The table has to be designated as ephemeral by the server configuration.
+// "params": [<db-name>, <table>, <row>]
+// Returns "result": {"uuid": <uuid>}
+func (s *ServOVSDB) Insert_ephemeral(ctx context.Context, param []interface{}) (interface{}, error) {
+	s.logRequest("insert_ephemeral", param)
+	if len(param) != 3 {
+		return nil, fmt.Errorf("wrong params %v", param)
+	}
+	dbName, ok1 := param[0].(string)
+	table, ok2 := param[1].(string)
+	r, ok3 := param[2].(map[string]interface{})
+	if !ok1 || !ok2 || !ok3 {
+		return nil, fmt.Errorf("wrong params %v", param)
+	}
+	rowUuid, err := s.dbServer.InsertEphemeral(ctx, dbName, table, r)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"uuid": ovsjson.Uuid(rowUuid)}, nil
+}
+
+// An extension of ovsdb-etcd that deletes an ephemeral row that was inserted by the client.
+// "params": [<db-name>, <table>, <uuid>]
+// Returns "result": {}
+func (s *ServOVSDB) Delete_ephemeral(ctx context.Context, param []interface{}) (interface{}, error) {
+	s.logRequest("delete_ephemeral", param)
+	if len(param) != 3 {
+		return nil, fmt.Errorf("wrong params %v", param)
+	}
+	dbName, ok1 := param[0].(string)
+	table, ok2 := param[1].(string)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("wrong params %v", param)
+	}
+	rowUuid, ok := atomValue(param[2]).(string)
+	if !ok {
+		return nil, fmt.Errorf("wrong uuid %v", param[2])
+	}
+	if err := s.dbServer.DeleteEphemeral(ctx, dbName, table, rowUuid); err != nil {
+		return nil, err
+	}
+	return ovsjson.EmptyStruct{}, nil
 }
 
+// The client must have previously requested the lock with "lock" or "steal". The server releases the lock if the
+// client owns it, otherwise the pending lock request is canceled.
+// "params": [<id>]
+// Returns "result": {}
 func (s *ServOVSDB) Unlock(ctx context.Context, param interface{}) (interface{}, error) {
-	fmt.Printf("Unlock %T, %+v\n", param, param)
-	var id string
+	s.logRequest("unlock", param)
+	id, err := lockID(param)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.dbServer.Unlock(ctx, id); err != nil {
+		return nil, err
+	}
+	return ovsjson.EmptyStruct{}, nil
+}
+
+func lockID(param interface{}) (string, error) {
 	// param is []interface{}, but just in case ...
 	switch param.(type) {
 	case []interface{}:
 		intArray := param.([]interface{})
 		if len(intArray) == 0 {
-			// Error
-			fmt.Printf("Empty params")
-			return []interface{}{"locked", false}, nil
-		} else {
-			id = fmt.Sprintf("%s", intArray[0])
+			return "", fmt.Errorf("empty params")
 		}
+		return fmt.Sprintf("%s", intArray[0]), nil
 	case string:
-		id = param.(string)
-	case interface{}:
-		id = fmt.Sprintf("%s", param)
+		return param.(string), nil
+	case nil:
+		return "", fmt.Errorf("empty params")
+	default:
+		return fmt.Sprintf("%s", param), nil
 	}
-	_ = s.dbServer.Unlock(ctx, id)
-	return "{Unlock}", nil
 }
 
 // The monitor_cond request enables a client to replicate subsets of tables within an OVSDB database by requesting
@@ -262,47 +418,42 @@ func (s *ServOVSDB) Unlock(ctx context.Context, param interface{}) (interface{},
 //  "error": null
 //  "id": same "id" as request
 func (s *ServOVSDB) Monitor_cond(ctx context.Context, param []interface{}) (interface{}, error) {
-	fmt.Printf("Monitor_cond %T %+v\n", param, param)
-
-	resp, err := s.dbServer.GetData("ovsdb/" + param[0].(string), false)
+	s.logRequest("monitor_cond", param)
+	dbName, requests, err := monitorParams(param)
 	if err != nil {
 		return nil, err
 	}
-
-	databases := Databases{Database: map[string]ovsjson.Initial{}}
-	for _, v := range resp.Kvs {
-		keys := strings.Split(string(v.Key), "/")
-		in, ok := databases.Database[keys[3]]
-		var id InitialData
-		if !ok {
-			in = ovsjson.Initial{}
-			databases.Database[keys[3]] = in
-		}
-		if in.Initial == nil {
-			in.Initial = InitialData{}
-		}
-		id = in.Initial.(InitialData)
-		switch keys[5] {
-		case "name":
-			id.Name = string(v.Value)
-		case "connected":
-			id.Connected, _ = strconv.ParseBool(string(v.Value))
-		case "leader":
-			id.Leader, _ = strconv.ParseBool(string(v.Value))
-		case "model":
-			id.Model = string(v.Value)
-		case "schema":
-			id.Schema = string(v.Value)
-		}
-		databases.Database[keys[3]] = ovsjson.Initial{Initial: id}
+	if err := s.dbServer.authorize(ctx, dbName, ACL_MONITOR); err != nil {
+		return nil, err
 	}
-	return databases, nil
+	return s.dbServer.AddMonitor(ctx, monitorV2, dbName, param[1], requests)
 }
 
-func (s *ServOVSDB) Monitor_cond_change(ctx context.Context, param interface{}) (interface{}, error) {
-	fmt.Printf("Monitor_cond_change %T, %+v\n", param, param)
-
-	return "{Monitor_cond_change}", nil
+// Enables a client to change an existing "monitor_cond" replication of the contents of an OVSDB database.
+// "params": [<json-value>, <json-value>, <monitor-cond-update-requests>]
+// The first <json-value> parameter should have the same value as the <json-value> of the monitor_cond request, and
+// the second one is used to match subsequent update notifications to the changed monitor.
+// The <monitor-cond-update-requests> object maps the name of the table to an array of <monitor-cond-update-request>,
+// which is an object with a "where" member, the new conditions of the table. The conditions of the tables that don't
+// appear in the object are not changed.
+// The response object has the following members:
+//  "result": {}
+//  "error": null
+//  "id": same "id" as request
+// The rows that start or stop matching the new conditions are sent in update notifications.
+func (s *ServOVSDB) Monitor_cond_change(ctx context.Context, param []interface{}) (interface{}, error) {
+	s.logRequest("monitor_cond_change", param)
+	if len(param) != 3 {
+		return nil, fmt.Errorf("wrong number of params %d", len(param))
+	}
+	requests, ok := param[2].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("wrong monitor requests %v", param[2])
+	}
+	if err := s.dbServer.ChangeMonitor(ctx, param[0], param[1], requests); err != nil {
+		return nil, err
+	}
+	return ovsjson.EmptyStruct{}, nil
 }
 
 // Enables a client to request changes that happened after a specific transaction id. A client can use this feature
@@ -328,20 +479,43 @@ func (s *ServOVSDB) Monitor_cond_change(ctx context.Context, param interface{})
 //  <table-updates2> of this response, so that client can keep tracking. If there is no change involved in this
 // response, it is the same as the <last-txn-id> in the request if <found> is true, or zero uuid if <found> is false.
 // If the server does not support transaction uuid, it will be zero uuid as well.
-func (s *ServOVSDB) Monitor_cond_since(ctx context.Context, param interface{}) (interface{}, error) {
-	fmt.Printf("Monitor_cond_since %T, %+v\n", param, param)
-
-	// TODO implement
-	return []interface{}{false, ovsjson.ZERO_UUID, ovsjson.EmptyStruct{}}, nil
+//
+// The transaction ids are the etcd revisions, represented as UUIDs. The subsequent changes are sent in "update3"
+// notifications:
+// "params": [<json-value>, <last-txn-id>, <table-updates2>]
+func (s *ServOVSDB) Monitor_cond_since(ctx context.Context, param []interface{}) (interface{}, error) {
+	s.logRequest("monitor_cond_since", param)
+	if len(param) != 4 {
+		return nil, fmt.Errorf("wrong number of params %d", len(param))
+	}
+	dbName, requests, err := monitorParams(param[:3])
+	if err != nil {
+		return nil, err
+	}
+	if err := s.dbServer.authorize(ctx, dbName, ACL_MONITOR); err != nil {
+		return nil, err
+	}
+	lastTxnID, ok := param[3].(string)
+	if !ok {
+		return nil, fmt.Errorf("wrong last transaction id %v", param[3])
+	}
+	// unknown transaction ids, e.g. the zero uuid, are not found
+	lastRevision, _ := uuidToRevision(lastTxnID)
+	found, revision, updates, err := s.dbServer.AddMonitorSince(ctx, dbName, param[1], requests, lastRevision)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{found, revisionToUuid(revision), updates}, nil
 }
 
 // A new RPC method added in Open vSwitch version 2.7.
 // "params": null
 // "result": "<server_id>"
 // <server_id> is JSON string that contains a UUID that uniquely identifies the running OVSDB server process.
-// A fresh UUID is generated when the process restarts.
+// The UUID is generated when the server starts for the first time, and it's stored in etcd, so it's kept when the
+// server restarts.
 func (s *ServOVSDB) Get_server_id(ctx context.Context, param interface{}) string {
-	fmt.Printf("Get_server_id %+v\n", param)
+	s.logRequest("get_server_id", param)
 	return s.dbServer.uuid
 }
 
@@ -358,12 +532,22 @@ func (s *ServOVSDB) Get_server_id(ctx context.Context, param interface{}) string
 // and false restores the default behavior. The reply is always the same:
 // "result": {}
 func (s *ServOVSDB) Set_db_change_aware(ctx context.Context, param interface{}) interface{} {
-	fmt.Printf("Set_db_change_aware %+v\n", param)
+	s.logRequest("set_db_change_aware", param)
+	aware := false
+	switch p := param.(type) {
+	case []interface{}:
+		if len(p) > 0 {
+			aware, _ = p[0].(bool)
+		}
+	case bool:
+		aware = p
+	}
+	s.dbServer.SetDbChangeAware(ctx, aware)
 	return ovsjson.EmptyStruct{}
 }
 
 func (s *ServOVSDB) Convert(ctx context.Context, param interface{}) (interface{}, error) {
-	fmt.Printf("Convert %+v\n", param)
+	s.logRequest("convert", param)
 	return "{Convert}", nil
 }
 
@@ -374,10 +558,15 @@ func (s *ServOVSDB) Convert(ctx context.Context, param interface{}) (interface{}
 //     	"error": null
 //		"id": the request "id" member
 func (s *ServOVSDB) Echo(ctx context.Context, param interface{}) interface{}{
-	fmt.Printf("Echo param %T %v\n", param, param)
+	s.logRequest("echo", param)
 	return param
 }
 
+// logRequest logs the request of a client by the "rpc" logger
+func (s *ServOVSDB) logRequest(method string, param interface{}) {
+	s.dbServer.log.WithName("rpc").V(6).Info("Request", "method", method, "params", param)
+}
+
 func NewService(dbServer *DBServer) *ServOVSDB {
 	return &ServOVSDB{dbServer: dbServer}
 }
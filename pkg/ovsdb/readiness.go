@@ -0,0 +1,58 @@
+package ovsdb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// WaitForEtcd reads the etcd keyspace until it succeeds, with an exponential backoff between the attempts, from
+// ETCD_RETRY_MIN_BACKOFF to ETCD_RETRY_MAX_BACKOFF. It returns an error if etcd isn't reachable when the context is
+// done.
+func (con *DBServer) WaitForEtcd(ctx context.Context) error {
+	backoff := ETCD_RETRY_MIN_BACKOFF
+	for {
+		reqCtx, cancel := context.WithTimeout(ctx, con.requestTimeout)
+		_, err := con.cli.Get(reqCtx, SCHEMAS_PREFIX, clientv3.WithPrefix(), clientv3.WithCountOnly())
+		cancel()
+		if err == nil {
+			return nil
+		}
+		con.log.WithName("etcd").Info("Waiting for etcd, the keyspace isn't reachable", "error", err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("etcd isn't reachable: %v", err)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > ETCD_RETRY_MAX_BACKOFF {
+			backoff = ETCD_RETRY_MAX_BACKOFF
+		}
+	}
+}
+
+// SetReady marks whether the server is ready to serve clients, after its schemas were loaded from etcd. The
+// "connected" column of the _Server Database rows that the server serves is the readiness of the server.
+func (con *DBServer) SetReady(ready bool) {
+	con.leadersMu.Lock()
+	con.ready = ready
+	con.leadersMu.Unlock()
+	con.cachesMu.Lock()
+	c, ok := con.caches["_Server"]
+	con.cachesMu.Unlock()
+	if ok {
+		for dbName := range con.databaseSchemas() {
+			c.setColumn("Database", dbName, "connected", ready)
+		}
+	}
+}
+
+// Ready returns true if the server is ready, and etcd is available by the circuit breaker
+func (con *DBServer) Ready() bool {
+	con.leadersMu.Lock()
+	ready := con.ready
+	con.leadersMu.Unlock()
+	return ready && con.breakerError() == nil
+}
@@ -0,0 +1,309 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/creachadair/jrpc2/channel"
+
+	ovsjson "github.com/ibm/ovsdb-etcd/pkg/json"
+)
+
+// the interval between the attempts to connect to the upstream server
+const RELAY_RECONNECT_INTERVAL = time.Second
+
+// A relay replicates the databases of an upstream ovsdb-etcd or ovsdb-server, by monitoring all their tables. The
+// monitors and the selects of the relay clients are served from the relay caches, and the transactions that modify
+// the databases are forwarded to the upstream server.
+type relay struct {
+	con  *DBServer
+	dial func() (channel.Channel, error)
+	// mu protects the fields below, they are nil while the relay is not connected to the upstream server
+	mu     sync.Mutex
+	up     *upstream
+	caches map[string]*dbCache
+}
+
+// StartRelay makes the server a relay of the upstream server that dial connects to. The relay replicates all the
+// databases that were added by AddSchema, and it reconnects to the upstream server when the connection is lost, until
+// the context is canceled. It should be called before the server accepts clients.
+func (con *DBServer) StartRelay(ctx context.Context, dial func() (channel.Channel, error)) {
+	con.relay = &relay{con: con, dial: dial}
+	go con.relay.run(ctx)
+}
+
+func (r *relay) run(ctx context.Context) {
+	for {
+		if err := r.connect(ctx); err != nil && ctx.Err() == nil {
+			r.con.log.WithName("relay").Info("Upstream connection failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(RELAY_RECONNECT_INTERVAL):
+		}
+	}
+}
+
+// connect monitors the databases of the upstream server, and relays them until the connection is lost
+func (r *relay) connect(ctx context.Context) error {
+	ch, err := r.dial()
+	if err != nil {
+		return err
+	}
+	// the revisions of the relay caches start at the current time, so the transaction ids of different connections
+	// don't overlap
+	revision := time.Now().UnixNano() / int64(time.Millisecond)
+	caches := map[string]*dbCache{}
+	for dbName, dbSchema := range r.con.databaseSchemas() {
+		c := newDBCache(r.con.log, dbName, dbSchema)
+		c.revision = revision
+		c.historySize = r.con.monitorHistorySize
+		caches[dbName] = c
+	}
+	log := r.con.log.WithName("relay")
+	up := newUpstream(log, ch, func(method string, params json.RawMessage) {
+		if method != "update2" {
+			log.V(5).Info("Ignoring a notification", "method", method)
+			return
+		}
+		var p []json.RawMessage
+		var dbName string
+		if err := json.Unmarshal(params, &p); err != nil || len(p) != 2 || json.Unmarshal(p[0], &dbName) != nil {
+			log.Error(err, "Wrong update2 params", "params", string(params))
+			return
+		}
+		c, ok := caches[dbName]
+		if !ok {
+			log.Info("Update2 notification of an unknown database", "db", dbName)
+			return
+		}
+		if _, err := c.applyUpdates2(p[1]); err != nil {
+			log.Error(err, "Update2 notification failed", "db", dbName)
+		}
+	})
+	defer func() {
+		up.close()
+		r.mu.Lock()
+		r.up, r.caches = nil, nil
+		r.mu.Unlock()
+		for _, c := range caches {
+			r.con.disconnectMonitors(c)
+		}
+	}()
+	for dbName, c := range caches {
+		requests := map[string]interface{}{}
+		for table := range c.dbSchema.Tables {
+			requests[table] = map[string]interface{}{}
+		}
+		// the initial rows are applied by the upstream reader, before the notifications that follow them
+		c := c
+		err := up.call(ctx, "monitor_cond", []interface{}{dbName, dbName, requests}, func(result json.RawMessage) error {
+			_, err := c.applyUpdates2(result)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("monitor of %s: %v", dbName, err)
+		}
+	}
+	log.Info("Connected to the upstream server")
+	r.mu.Lock()
+	r.up, r.caches = up, caches
+	r.mu.Unlock()
+	select {
+	case <-up.done:
+		return up.err
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// cache returns the relay cache of the database
+func (r *relay) cache(dbName string) (*dbCache, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.caches == nil {
+		return nil, fmt.Errorf("not connected to the upstream server")
+	}
+	c, ok := r.caches[dbName]
+	if !ok {
+		return nil, fmt.Errorf("unknown database")
+	}
+	return c, nil
+}
+
+// databases returns the sorted names of the relayed databases
+func (r *relay) databases() []string {
+	dbs := []string{}
+	for dbName := range r.con.databaseSchemas() {
+		dbs = append(dbs, dbName)
+	}
+	sort.Strings(dbs)
+	return dbs
+}
+
+// transact forwards the transaction to the upstream server, and returns its result as is
+func (r *relay) transact(ctx context.Context, params []interface{}) (interface{}, error) {
+	r.mu.Lock()
+	up := r.up
+	r.mu.Unlock()
+	if up == nil {
+		return nil, fmt.Errorf("not connected to the upstream server")
+	}
+	var result json.RawMessage
+	err := up.call(ctx, "transact", params, func(res json.RawMessage) error {
+		result = res
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// selectRows returns the current rows of the table from the relay cache. The relay caches don't have the row
+// versions, so "_version" is not returned.
+func (r *relay) selectRows(dbName, table string, columnsMap map[string]bool) ([]map[string]interface{}, int64,
+	error) {
+	c, err := r.cache(dbName)
+	if err != nil {
+		return nil, 0, err
+	}
+	rows, revision, _ := c.selectRows(table, columnsMap, false, 0)
+	return rows, revision, nil
+}
+
+// applyUpdates2 applies <table-updates2> of the upstream server to the cache as a single revision, notifies the
+// monitors, and returns the changes
+func (c *dbCache) applyUpdates2(data json.RawMessage) (tablesChanges, error) {
+	var updates map[string]map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(data, &updates); err != nil {
+		return nil, err
+	}
+	changes := tablesChanges{}
+	c.mu.Lock()
+	for table, tableUpdates := range updates {
+		tableSchema, ok := c.dbSchema.Tables[table]
+		if !ok {
+			c.log.V(5).Info("Unknown table", "table", table)
+			continue
+		}
+		tableRows := c.rows[table]
+		tableChanges := map[string]*rowChange{}
+		changes[table] = tableChanges
+		for rowUuid, rowUpdate := range tableUpdates {
+			old := tableRows[rowUuid]
+			var current row
+			for kind, value := range rowUpdate {
+				var r row
+				if err := json.Unmarshal(value, &r); err != nil {
+					c.mu.Unlock()
+					return nil, fmt.Errorf("%s of %s/%s: %v", kind, table, rowUuid, err)
+				}
+				switch kind {
+				case "initial", "insert":
+					current = row{}
+					for colName, value := range r {
+						if _, ok := tableSchema.Columns[colName]; ok {
+							current[colName] = value
+						}
+					}
+				case "modify":
+					current = old.copy()
+					if current == nil {
+						current = row{}
+					}
+					for colName, diff := range r {
+						colSchema, ok := tableSchema.Columns[colName]
+						if !ok {
+							continue
+						}
+						value, err := applyDiff(&colSchema.Type, current[colName], diff)
+						if err != nil {
+							c.mu.Unlock()
+							return nil, fmt.Errorf("column %s of %s/%s: %v", colName, table, rowUuid, err)
+						}
+						current[colName] = value
+					}
+				case "delete":
+					current = nil
+				default:
+					c.mu.Unlock()
+					return nil, fmt.Errorf("wrong row update %s of %s/%s", kind, table, rowUuid)
+				}
+			}
+			if current == nil {
+				delete(tableRows, rowUuid)
+			} else {
+				tableRows[rowUuid] = current
+			}
+			tableChanges[rowUuid] = &rowChange{old: old, new: current}
+		}
+	}
+	revision := c.revision + 1
+	monitors := c.record(revision, changes)
+	c.mu.Unlock()
+	for _, m := range monitors {
+		m.update(revision, changes)
+	}
+	return changes, nil
+}
+
+// applyDiff applies a column diff of a "modify" <row-update2> to the column value. Atomic columns are replaced by
+// the diff, the elements of a set diff are added to the set or removed from it, and the pairs of a map diff are added
+// to the map, remove a pair with the same value, or replace the value of their key.
+func applyDiff(colType *ovsjson.ColumnType, value, diff interface{}) (interface{}, error) {
+	if !colType.IsMap() && !colType.IsSet() {
+		return diff, nil
+	}
+	if value == nil {
+		value = defaultValue(colType)
+	}
+	oldValue, err := normalizeValue(value)
+	if err != nil {
+		return nil, err
+	}
+	diffValue, err := normalizeValue(diff)
+	if err != nil {
+		return nil, err
+	}
+	if colType.IsMap() {
+		pairs := wireMapPairs(oldValue)
+		for key, pair := range wireMapPairs(diffValue) {
+			if old, ok := pairs[key]; ok && contains([]interface{}{old[1]}, pair[1]) {
+				delete(pairs, key)
+			} else {
+				pairs[key] = pair
+			}
+		}
+		keys := make([]string, 0, len(pairs))
+		for key := range pairs {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		elements := make([]interface{}, 0, len(keys))
+		for _, key := range keys {
+			elements = append(elements, pairs[key])
+		}
+		return []interface{}{"map", elements}, nil
+	}
+	elements := wireSetElements(oldValue)
+	for _, e := range wireSetElements(diffValue) {
+		removed := false
+		for i, old := range elements {
+			if contains([]interface{}{old}, e) {
+				elements = append(elements[:i:i], elements[i+1:]...)
+				removed = true
+				break
+			}
+		}
+		if !removed {
+			elements = append(elements, e)
+		}
+	}
+	return []interface{}{"set", elements}, nil
+}
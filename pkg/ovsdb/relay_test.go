@@ -0,0 +1,55 @@
+package ovsdb
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	ovsjson "github.com/ibm/ovsdb-etcd/pkg/json"
+)
+
+func testApplyDiff(t *testing.T, colType ovsjson.ColumnType, value interface{}, diff string, expected string) {
+	var d interface{}
+	assert.Nil(t, json.Unmarshal([]byte(diff), &d))
+	newValue, err := applyDiff(&colType, value, d)
+	assert.Nil(t, err)
+	b, err := json.Marshal(newValue)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, string(b))
+}
+
+func TestApplyDiffAtoms(t *testing.T) {
+	testApplyDiff(t, ovsjson.ColumnType{Key: integerType, Min: 1, Max: 1}, int64(1), `2`, `2`)
+	testApplyDiff(t, ovsjson.ColumnType{Key: stringType, Min: 1, Max: 1}, nil, `"a"`, `"a"`)
+}
+
+func TestApplyDiffSets(t *testing.T) {
+	setType := ovsjson.ColumnType{Key: stringType, Min: 0, Max: ovsjson.Unlimited}
+	testApplyDiff(t, setType, ovsjson.Set{"a", "b"}, `["set",["a","c"]]`, `["set",["b","c"]]`)
+	testApplyDiff(t, setType, "a", `["set",["b"]]`, `["set",["a","b"]]`)
+	testApplyDiff(t, setType, nil, `"a"`, `["set",["a"]]`)
+	optionalType := ovsjson.ColumnType{Key: uuidType, Min: 0, Max: 1}
+	testApplyDiff(t, optionalType, ovsjson.Uuid("25f2e69e-4bac-4529-9082-9f94da060cf1"),
+		`["set",[["uuid","25f2e69e-4bac-4529-9082-9f94da060cf1"]]]`, `["set",[]]`)
+}
+
+func TestApplyDiffMaps(t *testing.T) {
+	mapType := ovsjson.ColumnType{Key: stringType, Value: stringType, Min: 0, Max: ovsjson.Unlimited}
+	testApplyDiff(t, mapType, ovsjson.Map{"a": "1"}, `["map",[["b","2"]]]`, `["map",[["a","1"],["b","2"]]]`)
+	testApplyDiff(t, mapType, ovsjson.Map{"a": "1"}, `["map",[["a","2"]]]`, `["map",[["a","2"]]]`)
+	testApplyDiff(t, mapType, ovsjson.Map{"a": "1", "b": "2"}, `["map",[["a","1"]]]`, `["map",[["b","2"]]]`)
+}
+
+// the diffs of diffValue are applied by applyDiff
+func TestApplyDiffValue(t *testing.T) {
+	mapType := ovsjson.ColumnType{Key: stringType, Value: stringType, Min: 0, Max: ovsjson.Unlimited}
+	oldValue, newValue := ovsjson.Map{"a": "1", "b": "2"}, ovsjson.Map{"b": "3", "c": "4"}
+	diff, err := diffValue(&mapType, oldValue, newValue)
+	assert.Nil(t, err)
+	value, err := applyDiff(&mapType, oldValue, diff)
+	assert.Nil(t, err)
+	expected, _ := normalizeValue(newValue)
+	actual, _ := normalizeValue(value)
+	assert.Equal(t, mapPairs(expected), mapPairs(actual))
+}
@@ -0,0 +1,91 @@
+package ovsdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/ibm/ovsdb-etcd/pkg/trace"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// The retries of etcd requests that fail by transient errors, with an exponential backoff
+const (
+	ETCD_RETRIES           = 5
+	ETCD_RETRY_MIN_BACKOFF = 50 * time.Millisecond
+	ETCD_RETRY_MAX_BACKOFF = 2 * time.Second
+)
+
+// retryable returns true for transient etcd errors, e.g. of leader changes, members without a leader, unavailable
+// members or too many requests. The reads of future revisions are retried too, a serializable read may be served by
+// a member that didn't apply the revision yet.
+func retryable(err error) bool {
+	if err == rpctypes.ErrFutureRev {
+		return true
+	}
+	var code codes.Code
+	if e, ok := err.(rpctypes.EtcdError); ok {
+		code = e.Code()
+	} else if s, ok := status.FromError(err); ok && s != nil {
+		code = s.Code()
+	} else {
+		return false
+	}
+	return code == codes.Unavailable || code == codes.ResourceExhausted
+}
+
+// etcdError returns true for the errors of etcd requests
+func etcdError(err error) bool {
+	if _, ok := err.(rpctypes.EtcdError); ok {
+		return true
+	}
+	if _, ok := status.FromError(err); ok {
+		return true
+	}
+	if _, ok := err.(errBreakerOpen); ok {
+		return true
+	}
+	return err == context.DeadlineExceeded
+}
+
+// retry runs the etcd request with a timeout, and runs it again with an exponential backoff if it fails by a
+// retryable error, until ETCD_RETRIES retries fail or the context is done. It returns the error of the last attempt.
+// The request fails immediately while the circuit breaker is open. The name is the request type of the metrics. Every
+// attempt is traced by its own span, whose trace context is sent to etcd.
+func (con *DBServer) retry(ctx context.Context, name string, request func(ctx context.Context) error) error {
+	backoff := ETCD_RETRY_MIN_BACKOFF
+	for i := 0; ; i++ {
+		if err := con.breakerError(); err != nil {
+			return err
+		}
+		reqCtx, cancel := context.WithTimeout(ctx, con.requestTimeout)
+		reqCtx, span := trace.Start(reqCtx, "etcd "+name)
+		if span != nil {
+			span.SetAttribute("attempt", i+1)
+			reqCtx = metadata.AppendToOutgoingContext(reqCtx, "traceparent", span.Traceparent())
+		}
+		start := time.Now()
+		err := request(reqCtx)
+		con.metrics.etcdLatency.Observe(time.Since(start).Seconds(), name)
+		recordEtcdRequest(ctx, name, time.Since(start))
+		span.End(err)
+		cancel()
+		con.breakerResult(ctx, err)
+		if err == nil || i == ETCD_RETRIES || !retryable(err) {
+			return err
+		}
+		con.log.WithName("etcd").V(5).Info("Request failed, retrying", "request", name, "error", err, "backoff", backoff)
+		con.metrics.etcdRetries.Inc(name)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > ETCD_RETRY_MAX_BACKOFF {
+			backoff = ETCD_RETRY_MAX_BACKOFF
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package ovsdb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRetryable(t *testing.T) {
+	assert.True(t, retryable(rpctypes.ErrNoLeader))
+	assert.True(t, retryable(rpctypes.ErrLeaderChanged))
+	assert.True(t, retryable(rpctypes.ErrTooManyRequests))
+	assert.True(t, retryable(status.Error(codes.Unavailable, "connection refused")))
+	assert.True(t, retryable(rpctypes.ErrFutureRev))
+	assert.False(t, retryable(rpctypes.ErrCompacted))
+	assert.False(t, retryable(context.DeadlineExceeded))
+	assert.False(t, retryable(fmt.Errorf("unknown table")))
+
+	assert.True(t, etcdError(rpctypes.ErrCompacted))
+	assert.True(t, etcdError(context.DeadlineExceeded))
+	assert.False(t, etcdError(fmt.Errorf("unknown table")))
+}
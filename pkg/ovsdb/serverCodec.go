@@ -7,7 +7,6 @@ package ovsdb
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"net/rpc"
 	"strings"
@@ -114,19 +113,10 @@ func (c *serverCodec) ReadRequestBody(x interface{}) error {
 	// we need it to support positional JRPC parameters.
 	switch x.(type) {
 	case *[]interface{}:
-		e := json.Unmarshal(*c.req.Params, x)
-		if e != nil {
-			fmt.Printf("ReadRequestBody slice Error %v\n", e)
-			return e
-		}
+		return json.Unmarshal(*c.req.Params, x)
 	default:
-		e := json.Unmarshal(*c.req.Params, &params)
-		if e != nil {
-			fmt.Printf("ReadRequestBody Error %v\n", e)
-			return e
-		}
+		return json.Unmarshal(*c.req.Params, &params)
 	}
-	return nil
 }
 
 var null = json.RawMessage([]byte("null"))
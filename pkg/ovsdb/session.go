@@ -0,0 +1,190 @@
+package ovsdb
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/channel"
+)
+
+// ClientIdentity is the identity of a client that was authenticated by its SSL certificate
+type ClientIdentity struct {
+	CommonName string
+	// the subject alternative names of the certificate: DNS names, email addresses, IP addresses and URIs
+	AltNames []string
+}
+
+// PeerCredentials are the credentials of the peer process of a UNIX socket connection
+type PeerCredentials struct {
+	PID int
+	UID int
+	GID int
+}
+
+// ClientConnection describes the connection of a client
+type ClientConnection struct {
+	// nil if the client was not authenticated
+	Identity *ClientIdentity
+	// the remote address of TCP and SSL connections
+	IP net.IP
+	// the remote address of the connection, as it's reported by the listener
+	Address string
+	// the peer process of UNIX socket connections, nil if it's unknown
+	Peer *PeerCredentials
+	// set if the client connected to a read-only listener, then it may only read and monitor the databases
+	ReadOnly bool
+	// the number of the connection among the connections of the server, set by SentBytesCounter
+	number uint64
+}
+
+// name identifies the client connection in the metrics, by the peer process of UNIX socket connections or by the remote
+// address
+func (c *ClientConnection) name() string {
+	if c.Peer != nil {
+		return fmt.Sprintf("unix:pid=%d", c.Peer.PID)
+	}
+	if len(c.Address) > 0 {
+		return c.Address
+	}
+	return "unknown"
+}
+
+// metricsName identifies the client connection in the per-client metrics. The name of the connection is not unique,
+// e.g. clients of the same process share it, so it's combined with the number of the connection, and the metrics of
+// the connection are removed when it's closed.
+func (c *ClientConnection) metricsName() string {
+	return fmt.Sprintf("%s#%d", c.name(), c.number)
+}
+
+// A ClientSession is the state of a single client connection: its monitors, its OVSDB locks and its in-flight
+// transactions. When the connection is closed, the session is torn down by a single path, so nothing is left behind,
+// and the locks of the client are released promptly, so other clients can take them over.
+type ClientSession struct {
+	srv *jrpc2.Server
+	// set when the session is created, before the server of the connection starts, and not changed after it
+	conn ClientConnection
+	// set if the session was registered by AddClient, otherwise the connection is unknown
+	registered bool
+	// the fields below are protected by DBServer.mu
+	dbChangeAware bool
+	// the monitors of the client, by the JSON encoding of their ids
+	monitors map[string]*monitor
+	// created by the first monitor of the client
+	notifier *notifier
+	// created by the first lock request of the client
+	locks *lockSession
+	// the key prefixes of the ephemeral rows that the client inserted
+	ephemeral map[string]bool
+	closed    bool
+	// the in-flight transactions of the client, the session is torn down when they complete
+	transactions sync.WaitGroup
+}
+
+// AddClient registers the session of a client connection, and starts the server of the connection on the channel, so
+// the first request of the client is already served with the connection, e.g. a read-only connection can't write.
+// The session is kept until the connection is closed. The clients are not aware of database changes, until they send
+// a set_db_change_aware request.
+func (con *DBServer) AddClient(srv *jrpc2.Server, ch channel.Channel, conn ClientConnection) {
+	con.mu.Lock()
+	cs := con.newSession(srv, conn)
+	cs.registered = true
+	con.mu.Unlock()
+	srv.Start(ch)
+	go con.closeSession(cs)
+}
+
+// session returns the session of the client connection, and creates it for the first request of a connection that
+// wasn't registered by AddClient. It's called with con.mu locked.
+func (con *DBServer) session(srv *jrpc2.Server) *ClientSession {
+	if cs, ok := con.sessions[srv]; ok {
+		return cs
+	}
+	cs := con.newSession(srv, ClientConnection{})
+	go con.closeSession(cs)
+	return cs
+}
+
+// newSession registers a new session of the client connection. It's called with con.mu locked.
+func (con *DBServer) newSession(srv *jrpc2.Server, conn ClientConnection) *ClientSession {
+	cs := &ClientSession{srv: srv, conn: conn, monitors: map[string]*monitor{}}
+	con.sessions[srv] = cs
+	con.metrics.sessions.Add(1)
+	return cs
+}
+
+// closeSession waits for the client connection to be closed, and then tears down its session
+func (con *DBServer) closeSession(cs *ClientSession) {
+	cs.srv.Wait()
+	con.mu.Lock()
+	if con.sessions[cs.srv] == cs {
+		delete(con.sessions, cs.srv)
+	}
+	cs.closed = true
+	monitors, n, ls := cs.monitors, cs.notifier, cs.locks
+	cs.monitors = map[string]*monitor{}
+	con.mu.Unlock()
+	con.metrics.sessions.Add(-1)
+	for _, m := range monitors {
+		m.cache.removeMonitor(m)
+		m.stop()
+		con.metrics.monitors.Add(-1, m.dbName)
+	}
+	if n != nil {
+		n.close()
+	}
+	// the canceled transactions complete quickly, the locks are kept until then
+	cs.transactions.Wait()
+	if ls != nil {
+		ls.close()
+	}
+	con.metrics.sentBytes.Delete(cs.conn.metricsName())
+}
+
+// SetDbChangeAware sets whether the client connection is kept open when databases are added, removed or converted
+func (con *DBServer) SetDbChangeAware(ctx context.Context, aware bool) {
+	srv := jrpc2.ServerFromContext(ctx)
+	con.mu.Lock()
+	defer con.mu.Unlock()
+	con.session(srv).dbChangeAware = aware
+}
+
+// ClientIdentity returns the identity of the client of the request, or nil if the client was not authenticated
+func (con *DBServer) ClientIdentity(ctx context.Context) *ClientIdentity {
+	srv := jrpc2.ServerFromContext(ctx)
+	con.mu.Lock()
+	defer con.mu.Unlock()
+	return con.session(srv).conn.Identity
+}
+
+// isReadOnly returns true if the client of the request connected to a read-only listener
+func (con *DBServer) isReadOnly(ctx context.Context) bool {
+	srv := jrpc2.ServerFromContext(ctx)
+	con.mu.Lock()
+	defer con.mu.Unlock()
+	return con.session(srv).conn.ReadOnly
+}
+
+// beginTransaction registers an in-flight transaction of the client, which Shutdown and the session teardown wait
+// for. It returns an error if the server is shutting down or the connection is closed.
+func (con *DBServer) beginTransaction(ctx context.Context) (*ClientSession, error) {
+	con.mu.Lock()
+	defer con.mu.Unlock()
+	if con.shuttingDown {
+		return nil, fmt.Errorf("server is shutting down")
+	}
+	cs := con.session(jrpc2.ServerFromContext(ctx))
+	if cs.closed {
+		return nil, fmt.Errorf("connection is closed")
+	}
+	con.transactions.Add(1)
+	cs.transactions.Add(1)
+	return cs, nil
+}
+
+func (con *DBServer) endTransaction(cs *ClientSession) {
+	cs.transactions.Done()
+	con.transactions.Done()
+}
@@ -0,0 +1,64 @@
+package ovsdb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/channel"
+	"github.com/creachadair/jrpc2/handler"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ibm/ovsdb-etcd/pkg/klogr"
+	"github.com/ibm/ovsdb-etcd/pkg/stats"
+)
+
+func TestReadOnlyConnection(t *testing.T) {
+	con := &DBServer{log: klogr.New(), sessions: map[*jrpc2.Server]*ClientSession{},
+		metrics: newServerMetrics(stats.NewRegistry())}
+	cch, sch := channel.Direct()
+	srv := jrpc2.NewServer(handler.Map{"transact": handler.New(NewService(con).Transact)},
+		&jrpc2.ServerOptions{AllowV1: true})
+	con.AddClient(srv, sch, ClientConnection{ReadOnly: true})
+	cli := jrpc2.NewClient(cch, &jrpc2.ClientOptions{AllowV1: true})
+	defer cli.Close()
+
+	// the first request of the connection is already checked
+	var result []map[string]interface{}
+	err := cli.CallResult(context.Background(), "transact", []interface{}{"OVN_Northbound",
+		map[string]interface{}{"op": "insert", "table": "ACL", "row": map[string]interface{}{}}}, &result)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(result))
+	assert.Equal(t, "permission error", result[0]["error"])
+}
+
+func TestACLFirstRequest(t *testing.T) {
+	con := &DBServer{log: klogr.New(), sessions: map[*jrpc2.Server]*ClientSession{},
+		metrics: newServerMetrics(stats.NewRegistry())}
+	assert.Nil(t, con.SetACL([]ACLRule{
+		{CommonName: "northd", Databases: []string{"*"}, Operations: []string{ACL_READ, ACL_WRITE}}}))
+	insert := func(conn *ClientConnection) string {
+		cch, sch := channel.Direct()
+		srv := jrpc2.NewServer(handler.Map{"transact": handler.New(NewService(con).Transact)},
+			&jrpc2.ServerOptions{AllowV1: true})
+		if conn != nil {
+			con.AddClient(srv, sch, *conn)
+		} else {
+			srv.Start(sch)
+		}
+		cli := jrpc2.NewClient(cch, &jrpc2.ClientOptions{AllowV1: true})
+		defer cli.Close()
+		var result []map[string]interface{}
+		err := cli.CallResult(context.Background(), "transact", []interface{}{"OVN_Northbound",
+			map[string]interface{}{"op": "insert", "table": "ACL", "row": map[string]interface{}{}}}, &result)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(result))
+		return fmt.Sprint(result[0]["error"], ": ", result[0]["details"])
+	}
+	// the identity of the connection is known from its first request, the insert passes the ACL and isn't supported
+	assert.Contains(t, insert(&ClientConnection{Identity: &ClientIdentity{CommonName: "northd"}}), "not supported")
+	assert.Contains(t, insert(&ClientConnection{Identity: &ClientIdentity{CommonName: "ovn-controller"}}),
+		"permission error: write of OVN_Northbound is not allowed")
+	assert.Contains(t, insert(nil), "the connection is unknown")
+}
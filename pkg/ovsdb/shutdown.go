@@ -0,0 +1,111 @@
+package ovsdb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/creachadair/jrpc2"
+)
+
+// CheckRequest rejects the requests that are received after the server started to shut down, it's used as the
+// CheckRequest option of the JSON-RPC servers
+func (con *DBServer) CheckRequest(ctx context.Context, req *jrpc2.Request) error {
+	con.mu.Lock()
+	defer con.mu.Unlock()
+	if con.shuttingDown {
+		return fmt.Errorf("server is shutting down")
+	}
+	return nil
+}
+
+// Shutdown stops the server gracefully: new requests are rejected, the in-flight transactions complete, the pending
+// monitor notifications are sent, and then the client connections are closed and their locks are released. If the
+// context is done before, the remaining steps are skipped and the connections are closed immediately.
+func (con *DBServer) Shutdown(ctx context.Context) {
+	con.SetReady(false)
+	con.mu.Lock()
+	con.shuttingDown = true
+	con.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		con.transactions.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		con.log.WithName("shutdown").Info("In-flight transactions didn't complete", "error", ctx.Err())
+	}
+
+	con.mu.Lock()
+	monitors := []*monitor{}
+	notifiers := []*notifier{}
+	for _, cs := range con.sessions {
+		for _, m := range cs.monitors {
+			monitors = append(monitors, m)
+		}
+		if cs.notifier != nil {
+			notifiers = append(notifiers, cs.notifier)
+		}
+	}
+	con.mu.Unlock()
+	for _, m := range monitors {
+		m.mu.Lock()
+		if m.flushTimer != nil {
+			m.flushTimer.Stop()
+			m.flushTimer = nil
+		}
+		m.flush()
+		m.mu.Unlock()
+	}
+	for _, n := range notifiers {
+		if err := n.drain(ctx); err != nil {
+			con.log.WithName("shutdown").Info("Notifications weren't sent", "error", err)
+			break
+		}
+	}
+
+	con.mu.Lock()
+	sessions := make([]*ClientSession, 0, len(con.sessions))
+	for _, cs := range con.sessions {
+		sessions = append(sessions, cs)
+	}
+	con.mu.Unlock()
+	for _, cs := range sessions {
+		cs.srv.Stop()
+	}
+	// the lock sessions are closed when the client sessions are torn down, which revokes their leases
+	for _, cs := range sessions {
+		con.mu.Lock()
+		ls := cs.locks
+		con.mu.Unlock()
+		if ls == nil {
+			continue
+		}
+		select {
+		case <-ls.closed:
+		case <-ctx.Done():
+			con.log.WithName("shutdown").Info("Locks weren't released", "error", ctx.Err())
+			return
+		}
+	}
+}
+
+// drain waits until the queued notifications are sent or the notifier is closed
+func (n *notifier) drain(ctx context.Context) error {
+	for {
+		n.mu.Lock()
+		empty := len(n.queue) == 0 || n.closed
+		n.mu.Unlock()
+		if empty {
+			return nil
+		}
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SlowTransactionLimits are the thresholds above which a transaction is logged with a summary of its operations, its
+// results and its etcd requests. A zero threshold is disabled.
+type SlowTransactionLimits struct {
+	Duration time.Duration
+	// the number of operations of the transaction
+	Operations int
+	// the size of the JSON encoding of the operations
+	Bytes int
+}
+
+// SetSlowTransactionLimits sets the thresholds of logging slow and large transactions
+func (con *DBServer) SetSlowTransactionLimits(limits SlowTransactionLimits) {
+	con.slowTransactionLimits = limits
+}
+
+// etcdRequests collect the etcd requests of a transaction, by request type
+type etcdRequests struct {
+	mu        sync.Mutex
+	counts    map[string]int
+	durations map[string]time.Duration
+}
+
+type etcdRequestsKey struct{}
+
+// withEtcdRequests returns a context whose etcd requests are collected
+func withEtcdRequests(ctx context.Context) (context.Context, *etcdRequests) {
+	reqs := &etcdRequests{counts: map[string]int{}, durations: map[string]time.Duration{}}
+	return context.WithValue(ctx, etcdRequestsKey{}, reqs), reqs
+}
+
+// recordEtcdRequest records an etcd request of the context, if its requests are collected
+func recordEtcdRequest(ctx context.Context, name string, duration time.Duration) {
+	reqs, ok := ctx.Value(etcdRequestsKey{}).(*etcdRequests)
+	if !ok {
+		return
+	}
+	reqs.mu.Lock()
+	defer reqs.mu.Unlock()
+	reqs.counts[name]++
+	reqs.durations[name] += duration
+}
+
+func (reqs *etcdRequests) String() string {
+	reqs.mu.Lock()
+	defer reqs.mu.Unlock()
+	names := make([]string, 0, len(reqs.counts))
+	for name := range reqs.counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s:%d/%v", name, reqs.counts[name], reqs.durations[name]))
+	}
+	return strings.Join(parts, ",")
+}
+
+// logSlowTransaction logs a summary of the transaction if it exceeds any of the slow transaction limits: the tables and
+// the types of its operations, the rows of its results, the size of the database cache and the etcd requests
+func (con *DBServer) logSlowTransaction(dbName string, operations []interface{}, result interface{}, err error,
+	reqs *etcdRequests, duration time.Duration) {
+	limits := con.slowTransactionLimits
+	if limits.Duration == 0 && limits.Operations == 0 && limits.Bytes == 0 {
+		return
+	}
+	size := 0
+	if data, err := json.Marshal(operations); err == nil {
+		size = len(data)
+	}
+	if (limits.Duration == 0 || duration < limits.Duration) &&
+		(limits.Operations == 0 || len(operations) < limits.Operations) &&
+		(limits.Bytes == 0 || size < limits.Bytes) {
+		return
+	}
+	opTypes := map[string]int{}
+	tables := map[string]bool{}
+	for _, op := range operations {
+		if valuesMap, ok := op.(map[string]interface{}); ok {
+			opTypes[fmt.Sprint(valuesMap["op"])]++
+			if table, ok := valuesMap["table"].(string); ok {
+				tables[table] = true
+			}
+		}
+	}
+	rows, errors := 0, 0
+	if results, ok := result.([]interface{}); ok {
+		for _, res := range results {
+			switch r := res.(type) {
+			case map[string]string:
+				errors++
+			case map[string]interface{}:
+				if resRows, ok := r["rows"].([]map[string]interface{}); ok {
+					rows += len(resRows)
+				}
+			}
+		}
+	}
+	cacheRows := -1
+	con.cachesMu.Lock()
+	c, ok := con.caches[dbName]
+	con.cachesMu.Unlock()
+	if ok {
+		cacheRows = c.size()
+	}
+	con.log.WithName("transact").Info("Slow transaction", "db", dbName, "duration", duration,
+		"operations", len(operations), "bytes", size, "op", formatCounts(opTypes),
+		"table", strings.Join(sortedKeys(tables), ","), "rows", rows, "errors", errors, "error", err,
+		"cacheRows", cacheRows, "etcd", reqs.String())
+}
+
+func formatCounts(counts map[string]int) string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s:%d", name, counts[name]))
+	}
+	return strings.Join(parts, ",")
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,19 @@
+package ovsdb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtcdRequests(t *testing.T) {
+	recordEtcdRequest(context.Background(), "get", time.Millisecond)
+	ctx, reqs := withEtcdRequests(context.Background())
+	recordEtcdRequest(ctx, "txn", 5*time.Millisecond)
+	recordEtcdRequest(ctx, "get", time.Millisecond)
+	recordEtcdRequest(ctx, "get", 2*time.Millisecond)
+	assert.Equal(t, "get:2/3ms,txn:1/5ms", reqs.String())
+	assert.Equal(t, "delete:1,select:2", formatCounts(map[string]int{"select": 2, "delete": 1}))
+}
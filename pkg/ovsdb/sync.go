@@ -0,0 +1,175 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/creachadair/jrpc2/channel"
+
+	ovsjson "github.com/ibm/ovsdb-etcd/pkg/json"
+)
+
+// the maximal number of operations of the etcd transactions that mirror the upstream changes, the default limit of
+// etcd servers
+const SYNC_MAX_TXN_OPS = 128
+
+// StartSync mirrors the databases of an upstream ovsdb-server into etcd, so an OVN deployment can be migrated to
+// ovsdb-etcd while the ovsdb-server is still active. All the tables of the databases that were added by AddSchema,
+// except _Server, are monitored, their initial contents replace the etcd contents of the databases, and then every
+// upstream change is written to etcd. The connection is restarted when the upstream server is lost or a write fails,
+// until the context is canceled.
+// Changes of more than SYNC_MAX_TXN_OPS columns or SYNC_MAX_TXN_BYTES, including the initial contents, are written by
+// several etcd transactions, so the clients of ovsdb-etcd may see their parts.
+func (con *DBServer) StartSync(ctx context.Context, dial func() (channel.Channel, error)) {
+	go func() {
+		for {
+			if err := con.sync(ctx, dial); err != nil && ctx.Err() == nil {
+				con.log.WithName("sync").Info("Upstream connection failed", "error", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(RELAY_RECONNECT_INTERVAL):
+			}
+		}
+	}()
+}
+
+func (con *DBServer) sync(ctx context.Context, dial func() (channel.Channel, error)) error {
+	ch, err := dial()
+	if err != nil {
+		return err
+	}
+	// the caches keep the upstream rows, so the column diffs of the notifications can be applied
+	caches := map[string]*dbCache{}
+	for dbName, dbSchema := range con.databaseSchemas() {
+		if dbName != "_Server" {
+			caches[dbName] = newDBCache(con.log, dbName, dbSchema)
+		}
+	}
+	failed := make(chan error, 1)
+	log := con.log.WithName("sync")
+	up := newUpstream(log, ch, func(method string, params json.RawMessage) {
+		if method != "update2" {
+			log.V(5).Info("Ignoring a notification", "method", method)
+			return
+		}
+		var p []json.RawMessage
+		var dbName string
+		if err := json.Unmarshal(params, &p); err != nil || len(p) != 2 || json.Unmarshal(p[0], &dbName) != nil {
+			log.Error(err, "Wrong update2 params", "params", string(params))
+			return
+		}
+		c, ok := caches[dbName]
+		if !ok {
+			return
+		}
+		changes, err := c.applyUpdates2(p[1])
+		if err == nil {
+			err = con.mirror(ctx, dbName, c.dbSchema, changes, false)
+		}
+		if err != nil {
+			// the etcd contents are not known anymore, they are replaced when the sync is restarted
+			select {
+			case failed <- fmt.Errorf("%s changes: %v", dbName, err):
+			default:
+			}
+		}
+	})
+	defer up.close()
+	for dbName, c := range caches {
+		var schema ovsjson.DatabaseSchema
+		err := up.call(ctx, "get_schema", []interface{}{dbName}, func(result json.RawMessage) error {
+			return json.Unmarshal(result, &schema)
+		})
+		if err != nil {
+			return fmt.Errorf("schema of %s: %v", dbName, err)
+		}
+		if schema.Version != c.dbSchema.Version {
+			return fmt.Errorf("schema of %s, upstream version %s, local version %s", dbName, schema.Version,
+				c.dbSchema.Version)
+		}
+		requests := map[string]interface{}{}
+		for table := range c.dbSchema.Tables {
+			requests[table] = map[string]interface{}{}
+		}
+		// the initial rows are written by the upstream reader, before the changes that follow them
+		c := c
+		err = up.call(ctx, "monitor_cond", []interface{}{dbName, dbName, requests}, func(result json.RawMessage) error {
+			changes, err := c.applyUpdates2(result)
+			if err != nil {
+				return err
+			}
+			return con.mirror(ctx, dbName, c.dbSchema, changes, true)
+		})
+		if err != nil {
+			return fmt.Errorf("monitor of %s: %v", dbName, err)
+		}
+		log.Info("Database is synchronized", "db", dbName)
+	}
+	select {
+	case <-up.done:
+		return up.err
+	case err := <-failed:
+		return err
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// mirror writes the changes of the database to etcd, columns with default values are not stored. If reset is true,
+// the changes are the whole contents of the database, and they replace the etcd contents.
+func (con *DBServer) mirror(ctx context.Context, dbName string, dbSchema *ovsjson.DatabaseSchema,
+	changes tablesChanges, reset bool) error {
+	prefix := con.dbPrefix(dbName)
+	txn := newTxnBuilder()
+	if reset {
+		txn.addDelete(prefix, true)
+	}
+	for table, tableChanges := range changes {
+		tableSchema := dbSchema.Tables[table]
+		for rowUuid, change := range tableChanges {
+			rowPrefix := prefix + table + "/" + rowUuid + "/"
+			if change.new == nil {
+				if !reset {
+					txn.addDelete(rowPrefix, true)
+				}
+				continue
+			}
+			for colName := range columnsUnion(change.old, change.new) {
+				colSchema, ok := tableSchema.Columns[colName]
+				if !ok || (!reset && reflect.DeepEqual(change.old[colName], change.new[colName])) {
+					continue
+				}
+				stored := false
+				if value, ok := change.new[colName]; ok {
+					encoded, err := encodeValue(value, &colSchema.Type)
+					if err != nil {
+						return fmt.Errorf("column %s of %s/%s: %v", colName, table, rowUuid, err)
+					}
+					defaultEncoded, _ := encodeValue(defaultValue(&colSchema.Type), &colSchema.Type)
+					if encoded != defaultEncoded {
+						if encoded, err = con.storedValue(rowPrefix+colName, encoded); err != nil {
+							return fmt.Errorf("column %s of %s/%s: %v", colName, table, rowUuid, err)
+						}
+						txn.addPut(rowPrefix+colName, encoded)
+						stored = true
+					}
+				}
+				if !stored && !reset {
+					txn.addDelete(rowPrefix+colName, false)
+				}
+			}
+		}
+	}
+	if err := txn.commit(ctx, con); err != nil {
+		return err
+	}
+	for _, tableChanges := range changes {
+		con.metrics.rows.Add(float64(len(tableChanges)), dbName, "mirror")
+	}
+	return nil
+}
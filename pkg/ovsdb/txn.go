@@ -0,0 +1,91 @@
+package ovsdb
+
+import (
+	"context"
+	"strings"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// The maximal size of the operations of an etcd transaction that is committed by a txnBuilder, the default request
+// limit of etcd servers is 1.5 MiB, and the rest is left for the overhead of the request
+const SYNC_MAX_TXN_BYTES = 1024 * 1024
+
+// A txnBuilder collects the puts and deletes of a change that may be too large for a single etcd transaction, and
+// commits them by several transactions, in the order that they were added. Every transaction has at most
+// SYNC_MAX_TXN_OPS operations of at most SYNC_MAX_TXN_BYTES, and it doesn't write a key twice, or put a key that it
+// deletes, which etcd rejects.
+type txnBuilder struct {
+	chunks [][]clientv3.Op
+	// the size, the put keys and the deleted keys and prefixes of the last chunk
+	bytes    int
+	puts     map[string]bool
+	deletes  map[string]bool
+	prefixes []string
+}
+
+func newTxnBuilder() *txnBuilder {
+	return &txnBuilder{}
+}
+
+// addPut adds a put of the key
+func (b *txnBuilder) addPut(key, value string, opts ...clientv3.OpOption) {
+	conflict := b.puts[key] || b.deletes[key] || b.deletedPrefix(key)
+	b.add(clientv3.OpPut(key, value, opts...), len(key)+len(value), conflict)
+	b.puts[key] = true
+}
+
+// addDelete adds a delete of the key, or of all the keys with the prefix if prefix is true
+func (b *txnBuilder) addDelete(key string, prefix bool) {
+	if !prefix {
+		b.add(clientv3.OpDelete(key), len(key), b.puts[key])
+		b.deletes[key] = true
+		return
+	}
+	conflict := false
+	for k := range b.puts {
+		conflict = conflict || strings.HasPrefix(k, key)
+	}
+	b.add(clientv3.OpDelete(key, clientv3.WithPrefix()), len(key), conflict)
+	b.prefixes = append(b.prefixes, key)
+}
+
+func (b *txnBuilder) deletedPrefix(key string) bool {
+	for _, prefix := range b.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// add adds the operation to the last chunk, or to a new chunk if it conflicts with the operations of the last chunk
+// or if the last chunk is full
+func (b *txnBuilder) add(op clientv3.Op, size int, conflict bool) {
+	last := len(b.chunks) - 1
+	if last < 0 || conflict || len(b.chunks[last]) == SYNC_MAX_TXN_OPS || b.bytes+size > SYNC_MAX_TXN_BYTES {
+		b.chunks = append(b.chunks, nil)
+		last++
+		b.bytes = 0
+		b.puts = map[string]bool{}
+		b.deletes = map[string]bool{}
+		b.prefixes = nil
+	}
+	b.chunks[last] = append(b.chunks[last], op)
+	b.bytes += size
+}
+
+// commit commits the operations by one etcd transaction per chunk, it stops at the first transaction that fails
+func (b *txnBuilder) commit(ctx context.Context, con *DBServer) error {
+	for _, ops := range b.chunks {
+		con.metrics.etcdTxnOps.Observe(float64(len(ops)))
+		err := con.retry(ctx, "txn", func(ctx context.Context) error {
+			_, err := con.cli.Txn(ctx).Then(ops...).Commit()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package ovsdb
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxnBuilder(t *testing.T) {
+	b := newTxnBuilder()
+	prefix := "ovsdb/OVN_Northbound/"
+	b.addDelete(prefix, true)
+	// the puts of the deleted prefix are committed by the next transaction
+	b.addPut(prefix+"ACL/u1/action", "allow")
+	b.addPut(prefix+"ACL/u1/priority", "1001")
+	b.addDelete(prefix+"ACL/u2/name", false)
+	assert.Equal(t, 2, len(b.chunks))
+	assert.Equal(t, 1, len(b.chunks[0]))
+	assert.Equal(t, 3, len(b.chunks[1]))
+	b.addPut(prefix+"ACL/u1/action", "drop")
+	b.addDelete(prefix+"ACL/u1/", true)
+	assert.Equal(t, 4, len(b.chunks))
+
+	b = newTxnBuilder()
+	for i := 0; i < SYNC_MAX_TXN_OPS+1; i++ {
+		b.addDelete(prefix+"ACL/u1/"+strings.Repeat("c", i), false)
+	}
+	assert.Equal(t, 2, len(b.chunks))
+	assert.Equal(t, SYNC_MAX_TXN_OPS, len(b.chunks[0]))
+
+	b = newTxnBuilder()
+	large := strings.Repeat("a", SYNC_MAX_TXN_BYTES/2)
+	b.addPut(prefix+"ACL/u1/match", large)
+	b.addPut(prefix+"ACL/u2/match", large)
+	assert.Equal(t, 2, len(b.chunks))
+}
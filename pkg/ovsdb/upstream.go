@@ -0,0 +1,145 @@
+package ovsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/creachadair/jrpc2/channel"
+	"github.com/go-logr/logr"
+)
+
+// An upstream is a JSON-RPC connection to the upstream server. The replies and the notifications of the server are
+// handled by a single reader in the order they are received, so the initial rows of a monitor are always applied
+// before its notifications.
+type upstream struct {
+	ch       channel.Channel
+	onNotify func(method string, params json.RawMessage)
+	log      logr.Logger
+	mu       sync.Mutex
+	nextID   int64
+	// the reply handlers of the outstanding requests, by request id
+	pending map[string]func(*upstreamMessage)
+	// closed when the reader stops, err is the reason
+	done chan struct{}
+	err  error
+}
+
+// a JSON-RPC 1.0 message of the OVSDB protocol
+type upstreamMessage struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  json.RawMessage `json:"error,omitempty"`
+}
+
+func newUpstream(log logr.Logger, ch channel.Channel, onNotify func(method string, params json.RawMessage)) *upstream {
+	up := &upstream{ch: ch, onNotify: onNotify, log: log, pending: map[string]func(*upstreamMessage){},
+		done: make(chan struct{})}
+	go up.read()
+	return up
+}
+
+func (up *upstream) read() {
+	defer close(up.done)
+	for {
+		data, err := up.ch.Recv()
+		if err != nil {
+			up.err = err
+			return
+		}
+		var msg upstreamMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			up.err = fmt.Errorf("wrong message %s: %v", string(data), err)
+			return
+		}
+		noID := len(msg.ID) == 0 || string(msg.ID) == "null"
+		switch {
+		case msg.Method != "" && noID:
+			up.onNotify(msg.Method, msg.Params)
+		case msg.Method == "echo":
+			// the upstream server checks that the relay is alive
+			up.send(&upstreamMessage{ID: msg.ID, Result: msg.Params, Error: json.RawMessage("null")})
+		case msg.Method != "":
+			up.log.V(5).Info("Ignoring a request", "method", msg.Method)
+		default:
+			up.mu.Lock()
+			handler, ok := up.pending[string(msg.ID)]
+			delete(up.pending, string(msg.ID))
+			up.mu.Unlock()
+			if ok {
+				handler(&msg)
+			}
+		}
+	}
+}
+
+// call sends the request, and calls handle with its result in the reader. If the context ends before the reply is
+// received, the request is canceled.
+func (up *upstream) call(ctx context.Context, method string, params interface{},
+	handle func(result json.RawMessage) error) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	reply := make(chan error, 1)
+	up.mu.Lock()
+	up.nextID++
+	id := json.RawMessage(strconv.FormatInt(up.nextID, 10))
+	up.pending[string(id)] = func(msg *upstreamMessage) {
+		if len(msg.Error) > 0 && string(msg.Error) != "null" {
+			var s string
+			if json.Unmarshal(msg.Error, &s) != nil {
+				s = string(msg.Error)
+			}
+			reply <- fmt.Errorf("%s", s)
+			return
+		}
+		reply <- handle(msg.Result)
+	}
+	up.mu.Unlock()
+	if err := up.send(&upstreamMessage{ID: id, Method: method, Params: data}); err != nil {
+		up.forget(id)
+		return err
+	}
+	select {
+	case err := <-reply:
+		return err
+	case <-up.done:
+		up.forget(id)
+		return fmt.Errorf("upstream connection closed: %v", up.err)
+	case <-ctx.Done():
+		if up.forget(id) {
+			up.send(&upstreamMessage{ID: json.RawMessage("null"), Method: "cancel",
+				Params: json.RawMessage("[" + string(id) + "]")})
+		}
+		return ctx.Err()
+	}
+}
+
+// forget removes the reply handler of the request, it returns false if the reply was already handled
+func (up *upstream) forget(id json.RawMessage) bool {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	_, ok := up.pending[string(id)]
+	delete(up.pending, string(id))
+	return ok
+}
+
+func (up *upstream) send(msg *upstreamMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	return up.ch.Send(data)
+}
+
+func (up *upstream) close() {
+	up.ch.Close()
+	<-up.done
+}
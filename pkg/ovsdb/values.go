@@ -0,0 +1,328 @@
+package ovsdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	ovsjson "github.com/ibm/ovsdb-etcd/pkg/json"
+)
+
+// Column values are stored in etcd in the same textual syntax that is used by ovn-nbctl and ovs-vsctl: atoms are
+// either bare words or double quoted strings, sets are written as [a, b, ...] and maps as {k1=v1, k2=v2, ...}.
+
+// decodeValue parses the stored value of a column, and returns it in the OVSDB wire format, according to the column
+// type: an atom, ovsjson.Set, ovsjson.Map or a generic <map> for maps with non string keys or values.
+func decodeValue(value string, colType *ovsjson.ColumnType) (interface{}, error) {
+	value = strings.TrimSpace(value)
+	if colType.IsMap() {
+		return decodeMap(value, colType)
+	}
+	if !colType.IsSet() {
+		if colType.Key.Type == ovsjson.TypeString && !strings.HasPrefix(value, `"`) {
+			// not quoted strings are stored as is
+			return value, nil
+		}
+		tokens, err := tokenize(value)
+		if err != nil {
+			return nil, err
+		}
+		if len(tokens) != 1 || tokens[0].delim {
+			return nil, fmt.Errorf("wrong atom value %q", value)
+		}
+		return decodeAtom(tokens[0].text, colType.Key)
+	}
+	tokens, err := tokenize(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) > 0 && tokens[0].isDelim('[') {
+		if !tokens[len(tokens)-1].isDelim(']') {
+			return nil, fmt.Errorf("wrong set value %q", value)
+		}
+		tokens = tokens[1 : len(tokens)-1]
+	}
+	set := ovsjson.Set{}
+	for i, t := range tokens {
+		if i%2 == 1 {
+			if !t.isDelim(',') {
+				return nil, fmt.Errorf("wrong set value %q", value)
+			}
+			continue
+		}
+		if t.delim {
+			return nil, fmt.Errorf("wrong set value %q", value)
+		}
+		atom, err := decodeAtom(t.text, colType.Key)
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, atom)
+	}
+	if len(set) == 1 {
+		// a set with exactly one element can be represented by the element itself
+		return set[0], nil
+	}
+	return set, nil
+}
+
+func decodeMap(value string, colType *ovsjson.ColumnType) (interface{}, error) {
+	tokens, err := tokenize(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) < 2 || !tokens[0].isDelim('{') || !tokens[len(tokens)-1].isDelim('}') {
+		return nil, fmt.Errorf("wrong map value %q", value)
+	}
+	tokens = tokens[1 : len(tokens)-1]
+	var pairs [][]interface{}
+	for i := 0; i < len(tokens); i += 4 {
+		if i+2 >= len(tokens) || tokens[i].delim || !tokens[i+1].isDelim('=') || tokens[i+2].delim ||
+			(i+3 < len(tokens) && !tokens[i+3].isDelim(',')) {
+			return nil, fmt.Errorf("wrong map value %q", value)
+		}
+		k, err := decodeAtom(tokens[i].text, colType.Key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := decodeAtom(tokens[i+2].text, colType.Value)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, []interface{}{k, v})
+	}
+	if colType.Key.Type == ovsjson.TypeString && colType.Value.Type == ovsjson.TypeString {
+		m := ovsjson.Map{}
+		for _, p := range pairs {
+			m[p[0].(string)] = p[1].(string)
+		}
+		return m, nil
+	}
+	if pairs == nil {
+		pairs = [][]interface{}{}
+	}
+	return []interface{}{"map", pairs}, nil
+}
+
+func decodeAtom(atom string, baseType *ovsjson.BaseType) (interface{}, error) {
+	switch baseType.Type {
+	case ovsjson.TypeString:
+		return atom, nil
+	case ovsjson.TypeInteger:
+		return strconv.ParseInt(atom, 10, 64)
+	case ovsjson.TypeReal:
+		return strconv.ParseFloat(atom, 64)
+	case ovsjson.TypeBoolean:
+		return strconv.ParseBool(atom)
+	case ovsjson.TypeUUID:
+		return ovsjson.Uuid(atom), nil
+	}
+	return nil, fmt.Errorf("unknown atomic type %q", baseType.Type)
+}
+
+// encodeValue returns the stored value of a column, the inverse of decodeValue. The value is in the OVSDB wire
+// format, either typed or generic JSON.
+func encodeValue(value interface{}, colType *ovsjson.ColumnType) (string, error) {
+	v, err := genericValue(value)
+	if err != nil {
+		return "", err
+	}
+	if colType.IsMap() {
+		pairs := wireMapPairs(v)
+		keys := make([]string, 0, len(pairs))
+		for key := range pairs {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		elements := make([]string, 0, len(keys))
+		for _, key := range keys {
+			k, err := encodeAtom(pairs[key][0], colType.Key)
+			if err != nil {
+				return "", err
+			}
+			v, err := encodeAtom(pairs[key][1], colType.Value)
+			if err != nil {
+				return "", err
+			}
+			elements = append(elements, k+"="+v)
+		}
+		return "{" + strings.Join(elements, ", ") + "}", nil
+	}
+	if colType.IsSet() {
+		elements := []string{}
+		for _, e := range wireSetElements(v) {
+			atom, err := encodeAtom(e, colType.Key)
+			if err != nil {
+				return "", err
+			}
+			elements = append(elements, atom)
+		}
+		return "[" + strings.Join(elements, ", ") + "]", nil
+	}
+	if s, ok := v.(string); ok && colType.Key.Type == ovsjson.TypeString {
+		// not quoted strings are stored as is, unless they would be decoded differently
+		if strings.HasPrefix(s, `"`) || strings.TrimSpace(s) != s {
+			return strconv.Quote(s), nil
+		}
+		return s, nil
+	}
+	return encodeAtom(v, colType.Key)
+}
+
+// genericValue returns the generic JSON representation of a value in the OVSDB wire format, as it's decoded from its
+// JSON encoding with json.Number numbers, so large integers keep their precision. The atoms, sets and maps are
+// converted directly, since a JSON round trip of every written column is expensive, and other values by a round trip.
+func genericValue(value interface{}) (interface{}, error) {
+	if v, ok := convertGeneric(value); ok {
+		return v, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// convertGeneric converts the value like genericValue, it returns false if the value has to be converted by a JSON
+// round trip
+func convertGeneric(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case string, bool, json.Number:
+		return v, true
+	case int:
+		return json.Number(strconv.Itoa(v)), true
+	case int64:
+		return json.Number(strconv.FormatInt(v, 10)), true
+	case float64:
+		return floatNumber(v)
+	case ovsjson.Uuid:
+		return []interface{}{"uuid", string(v)}, true
+	case ovsjson.NamedUuid:
+		return []interface{}{"named-uuid", string(v)}, true
+	case ovsjson.Set:
+		elements, ok := convertGenericElements(v)
+		return []interface{}{"set", elements}, ok
+	case ovsjson.Map:
+		pairs := make([]interface{}, 0, len(v))
+		for k, e := range v {
+			pairs = append(pairs, []interface{}{k, e})
+		}
+		return []interface{}{"map", pairs}, true
+	case []interface{}:
+		if v == nil {
+			return nil, true
+		}
+		return convertGenericElements(v)
+	}
+	return nil, false
+}
+
+func convertGenericElements(elements []interface{}) ([]interface{}, bool) {
+	converted := make([]interface{}, len(elements))
+	for i, e := range elements {
+		var ok bool
+		if converted[i], ok = convertGeneric(e); !ok {
+			return nil, false
+		}
+	}
+	return converted, true
+}
+
+// floatNumber returns the number of a float in the format of encoding/json, which doesn't encode infinities and NaN
+func floatNumber(f float64) (interface{}, bool) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return nil, false
+	}
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	s := strconv.FormatFloat(f, format, -1, 64)
+	if n := len(s); format == 'e' && n >= 4 && s[n-4] == 'e' && s[n-3] == '-' && s[n-2] == '0' {
+		// e-09 is written as e-9
+		s = s[:n-2] + s[n-1:]
+	}
+	return json.Number(s), true
+}
+
+// encodeAtom returns the textual syntax of an atom, strings are quoted if they contain delimiters
+func encodeAtom(atom interface{}, baseType *ovsjson.BaseType) (string, error) {
+	switch a := atomValue(atom).(type) {
+	case string:
+		if baseType.Type != ovsjson.TypeString && baseType.Type != ovsjson.TypeUUID {
+			return "", fmt.Errorf("wrong %s atom %q", baseType.Type, a)
+		}
+		if len(a) == 0 || strings.ContainsAny(a, "[]{}=, \t\n\"\\") {
+			return strconv.Quote(a), nil
+		}
+		return a, nil
+	case json.Number:
+		if baseType.Type != ovsjson.TypeInteger && baseType.Type != ovsjson.TypeReal {
+			return "", fmt.Errorf("wrong %s atom %s", baseType.Type, a)
+		}
+		return a.String(), nil
+	case bool:
+		if baseType.Type != ovsjson.TypeBoolean {
+			return "", fmt.Errorf("wrong %s atom %v", baseType.Type, a)
+		}
+		return strconv.FormatBool(a), nil
+	}
+	return "", fmt.Errorf("wrong atom %v", atom)
+}
+
+type token struct {
+	text  string
+	delim bool
+}
+
+func (t token) isDelim(c byte) bool {
+	return t.delim && t.text[0] == c
+}
+
+// tokenize splits a stored value into atoms and delimiters, quoted atoms are unquoted
+func tokenize(value string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(value); {
+		c := value[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case strings.IndexByte("[]{}=,", c) >= 0:
+			tokens = append(tokens, token{text: value[i : i+1], delim: true})
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(value) && value[j] != '"'; j++ {
+				if value[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(value) {
+				return nil, fmt.Errorf("unterminated string in %q", value)
+			}
+			s, err := strconv.Unquote(value[i : j+1])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{text: s})
+			i = j + 1
+		default:
+			j := i
+			for ; j < len(value) && strings.IndexByte("[]{}=, \t\n\"", value[j]) < 0; j++ {
+			}
+			tokens = append(tokens, token{text: value[i:j]})
+			i = j
+		}
+	}
+	return tokens, nil
+}
@@ -0,0 +1,143 @@
+package ovsdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	ovsjson "github.com/ibm/ovsdb-etcd/pkg/json"
+)
+
+var stringType = &ovsjson.BaseType{Type: ovsjson.TypeString}
+var integerType = &ovsjson.BaseType{Type: ovsjson.TypeInteger}
+var uuidType = &ovsjson.BaseType{Type: ovsjson.TypeUUID}
+
+func testDecode(t *testing.T, value string, colType ovsjson.ColumnType, expected string) {
+	v, err := decodeValue(value, &colType)
+	assert.Nil(t, err)
+	b, err := json.Marshal(v)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, string(b), "decoding "+value)
+}
+
+func TestDecodeAtoms(t *testing.T) {
+	testDecode(t, "allow-related", ovsjson.ColumnType{Key: stringType, Min: 1, Max: 1}, `"allow-related"`)
+	testDecode(t, `"ip4.src == \"10.0.0.1\""`, ovsjson.ColumnType{Key: stringType, Min: 1, Max: 1},
+		`"ip4.src == \"10.0.0.1\""`)
+	testDecode(t, "1001", ovsjson.ColumnType{Key: integerType, Min: 1, Max: 1}, `1001`)
+	testDecode(t, "false", ovsjson.ColumnType{Key: &ovsjson.BaseType{Type: ovsjson.TypeBoolean}, Min: 1, Max: 1},
+		`false`)
+}
+
+func TestDecodeSets(t *testing.T) {
+	setType := ovsjson.ColumnType{Key: uuidType, Min: 0, Max: ovsjson.Unlimited}
+	testDecode(t, "[]", setType, `["set",[]]`)
+	testDecode(t, "[413afe3e-79ff-4583-88a6-f02b70b8e927]", setType,
+		`["uuid","413afe3e-79ff-4583-88a6-f02b70b8e927"]`)
+	testDecode(t, "[25f2e69e-4bac-4529-9082-9f94da060cf1, 73000cf3-73d0-4283-8aad-bcf181626a40]", setType,
+		`["set",[["uuid","25f2e69e-4bac-4529-9082-9f94da060cf1"],["uuid","73000cf3-73d0-4283-8aad-bcf181626a40"]]]`)
+	testDecode(t, `["10.244.0.3", "10.244.0.4"]`, ovsjson.ColumnType{Key: stringType, Min: 0, Max: ovsjson.Unlimited},
+		`["set",["10.244.0.3","10.244.0.4"]]`)
+}
+
+func TestDecodeMaps(t *testing.T) {
+	testDecode(t, `{name=default_v4}`, ovsjson.ColumnType{Key: stringType, Value: stringType, Min: 0,
+		Max: ovsjson.Unlimited}, `["map",[["name","default_v4"]]]`)
+	testDecode(t, `{}`, ovsjson.ColumnType{Key: stringType, Value: stringType, Min: 0, Max: ovsjson.Unlimited},
+		`["map",[]]`)
+	testDecode(t, `{rate=10}`, ovsjson.ColumnType{Key: stringType, Value: integerType, Min: 0, Max: ovsjson.Unlimited},
+		`["map",[["rate",10]]]`)
+}
+
+func TestDecodeErrors(t *testing.T) {
+	mapType := ovsjson.ColumnType{Key: stringType, Value: stringType, Min: 0, Max: ovsjson.Unlimited}
+	for _, value := range []string{`{a=}`, `{a=b`, `[a, b]`, `{"a=b}`} {
+		_, err := decodeValue(value, &mapType)
+		assert.NotNil(t, err, "decoding "+value)
+	}
+	_, err := decodeValue("abc", &ovsjson.ColumnType{Key: integerType, Min: 1, Max: 1})
+	assert.NotNil(t, err)
+}
+
+func testEncode(t *testing.T, value string, colType ovsjson.ColumnType, expected string) {
+	var v interface{}
+	assert.Nil(t, json.Unmarshal([]byte(value), &v))
+	encoded, err := encodeValue(v, &colType)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, encoded, "encoding "+value)
+	if !colType.IsMap() {
+		testDecode(t, encoded, colType, value)
+	}
+	// the maps are decoded to Go maps, whose JSON order isn't fixed, so the decoded values are compared by their
+	// encodings
+	decoded, err := decodeValue(encoded, &colType)
+	assert.Nil(t, err)
+	reencoded, err := encodeValue(decoded, &colType)
+	assert.Nil(t, err)
+	assert.Equal(t, encoded, reencoded, "decoding "+encoded)
+}
+
+func TestEncodeValues(t *testing.T) {
+	testEncode(t, `"allow-related"`, ovsjson.ColumnType{Key: stringType, Min: 1, Max: 1}, "allow-related")
+	testEncode(t, `"\"quoted\""`, ovsjson.ColumnType{Key: stringType, Min: 1, Max: 1}, `"\"quoted\""`)
+	testEncode(t, `1001`, ovsjson.ColumnType{Key: integerType, Min: 1, Max: 1}, "1001")
+	testEncode(t, `["set",[["uuid","25f2e69e-4bac-4529-9082-9f94da060cf1"],["uuid","73000cf3-73d0-4283-8aad-bcf181626a40"]]]`,
+		ovsjson.ColumnType{Key: uuidType, Min: 0, Max: ovsjson.Unlimited},
+		"[25f2e69e-4bac-4529-9082-9f94da060cf1, 73000cf3-73d0-4283-8aad-bcf181626a40]")
+	testEncode(t, `["set",["10.244.0.3","a b"]]`, ovsjson.ColumnType{Key: stringType, Min: 0, Max: ovsjson.Unlimited},
+		`[10.244.0.3, "a b"]`)
+	testEncode(t, `["map",[["mac_prefix","86:a9:cb"],["name","x=y"]]]`, ovsjson.ColumnType{Key: stringType,
+		Value: stringType, Min: 0, Max: ovsjson.Unlimited}, `{mac_prefix=86:a9:cb, name="x=y"}`)
+	testEncode(t, `["map",[]]`, ovsjson.ColumnType{Key: stringType, Value: stringType, Min: 0, Max: ovsjson.Unlimited},
+		`{}`)
+}
+
+func TestGenericValue(t *testing.T) {
+	for _, value := range []interface{}{"a", true, json.Number("12345678901234567890"), 42, int64(-7), 0.5, 1e21,
+		1e-7, 3.0, ovsjson.Uuid("u1"), ovsjson.NamedUuid("n1"), ovsjson.Set{}, ovsjson.Set{ovsjson.Uuid("u1"), 2.5},
+		ovsjson.Map{}, ovsjson.Map{"k": "v"}, []interface{}{"set", []interface{}{"a", 1.0}}, []interface{}(nil),
+		map[string]interface{}{"k": "v"}} {
+		data, err := json.Marshal(value)
+		assert.Nil(t, err)
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		var expected interface{}
+		assert.Nil(t, decoder.Decode(&expected))
+		v, err := genericValue(value)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, v, "converting %#v", value)
+	}
+}
+
+func BenchmarkEncodeValue(b *testing.B) {
+	mapType := ovsjson.ColumnType{Key: stringType, Value: stringType, Min: 0, Max: ovsjson.Unlimited}
+	setType := ovsjson.ColumnType{Key: uuidType, Min: 0, Max: ovsjson.Unlimited}
+	externalIds := ovsjson.Map{"iface-id": "lsp1", "attached-mac": "0a:58:0a:f4:00:03", "ip-addresses": "10.244.0.3/24"}
+	ports := ovsjson.Set{ovsjson.Uuid("25f2e69e-4bac-4529-9082-9f94da060cf1"),
+		ovsjson.Uuid("73000cf3-73d0-4283-8aad-bcf181626a40")}
+	for i := 0; i < b.N; i++ {
+		if _, err := encodeValue(externalIds, &mapType); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := encodeValue(ports, &setType); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeValue(b *testing.B) {
+	mapType := ovsjson.ColumnType{Key: stringType, Value: stringType, Min: 0, Max: ovsjson.Unlimited}
+	setType := ovsjson.ColumnType{Key: uuidType, Min: 0, Max: ovsjson.Unlimited}
+	for i := 0; i < b.N; i++ {
+		if _, err := decodeValue(`{attached-mac="0a:58:0a:f4:00:03", iface-id=lsp1, ip-addresses="10.244.0.3/24"}`,
+			&mapType); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := decodeValue("[25f2e69e-4bac-4529-9082-9f94da060cf1, 73000cf3-73d0-4283-8aad-bcf181626a40]",
+			&setType); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+// Package stats keeps the counters, gauges and histograms of the server, and writes them in the Prometheus text
+// exposition format.
+package stats
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The default buckets of latency histograms, in seconds
+var LATENCY_BUCKETS = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// The default buckets of size histograms, e.g. of operations or rows
+var SIZE_BUCKETS = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 5000, 10000}
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// Registry is a set of metrics, which can be written together
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]*metric
+}
+
+// metric is a counter, a gauge or a histogram, with the values of all its label values
+type metric struct {
+	name       string
+	help       string
+	typ        string
+	labelNames []string
+	buckets    []float64
+	mu         sync.Mutex
+	// by the label values, joined by 0 bytes
+	values map[string]*value
+}
+
+type value struct {
+	labelValues []string
+	// the value of counters and gauges, the sum of histograms
+	v float64
+	// the cumulative counts of the histogram buckets, and the count of all the observations
+	counts []uint64
+	count  uint64
+}
+
+// Counter is a counter with labels
+type Counter struct{ m *metric }
+
+// Gauge is a gauge with labels
+type Gauge struct{ m *metric }
+
+// Histogram is a histogram with labels
+type Histogram struct{ m *metric }
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: map[string]*metric{}}
+}
+
+func (r *Registry) register(name, help, typ string, buckets []float64, labelNames []string) *metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.metrics[name]; ok {
+		if m.typ != typ || len(m.labelNames) != len(labelNames) {
+			panic(fmt.Sprintf("metric %s is already registered as a %s", name, m.typ))
+		}
+		return m
+	}
+	m := &metric{name: name, help: help, typ: typ, labelNames: labelNames, buckets: buckets,
+		values: map[string]*value{}}
+	r.metrics[name] = m
+	return m
+}
+
+// Counter registers a counter, or returns the registered counter of the name
+func (r *Registry) Counter(name, help string, labelNames ...string) *Counter {
+	return &Counter{m: r.register(name, help, typeCounter, nil, labelNames)}
+}
+
+// Gauge registers a gauge, or returns the registered gauge of the name
+func (r *Registry) Gauge(name, help string, labelNames ...string) *Gauge {
+	return &Gauge{m: r.register(name, help, typeGauge, nil, labelNames)}
+}
+
+// Histogram registers a histogram with the upper bounds of its buckets, in increasing order, or returns the
+// registered histogram of the name
+func (r *Registry) Histogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	return &Histogram{m: r.register(name, help, typeHistogram, buckets, labelNames)}
+}
+
+// value returns the value of the label values, it's called with m.mu locked
+func (m *metric) value(labelValues []string) *value {
+	if len(labelValues) != len(m.labelNames) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", m.name, len(m.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\x00")
+	v, ok := m.values[key]
+	if !ok {
+		v = &value{labelValues: append([]string{}, labelValues...)}
+		if m.typ == typeHistogram {
+			v.counts = make([]uint64, len(m.buckets))
+		}
+		m.values[key] = v
+	}
+	return v
+}
+
+// Add adds delta, which is not negative, to the counter of the label values
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	c.m.mu.Lock()
+	c.m.value(labelValues).v += delta
+	c.m.mu.Unlock()
+}
+
+// Inc increments the counter of the label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Delete removes the counter of the label values, e.g. of a client that disconnected
+func (c *Counter) Delete(labelValues ...string) {
+	c.m.delete(labelValues)
+}
+
+// Set sets the gauge of the label values
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.m.mu.Lock()
+	g.m.value(labelValues).v = v
+	g.m.mu.Unlock()
+}
+
+// Add adds delta to the gauge of the label values
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.m.mu.Lock()
+	g.m.value(labelValues).v += delta
+	g.m.mu.Unlock()
+}
+
+// Delete removes the gauge of the label values, e.g. of a client that disconnected
+func (g *Gauge) Delete(labelValues ...string) {
+	g.m.delete(labelValues)
+}
+
+func (m *metric) delete(labelValues []string) {
+	m.mu.Lock()
+	delete(m.values, strings.Join(labelValues, "\x00"))
+	m.mu.Unlock()
+}
+
+// Observe adds an observation to the histogram of the label values
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.m.mu.Lock()
+	defer h.m.mu.Unlock()
+	hv := h.m.value(labelValues)
+	for i, bound := range h.m.buckets {
+		if v <= bound {
+			hv.counts[i]++
+		}
+	}
+	hv.count++
+	hv.v += v
+}
+
+// WriteText writes the metrics in the Prometheus text exposition format, sorted by name and label values
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	metrics := make([]*metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		metrics = append(metrics, m)
+	}
+	r.mu.Unlock()
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name < metrics[j].name })
+	var b strings.Builder
+	for _, m := range metrics {
+		m.write(&b)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (m *metric) write(b *strings.Builder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", m.name, escape(m.help, false), m.name, m.typ)
+	keys := make([]string, 0, len(m.values))
+	for key := range m.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		v := m.values[key]
+		if m.typ != typeHistogram {
+			fmt.Fprintf(b, "%s%s %s\n", m.name, m.labels(v.labelValues, ""), formatFloat(v.v))
+			continue
+		}
+		for i, bound := range m.buckets {
+			fmt.Fprintf(b, "%s_bucket%s %d\n", m.name, m.labels(v.labelValues, formatFloat(bound)), v.counts[i])
+		}
+		fmt.Fprintf(b, "%s_bucket%s %d\n", m.name, m.labels(v.labelValues, "+Inf"), v.count)
+		fmt.Fprintf(b, "%s_sum%s %s\n", m.name, m.labels(v.labelValues, ""), formatFloat(v.v))
+		fmt.Fprintf(b, "%s_count%s %d\n", m.name, m.labels(v.labelValues, ""), v.count)
+	}
+}
+
+// labels returns the labels of the values, with the "le" label of a histogram bucket if it's not empty
+func (m *metric) labels(labelValues []string, le string) string {
+	pairs := make([]string, 0, len(labelValues)+1)
+	for i, name := range m.labelNames {
+		pairs = append(pairs, name+`="`+escape(labelValues[i], true)+`"`)
+	}
+	if len(le) > 0 {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escape(s string, quotes bool) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	if quotes {
+		s = strings.ReplaceAll(s, `"`, `\"`)
+	}
+	return s
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Handler serves the metrics in the Prometheus text exposition format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.WriteText(w)
+	})
+}
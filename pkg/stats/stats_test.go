@@ -0,0 +1,48 @@
+package stats
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("ovsdb_transactions_total", "Transactions.", "op", "result")
+	c.Inc("select", "ok")
+	c.Add(2, "select", "ok")
+	c.Inc("insert", "permission \"error\"")
+	g := r.Gauge("ovsdb_sessions", "Client sessions.")
+	g.Set(3)
+	h := r.Histogram("ovsdb_etcd_latency_seconds", "Etcd latency.", []float64{0.1, 1}, "request")
+	h.Observe(0.05, "get")
+	h.Observe(0.5, "get")
+	h.Observe(5, "get")
+
+	var b strings.Builder
+	assert.Nil(t, r.WriteText(&b))
+	assert.Equal(t, `# HELP ovsdb_etcd_latency_seconds Etcd latency.
+# TYPE ovsdb_etcd_latency_seconds histogram
+ovsdb_etcd_latency_seconds_bucket{request="get",le="0.1"} 1
+ovsdb_etcd_latency_seconds_bucket{request="get",le="1"} 2
+ovsdb_etcd_latency_seconds_bucket{request="get",le="+Inf"} 3
+ovsdb_etcd_latency_seconds_sum{request="get"} 5.55
+ovsdb_etcd_latency_seconds_count{request="get"} 3
+# HELP ovsdb_sessions Client sessions.
+# TYPE ovsdb_sessions gauge
+ovsdb_sessions 3
+# HELP ovsdb_transactions_total Transactions.
+# TYPE ovsdb_transactions_total counter
+ovsdb_transactions_total{op="insert",result="permission \"error\""} 1
+ovsdb_transactions_total{op="select",result="ok"} 3
+`, b.String())
+
+	g.Delete()
+	c.Delete("select", "ok")
+	b.Reset()
+	assert.Nil(t, r.WriteText(&b))
+	assert.NotContains(t, b.String(), "ovsdb_sessions 3")
+	assert.NotContains(t, b.String(), `result="ok"`)
+	assert.Equal(t, c, r.Counter("ovsdb_transactions_total", "Transactions.", "op", "result"))
+}
@@ -0,0 +1,154 @@
+// Package trace records the spans of the requests of the server, from the JSON-RPC requests through the OVSDB
+// operations to the etcd requests, and exports all the spans of a trace when its root span ends. The trace and span ids
+// follow the W3C trace context, so the traces can be correlated with the traces of other components.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"sync"
+	"time"
+)
+
+// Span is a timed operation of a trace
+type Span struct {
+	TraceID  string    `json:"traceId"`
+	SpanID   string    `json:"spanId"`
+	ParentID string    `json:"parentSpanId,omitempty"`
+	Name     string    `json:"name"`
+	Start    time.Time `json:"start"`
+	// in nanoseconds
+	Duration   time.Duration     `json:"duration"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"`
+
+	trace *traceSpans
+	ended bool
+}
+
+// traceSpans are the ended spans of a trace, which are exported when the root span ends
+type traceSpans struct {
+	tracer *Tracer
+	mu     sync.Mutex
+	spans  []Span
+}
+
+// Exporter exports the spans of a trace, the root span is the last one
+type Exporter func(spans []Span)
+
+// Tracer starts the root spans of the traces, and exports the traces that are sampled
+type Tracer struct {
+	// the fraction of the traces that are recorded, between 0 and 1
+	sampleRatio float64
+	// the traces whose root span is shorter are not exported
+	minDuration time.Duration
+	export      Exporter
+}
+
+type spanKey struct{}
+
+// NewTracer returns a tracer that records the sampleRatio fraction of the traces, and exports the traces that last at
+// least minDuration
+func NewTracer(sampleRatio float64, minDuration time.Duration, export Exporter) *Tracer {
+	return &Tracer{sampleRatio: sampleRatio, minDuration: minDuration, export: export}
+}
+
+// WriterExporter writes every trace as a single line of a JSON array of its spans
+func WriterExporter(w io.Writer) Exporter {
+	var mu sync.Mutex
+	return func(spans []Span) {
+		data, err := json.Marshal(spans)
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write(append(data, '\n'))
+	}
+}
+
+// Start starts the root span of a trace, if the trace is sampled. The span is nil otherwise, and then the spans of
+// the returned context are not recorded.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	if t == nil || mathrand.Float64() >= t.sampleRatio {
+		return ctx, nil
+	}
+	s := &Span{TraceID: newID(16), SpanID: newID(8), Name: name, Start: time.Now(), trace: &traceSpans{tracer: t}}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Start starts a child span of the span of the context. The span is nil if the context is not traced.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	parent := FromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	s := &Span{TraceID: parent.TraceID, SpanID: newID(8), ParentID: parent.SpanID, Name: name, Start: time.Now(),
+		trace: parent.trace}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// FromContext returns the span of the context, or nil if the context is not traced
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// SetAttribute sets an attribute of the span, it's a no-op for nil spans
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.trace.mu.Lock()
+	defer s.trace.mu.Unlock()
+	if s.Attributes == nil {
+		s.Attributes = map[string]string{}
+	}
+	s.Attributes[key] = fmt.Sprint(value)
+}
+
+// End ends the span with the error of its operation, if any. The trace is exported when its root span ends, the
+// spans that end later are dropped.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	t := s.trace
+	t.mu.Lock()
+	if s.ended {
+		t.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.Duration = time.Since(s.Start)
+	if err != nil {
+		s.Error = err.Error()
+	}
+	t.spans = append(t.spans, *s)
+	if len(s.ParentID) > 0 {
+		t.mu.Unlock()
+		return
+	}
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+	if s.Duration >= t.tracer.minDuration {
+		t.tracer.export(spans)
+	}
+}
+
+// Traceparent returns the W3C traceparent header of the span, which propagates the trace to other components
+func (s *Span) Traceparent() string {
+	return "00-" + s.TraceID + "-" + s.SpanID + "-01"
+}
+
+func newID(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,47 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpans(t *testing.T) {
+	var exported [][]Span
+	tracer := NewTracer(1, 0, func(spans []Span) { exported = append(exported, spans) })
+	ctx, root := tracer.Start(context.Background(), "rpc transact")
+	assert.NotNil(t, root)
+	_, child := Start(ctx, "etcd get")
+	child.SetAttribute("attempt", 1)
+	child.End(fmt.Errorf("etcdserver: leader changed"))
+	assert.Equal(t, 0, len(exported))
+	root.End(nil)
+	root.End(nil)
+	assert.Equal(t, 1, len(exported))
+	spans := exported[0]
+	assert.Equal(t, 2, len(spans))
+	assert.Equal(t, "etcd get", spans[0].Name)
+	assert.Equal(t, root.TraceID, spans[0].TraceID)
+	assert.Equal(t, root.SpanID, spans[0].ParentID)
+	assert.Equal(t, "1", spans[0].Attributes["attempt"])
+	assert.Equal(t, "etcdserver: leader changed", spans[0].Error)
+	assert.Equal(t, "rpc transact", spans[1].Name)
+	assert.Equal(t, 55, len(root.Traceparent()))
+
+	_, span := Start(context.Background(), "etcd get")
+	assert.Nil(t, span)
+	span.SetAttribute("attempt", 1)
+	span.End(nil)
+
+	_, span = NewTracer(0, 0, nil).Start(context.Background(), "rpc transact")
+	assert.Nil(t, span)
+
+	exported = nil
+	_, span = NewTracer(1, time.Hour, func(spans []Span) { exported = append(exported, spans) }).Start(
+		context.Background(), "rpc transact")
+	span.End(nil)
+	assert.Equal(t, 0, len(exported))
+}